@@ -0,0 +1,224 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"bbb/config"
+)
+
+// Account is a Bluesky account the bot signs in as, and the one thing it does
+// when it's mentioned.
+type Account struct {
+	Handle   string
+	DID      string
+	Password string
+
+	// Behaviour is one of config.BehaviourCommands, config.BehaviourAltText or config.BehaviourCommunity
+	Behaviour string
+
+	// Bot is the community bot the account runs, when its behaviour is config.BehaviourCommunity
+	Bot string
+
+	Settings Settings
+}
+
+// Settings for a single account, with the global values filled in for
+// anything the account doesn't override.
+type Settings struct {
+	DryRun        bool
+	JobWaitTime   int
+	AltTextPrompt string
+}
+
+// Registry maps every configured account to its behaviour.
+type Registry struct {
+	accounts []Account
+
+	// disabled accounts run community bots that couldn't be loaded
+	disabled []Account
+
+	// DIDs are learned as accounts sign in, if they weren't configured
+	mutex sync.RWMutex
+	dids  map[string]string
+}
+
+// New builds the registry from the configuration. Every community account must
+// name a bot in communityBots, and every bot in communityBots must have an
+// account, so no bot is left silently unreachable. Accounts for the bots in
+// brokenBots, which exist but couldn't be loaded, are disabled until they're
+// fixed.
+func New(cfg config.Config, communityBots, brokenBots []string) (*Registry, error) {
+
+	registry := &Registry{
+		dids: map[string]string{},
+	}
+
+	errs := []error{}
+	botAccounts := map[string][]string{}
+
+	for _, configured := range cfg.Bluesky.Accounts {
+
+		account := Account{
+			Handle:    configured.Username,
+			DID:       configured.DID,
+			Password:  configured.Password,
+			Behaviour: configured.Behaviour,
+			Bot:       configured.Bot,
+			Settings: Settings{
+				DryRun:        cfg.Bluesky.DryRun,
+				JobWaitTime:   cfg.Bacalhau.JobWaitTime,
+				AltTextPrompt: cfg.Jobs.AltTextPrompt,
+			},
+		}
+
+		if configured.Settings.DryRun != nil {
+			account.Settings.DryRun = *configured.Settings.DryRun
+		}
+
+		if configured.Settings.JobWaitTime > 0 {
+			account.Settings.JobWaitTime = configured.Settings.JobWaitTime
+		}
+
+		if configured.Settings.AltTextPrompt != "" {
+			account.Settings.AltTextPrompt = configured.Settings.AltTextPrompt
+		}
+
+		if account.Behaviour == config.BehaviourCommunity && contains(brokenBots, account.Bot) {
+			registry.disabled = append(registry.disabled, account)
+			continue
+		}
+
+		if account.Behaviour == config.BehaviourCommunity {
+			if !contains(communityBots, account.Bot) {
+				errs = append(errs, fmt.Errorf(`account %s runs the community bot "%s", but there's no such bot in ./community`, account.Handle, account.Bot))
+			}
+			botAccounts[account.Bot] = append(botAccounts[account.Bot], account.Handle)
+		}
+
+		if account.DID != "" {
+			registry.dids[account.Handle] = account.DID
+		}
+
+		registry.accounts = append(registry.accounts, account)
+
+	}
+
+	for _, bot := range communityBots {
+		handles := botAccounts[bot]
+
+		if len(handles) == 0 {
+			errs = append(errs, fmt.Errorf(`community bot "%s" has no account. Add an account with behaviour %s and bot %s`, bot, config.BehaviourCommunity, bot))
+		}
+
+		if len(handles) > 1 {
+			errs = append(errs, fmt.Errorf(`community bot "%s" is run by more than one account: %s`, bot, strings.Join(handles, ", ")))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+
+}
+
+// All returns every account in the registry.
+func (r *Registry) All() []Account {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	all := make([]Account, len(r.accounts))
+	for index, account := range r.accounts {
+		account.DID = r.dids[account.Handle]
+		all[index] = account
+	}
+
+	return all
+
+}
+
+// Disabled returns the accounts that aren't used because their community bot
+// couldn't be loaded.
+func (r *Registry) Disabled() []Account {
+	return append([]Account{}, r.disabled...)
+}
+
+// ForBot returns the account that runs the named community bot.
+func (r *Registry) ForBot(name string) (Account, bool) {
+
+	for _, account := range r.All() {
+		if account.Behaviour == config.BehaviourCommunity && account.Bot == name {
+			return account, true
+		}
+	}
+
+	return Account{}, false
+
+}
+
+// ForDID returns the account with the given DID, once it's configured or the
+// account has signed in.
+func (r *Registry) ForDID(did string) (Account, bool) {
+
+	for _, account := range r.All() {
+		if account.DID != "" && account.DID == did {
+			return account, true
+		}
+	}
+
+	return Account{}, false
+
+}
+
+// SignedIn records the DID of the session an account signed in with. If the
+// account was configured with a different DID, the credentials belong to
+// another account and an error is returned.
+func (r *Registry) SignedIn(handle, did string) error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if configured, exists := r.dids[handle]; exists && configured != did {
+		return fmt.Errorf("signed in as %s, but %s is configured with the DID %s", did, handle, configured)
+	}
+
+	r.dids[handle] = did
+
+	return nil
+
+}
+
+// Inherit copies the DIDs that accounts in previous learned when they signed
+// in, so that a reloaded registry can match sessions to accounts straight away.
+func (r *Registry) Inherit(previous *Registry) {
+
+	previous.mutex.RLock()
+	defer previous.mutex.RUnlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, account := range r.accounts {
+		if _, exists := r.dids[account.Handle]; exists {
+			continue
+		}
+		if did, learned := previous.dids[account.Handle]; learned {
+			r.dids[account.Handle] = did
+		}
+	}
+
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package accounts
+
+import (
+	"strings"
+	"testing"
+
+	"bbb/config"
+)
+
+func TestNew(t *testing.T) {
+
+	account := func(username, bot string) config.Account {
+		return config.Account{Username: username, Password: "x", Behaviour: config.BehaviourCommunity, Bot: bot}
+	}
+
+	tests := []struct {
+		name string
+		accounts []config.Account
+		bots []string
+		broken []string
+		wantAccounts int
+		wantDisabled int
+		wantErr string
+	}{
+		{"every bot has an account", []config.Account{account("a.bots", "a"), account("b.bots", "b")}, []string{"a", "b"}, nil, 2, 0, ""},
+		{"broken bots disable their account", []config.Account{account("a.bots", "a"), account("b.bots", "b")}, []string{"a"}, []string{"b"}, 1, 1, ""},
+		{"account for a bot that doesn't exist", []config.Account{account("a.bots", "a"), account("typo.bots", "typo")}, []string{"a"}, nil, 0, 0, `there's no such bot`},
+		{"bot without an account", []config.Account{account("a.bots", "a")}, []string{"a", "b"}, nil, 0, 0, `"b" has no account`},
+		{"bot with two accounts", []config.Account{account("a.bots", "a"), account("a2.bots", "a")}, []string{"a"}, nil, 0, 0, "more than one account"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			cfg := config.Default()
+			cfg.Bluesky.Accounts = test.accounts
+
+			registry, err := New(cfg, test.bots, test.broken)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(registry.All()) != test.wantAccounts || len(registry.Disabled()) != test.wantDisabled {
+				t.Errorf("got %d accounts and %d disabled, want %d and %d", len(registry.All()), len(registry.Disabled()), test.wantAccounts, test.wantDisabled)
+			}
+
+		})
+	}
+
+}
+
+func TestSignedIn(t *testing.T) {
+
+	cfg := config.Default()
+	cfg.Bluesky.Accounts = []config.Account{
+		{Username: "commands.bots", Password: "x", Behaviour: config.BehaviourCommands, DID: "did:plc:configured"},
+		{Username: "alt-text.bots", Password: "x", Behaviour: config.BehaviourAltText},
+	}
+
+	registry, err := New(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.SignedIn("commands.bots", "did:plc:other"); err == nil {
+		t.Error("expected an error for a DID that doesn't match the configured one")
+	}
+
+	if err := registry.SignedIn("alt-text.bots", "did:plc:learned"); err != nil {
+		t.Fatal(err)
+	}
+
+	if account, found := registry.ForDID("did:plc:learned"); !found || account.Handle != "alt-text.bots" {
+		t.Errorf("got %+v, %v", account, found)
+	}
+
+	next, _ := New(cfg, nil, nil)
+	next.Inherit(registry)
+
+	if _, found := next.ForDID("did:plc:learned"); !found {
+		t.Error("expected the reloaded registry to inherit the learned DID")
+	}
+
+}
@@ -0,0 +1,151 @@
+package annotate
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+
+	"bbb/bacalhau"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var palette = []color.RGBA{
+	{R: 0, G: 85, B: 255, A: 255},
+	{R: 255, G: 56, B: 56, A: 255},
+	{R: 255, G: 157, B: 0, A: 255},
+	{R: 0, G: 200, B: 120, A: 255},
+	{R: 178, G: 0, B: 255, A: 255},
+	{R: 0, G: 190, B: 220, A: 255},
+	{R: 255, G: 0, B: 170, A: 255},
+	{R: 140, G: 200, B: 0, A: 255},
+}
+
+// DrawDetections decodes the original image, draws a box and a label for each
+// detection that carries a bounding box, and returns the result as a JPEG.
+func DrawDetections(imageData []byte, detections []bacalhau.Detection) ([]byte, error) {
+
+	source, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %w", err)
+	}
+
+	bounds := source.Bounds()
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, source, bounds.Min, draw.Src)
+
+	// Scale line and label sizes with the image so they stay legible on
+	// both thumbnails and full-size images.
+	shortestSide := bounds.Dx()
+	if bounds.Dy() < shortestSide {
+		shortestSide = bounds.Dy()
+	}
+
+	thickness := shortestSide / 200
+	if thickness < 2 {
+		thickness = 2
+	}
+
+	labelScale := shortestSide / 400
+	if labelScale < 1 {
+		labelScale = 1
+	}
+
+	for _, detection := range detections {
+
+		if len(detection.Box) != 4 {
+			continue
+		}
+
+		box := image.Rect(
+			bounds.Min.X+int(detection.Box[0]),
+			bounds.Min.Y+int(detection.Box[1]),
+			bounds.Min.X+int(detection.Box[2]),
+			bounds.Min.Y+int(detection.Box[3]),
+		).Intersect(bounds)
+
+		if box.Empty() {
+			continue
+		}
+
+		boxColor := colorForLabel(detection.Label)
+
+		drawRectangle(canvas, box, thickness, boxColor)
+		drawLabel(canvas, box, fmt.Sprintf("%s %.0f%%", detection.Label, detection.Confidence*100), labelScale, boxColor)
+
+	}
+
+	var output bytes.Buffer
+	if err := jpeg.Encode(&output, canvas, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("could not encode annotated image: %w", err)
+	}
+
+	return output.Bytes(), nil
+
+}
+
+func colorForLabel(label string) color.RGBA {
+	hash := fnv.New32a()
+	hash.Write([]byte(label))
+	return palette[hash.Sum32()%uint32(len(palette))]
+}
+
+func drawRectangle(canvas *image.RGBA, box image.Rectangle, thickness int, c color.RGBA) {
+
+	fill := image.NewUniform(c)
+
+	edges := []image.Rectangle{
+		image.Rect(box.Min.X, box.Min.Y, box.Max.X, box.Min.Y+thickness),
+		image.Rect(box.Min.X, box.Max.Y-thickness, box.Max.X, box.Max.Y),
+		image.Rect(box.Min.X, box.Min.Y, box.Min.X+thickness, box.Max.Y),
+		image.Rect(box.Max.X-thickness, box.Min.Y, box.Max.X, box.Max.Y),
+	}
+
+	for _, edge := range edges {
+		draw.Draw(canvas, edge.Intersect(box), fill, image.Point{}, draw.Src)
+	}
+
+}
+
+func drawLabel(canvas *image.RGBA, box image.Rectangle, text string, scale int, c color.RGBA) {
+
+	face := basicfont.Face7x13
+	padding := 2
+
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := face.Metrics().Height.Ceil()
+
+	// Render the label at the font's native size, then scale it up
+	label := image.NewRGBA(image.Rect(0, 0, textWidth+padding*2, textHeight+padding*2))
+	draw.Draw(label, label.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{
+		Dst:  label,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(padding, padding+face.Metrics().Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+
+	scaledSize := image.Pt(label.Bounds().Dx()*scale, label.Bounds().Dy()*scale)
+
+	// Sit the label above the box, or inside it if the box touches the top edge
+	origin := image.Pt(box.Min.X, box.Min.Y-scaledSize.Y)
+	if origin.Y < canvas.Bounds().Min.Y {
+		origin.Y = box.Min.Y
+	}
+
+	// The scaler clips to the canvas itself, so labels near the edge are cropped rather than squashed
+	target := image.Rectangle{Min: origin, Max: origin.Add(scaledSize)}
+
+	xdraw.NearestNeighbor.Scale(canvas, target, label, label.Bounds(), draw.Src, nil)
+
+}
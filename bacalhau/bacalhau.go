@@ -1,58 +1,137 @@
 package bacalhau
 
 import(
+	"context"
 	"os"
 	"fmt"
 	"bytes"
 	"io"
 	"time"
+	"sort"
 	"regexp"
+	"strconv"
 	"strings"
 	"errors"
 	"encoding/json"
 	"encoding/base64"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	
 	"bbb/bsky"
+	"bbb/metrics"
+	"bbb/tracing"
 
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"gopkg.in/yaml.v3"
 )
 
 type JobExecutionResult struct {
-	JobID        string `json:"JobID"`
-	ExecutionID  string `json:"ExecutionID"`
-	Stdout       string `json:"Stdout"`
+	JobID        string        `json:"JobID"`
+	ExecutionID  string        `json:"ExecutionID"`
+	Stdout       string        `json:"Stdout"`
+	Stderr       string        `json:"Stderr"`
+	State        string        `json:"State"`
+	Duration     time.Duration `json:"Duration"`
 }
 
-var BACALHAU_HOST string
+// executionStates names the values of the orchestrator's ExecutionStateType
+// enum, for orchestrators that report the state as a number.
+var executionStates = []string{
+	"Undefined",
+	"New",
+	"AskForBid",
+	"AskForBidAccepted",
+	"AskForBidRejected",
+	"BidAccepted",
+	"BidRejected",
+	"Completed",
+	"Failed",
+	"Cancelled",
+}
+
+type executionState string
+
+func (s *executionState) UnmarshalJSON(data []byte) error {
+
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*s = executionState(name)
+		return nil
+	}
+
+	var number int
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("unexpected execution state %s", string(data))
+	}
+
+	if number >= 0 && number < len(executionStates) {
+		*s = executionState(executionStates[number])
+	} else {
+		*s = executionState(fmt.Sprintf("State %d", number))
+	}
+
+	return nil
+
+}
+
+type ClassificationOptions struct {
+	Model         string
+	MinConfidence float64
+	TopN          int
+}
+
+type Detection struct {
+	Label      string    `json:"label"`
+	Confidence float64   `json:"confidence"`
+	Box        []float64 `json:"box,omitempty"` // [x1, y1, x2, y2] in pixels of the source image
+}
+
+type ClassificationOutput struct {
+	ResultsText string      `json:"resultsText"`
+	Detections  []Detection `json:"detections"`
+}
+
+// Config is everything the package needs to know about the orchestrator and
+// the jobs it generates.
+type Config struct {
+	Host        string
+	Port        int
+	Secure      bool
+	AccessToken string
+
+	ClassificationImage  string
+	ClassificationModels []string
+
+	OpenAIOrigin string
+	OpenAIModel  string
+	AWSRegion    string
+}
+
+var config Config
+
+// Configure sets the configuration used by every function in the package.
+// It must be called before any jobs are created.
+func Configure(c Config) {
+	config = c
+}
 
 func constructOrchestratorURL() (string, error){
 
 	var protocol string
-	var hostName string
-	var portNumber string
 
-	if os.Getenv("USING_SECURE_ORCHESTRATOR") == "true"{
+	if config.Secure {
 		protocol = "https"
 	} else {
 		protocol = "http"
 	}
 
-	if os.Getenv("BACALHAU_HOST") == "" {
-		return "", errors.New("BACALHAU_HOST is not set with environment variables. Cannot construct Orchestrator URL.")
-	} else {
-		hostName = os.Getenv("BACALHAU_HOST")
+	if config.Host == "" {
+		return "", errors.New("the orchestrator host isn't configured. Cannot construct Orchestrator URL.")
 	}
 
-	if os.Getenv("BACALHAU_PORT") == ""{
-		fmt.Println("Warning: BACALHAU_PORT is not set with environment variables. Defaulting to 1234")
-		portNumber = "1234"
-	} else {
-		portNumber = os.Getenv("BACALHAU_PORT")
-	}
-
-	constructedURL := fmt.Sprintf(`%s://%s:%s`, protocol, hostName, portNumber)
+	constructedURL := fmt.Sprintf(`%s://%s:%d`, protocol, config.Host, config.Port)
 
 	return constructedURL, nil
 
@@ -60,9 +139,9 @@ func constructOrchestratorURL() (string, error){
 
 func getSignedAuthToken() (string, error) {
 
-	accessToken := os.Getenv("BACALHAU_ACCESS_TOKEN")
+	accessToken := config.AccessToken
 	if accessToken == "" {
-		return "", errors.New("BACALHAU_ACCESS_TOKEN isn't set by environment variables. Cannot generate auth token.")
+		return "", errors.New("the orchestrator access token isn't configured. Cannot generate auth token.")
 	}
 
 	b64Token := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"token":"%s"}`, accessToken)))
@@ -83,7 +162,7 @@ func getSignedAuthToken() (string, error) {
 
 	authEndpoint := fmt.Sprintf("%s/api/v1/auth/shared_secret", orchestratorURL)
 
-	fmt.Println("Authenticating with orchestrator...")
+	slog.Debug("Authenticating with orchestrator")
 
 	req, err := http.NewRequest("POST", authEndpoint, bytes.NewBuffer(payloadBytes))
 	if err != nil {
@@ -92,7 +171,7 @@ func getSignedAuthToken() (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(context.Background(), client, req, "auth")
 	if err != nil {
 		return "", fmt.Errorf("failed to authenticate with orchestrator: %v", err)
 	}
@@ -118,9 +197,49 @@ func getSignedAuthToken() (string, error) {
 
 }
 
+// CheckOrchestrator confirms that the orchestrator is reachable and, when a
+// secure orchestrator is in use, that we can still authenticate with it.
+func CheckOrchestrator() error {
+
+	orchestratorURL, err := constructOrchestratorURL()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/agent/alive", orchestratorURL), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	if config.Secure {
+
+		token, tokenErr := getSignedAuthToken()
+		if tokenErr != nil {
+			return tokenErr
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token) )
+
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := doRequest(context.Background(), client, req, "alive")
+	if err != nil {
+		return fmt.Errorf("orchestrator is unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("orchestrator health check failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+
+}
+
 func GetJobFileFromURL(url string) (string, error) {
 
-	fmt.Println("Getting job file from URL:", url)
+	slog.Info("Getting job file", "url", url)
 
 	// Make a GET request to the provided URL
 	resp, err := http.Get(url)
@@ -147,6 +266,10 @@ func GetJobFileFromURL(url string) (string, error) {
 		return "", fmt.Errorf("failed to parse YAML: %v", err)
 	}
 
+	if references := findSecretReferences(yamlContent, ""); len(references) > 0 {
+		return "", fmt.Errorf("secret references (env:) aren't allowed, but were found in %s", strings.Join(references, ", "))
+	}
+
 	// Nest the parsed YAML map under the "Job" property
 	wrappedContent := map[string]interface{}{
 		"Job": yamlContent,
@@ -193,15 +316,23 @@ func LoadEnvVarsToJob(jobFile *string, desiredVars []string, values map[string]i
 	}
 
 	// Add or update the IMAGE environment variable manually
-	tasks := yamlContent["Tasks"].([]interface{})
-	firstTask := tasks[0].(map[string]interface{})
-	engine := firstTask["Engine"].(map[string]interface{})
-	params := engine["Params"].(map[string]interface{})
+	tasks, _ := yamlContent["Tasks"].([]interface{})
+	if len(tasks) == 0 {
+		return fmt.Errorf("the job has no tasks")
+	}
+
+	firstTask, _ := tasks[0].(map[string]interface{})
+	engine, _ := firstTask["Engine"].(map[string]interface{})
+	params, isMap := engine["Params"].(map[string]interface{})
+	if !isMap {
+		return fmt.Errorf("the job's task has no engine parameters")
+	}
+
 	envVars := []string{}
 
 	for _, desiredVar := range desiredVars {
 
-		fmt.Println("desiredVar", desiredVar, values[desiredVar])
+		slog.Debug("Loading env var into job", "name", desiredVar)
 
 		if val, ok := values[desiredVar]; ok {
 			envVars = append(envVars, fmt.Sprintf("%s=%v", desiredVar, val))
@@ -225,7 +356,163 @@ func LoadEnvVarsToJob(jobFile *string, desiredVars []string, values map[string]i
 
 }
 
-func GenerateClassificationJob(imageURL string, isHotDogJob bool, className string) (string, error) {
+// AllowedClassificationModels returns the models that users may select with the
+// `model=` argument of the classify command. The list always includes the
+// default classification image.
+func AllowedClassificationModels() []string {
+
+	allowedModels := []string{}
+
+	if config.ClassificationImage != "" {
+		allowedModels = append(allowedModels, config.ClassificationImage)
+	}
+
+	for _, model := range config.ClassificationModels {
+
+		model = strings.TrimSpace(model)
+
+		if model == "" || model == config.ClassificationImage {
+			continue
+		}
+
+		allowedModels = append(allowedModels, model)
+
+	}
+
+	return allowedModels
+
+}
+
+func DefaultClassificationOptions() ClassificationOptions {
+	return ClassificationOptions{
+		Model: config.ClassificationImage,
+	}
+}
+
+// ParseClassificationOptions reads the `model=`, `min=` and `top=` arguments
+// that can follow the classify command, e.g. `classify model=yolov8s min=0.6 top=5`.
+// Anything else in the post, like a link with a query string, is left alone.
+func ParseClassificationOptions(post string) (ClassificationOptions, error) {
+
+	options := DefaultClassificationOptions()
+
+	parts := strings.Fields(post)
+
+	for idx, part := range parts {
+		if strings.EqualFold(part, "classify") {
+			parts = parts[idx+1:]
+			break
+		}
+	}
+
+	for _, part := range parts {
+
+		key, value, found := strings.Cut(part, "=")
+
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+			case "model":
+
+				allowed := false
+
+				for _, allowedModel := range AllowedClassificationModels() {
+					if value == allowedModel {
+						allowed = true
+						break
+					}
+				}
+
+				if !allowed {
+					return options, fmt.Errorf(`the model "%s" isn't available. Try one of: %s`, value, strings.Join(AllowedClassificationModels(), ", "))
+				}
+
+				options.Model = value
+
+			case "min":
+
+				number, isPercentage := strings.CutSuffix(value, "%")
+
+				minConfidence, err := strconv.ParseFloat(number, 64)
+				if err != nil {
+					return options, fmt.Errorf(`"%s" isn't a valid minimum confidence`, value)
+				}
+
+				// Accept percentages (min=60% or min=60) as well as fractions (min=0.6)
+				if isPercentage || minConfidence > 1 {
+					minConfidence = minConfidence / 100
+				}
+
+				if minConfidence < 0 || minConfidence > 1 {
+					return options, fmt.Errorf("the minimum confidence must be between 0 and 1")
+				}
+
+				options.MinConfidence = minConfidence
+
+			case "top":
+
+				topN, err := strconv.Atoi(value)
+				if err != nil || topN < 1 {
+					return options, fmt.Errorf(`"%s" isn't a valid number of results`, value)
+				}
+
+				options.TopN = topN
+		}
+
+	}
+
+	return options, nil
+
+}
+
+// FilterDetections drops detections below the requested confidence and
+// returns the rest ordered by confidence, limited to the requested count.
+func FilterDetections(detections []Detection, options ClassificationOptions) []Detection {
+
+	filtered := []Detection{}
+
+	for _, detection := range detections {
+		if detection.Confidence >= options.MinConfidence {
+			filtered = append(filtered, detection)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Confidence > filtered[j].Confidence
+	})
+
+	if options.TopN > 0 && len(filtered) > options.TopN {
+		filtered = filtered[:options.TopN]
+	}
+
+	return filtered
+
+}
+
+// ParseClassificationOutput reads the results that the classification
+// container prints to stdout after the ">>> Results <<<" marker.
+func ParseClassificationOutput(stdout string) (ClassificationOutput, error) {
+
+	var output ClassificationOutput
+
+	splitStdoutStr := ">>> Results <<<"
+
+	_, resultsJSON, found := strings.Cut(stdout, splitStdoutStr)
+	if !found {
+		return output, errors.New("classification output is missing the results marker")
+	}
+
+	if err := json.Unmarshal([]byte(strings.TrimSpace(resultsJSON)), &output); err != nil {
+		return output, fmt.Errorf("could not parse classification results: %w", err)
+	}
+
+	return output, nil
+
+}
+
+func GenerateClassificationJob(imageURL string, isHotDogJob bool, className string, options ClassificationOptions) (string, error) {
 	// Read the YAML file
 	jobFileTemplate, jtErr := os.ReadFile("./classify_job.yaml")
 	if jtErr != nil {
@@ -245,10 +532,16 @@ func GenerateClassificationJob(imageURL string, isHotDogJob bool, className stri
 	params := engine["Params"].(map[string]interface{})
 	envVars := []string{
 		fmt.Sprintf("IMAGE=%s", imageURL),
-		fmt.Sprintf("MODEL=%s", os.Getenv("CLASSIFICATION_IMAGE")),
-		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_ACCESS_KEY_ID")),
-		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", os.Getenv("AWS_SECRET_ACCESS_KEY")),
-		fmt.Sprintf("S3_BUCKET=%s", os.Getenv("S3_IMAGE_BUCKET")),
+		fmt.Sprintf("MODEL=%s", options.Model),
+		"RESULTS_OUTPUT=stdout",
+	}
+
+	if options.MinConfidence > 0 {
+		envVars = append(envVars, fmt.Sprintf("MIN_CONFIDENCE=%s", strconv.FormatFloat(options.MinConfidence, 'f', -1, 64)))
+	}
+
+	if options.TopN > 0 {
+		envVars = append(envVars, fmt.Sprintf("TOP_N=%d", options.TopN))
 	}
 
 	if isHotDogJob == true {
@@ -264,7 +557,7 @@ func GenerateClassificationJob(imageURL string, isHotDogJob bool, className stri
 
 	params["EnvironmentVariables"] = envVars
 
-	fmt.Println("TASKS:", tasks)
+	slog.Debug("Generated job tasks", "tasks", tasks)
 
 	// Wrap the updated YAML content into the final JSON structure
 	wrappedContent := map[string]interface{}{
@@ -282,6 +575,8 @@ func GenerateClassificationJob(imageURL string, isHotDogJob bool, className stri
 
 }
 
+// GenerateAltTextJob builds the alt-text job. The job prints the alt-text, and
+// reads OPEN_AI_KEY from the compute node.
 func GenerateAltTextJob(imageURL, prompt string) (string, error) {
 
 	jobFileTemplate, jtErr := os.ReadFile("./alt_text_job.yaml")
@@ -302,17 +597,15 @@ func GenerateAltTextJob(imageURL, prompt string) (string, error) {
 	params := engine["Params"].(map[string]interface{})
 	envVars := []string{
 		fmt.Sprintf("IMAGE_URL=%s", imageURL),
-		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_ACCESS_KEY_ID")),
-		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", os.Getenv("AWS_SECRET_ACCESS_KEY")),
-		fmt.Sprintf("S3_BUCKET=%s", os.Getenv("S3_IMAGE_BUCKET")),
 		fmt.Sprintf("PROMPT_TEXT=%s", prompt),
-		fmt.Sprintf("OPEN_AI_ORIGIN=%s", os.Getenv("OPEN_AI_ORIGIN")),
-		fmt.Sprintf("OPEN_AI_KEY=%s", os.Getenv("OPEN_AI_KEY")),
-		fmt.Sprintf("OPEN_AI_MODEL=%s", os.Getenv("OPEN_AI_MODEL")),
+		fmt.Sprintf("OPEN_AI_ORIGIN=%s", config.OpenAIOrigin),
+		fmt.Sprintf("OPEN_AI_MODEL=%s", config.OpenAIModel),
 	}
 
 	params["EnvironmentVariables"] = envVars
 
+	SetSecretReferences(firstTask, "OPEN_AI_KEY")
+
 	// Wrap the updated YAML content into the final JSON structure
 	wrappedContent := map[string]interface{}{
 		"Job": yamlContent,
@@ -329,6 +622,8 @@ func GenerateAltTextJob(imageURL, prompt string) (string, error) {
 
 }
 
+// GenerateOCRJob builds the OCR job. Its AWS credentials come from the compute
+// node rather than the bot.
 func GenerateOCRJob(imageURL string) (string, error) {
 
 	jobFileTemplate, jtErr := os.ReadFile("./ocr_job.yaml")
@@ -349,13 +644,13 @@ func GenerateOCRJob(imageURL string) (string, error) {
 	params := engine["Params"].(map[string]interface{})
 	envVars := []string{
 		fmt.Sprintf("IMAGE_URL=%s", imageURL),
-		fmt.Sprintf("AWS_REGION=%s", os.Getenv("AWS_REGION")),
-		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_ACCESS_KEY_ID")),
-		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		fmt.Sprintf("AWS_REGION=%s", config.AWSRegion),
 	}
 
 	params["EnvironmentVariables"] = envVars
 
+	SetSecretReferences(firstTask, "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY")
+
 	// Wrap the updated YAML content into the final JSON structure
 	wrappedContent := map[string]interface{}{
 		"Job": yamlContent,
@@ -372,7 +667,7 @@ func GenerateOCRJob(imageURL string) (string, error) {
 
 }
 
-func GetResultsForJob(jobID string) (JobExecutionResult, error) {
+func GetResultsForJob(ctx context.Context, jobID string) (JobExecutionResult, error) {
 
 	var token string
 	var tokenErr error
@@ -380,17 +675,17 @@ func GetResultsForJob(jobID string) (JobExecutionResult, error) {
 	orchestratorURL, orchErr := constructOrchestratorURL()
 
 	if orchErr != nil {
-		fmt.Println("Could not create Job:", orchErr.Error())
+		slog.Error("Could not construct orchestrator URL", "error", orchErr)
 		return JobExecutionResult{}, orchErr
 	}
 
-	if os.Getenv("USING_SECURE_ORCHESTRATOR") == "true" {
+	if config.Secure {
 
 		token, tokenErr = getSignedAuthToken()
 
 		if tokenErr != nil {
 
-			fmt.Println("Could not create Job:", tokenErr.Error())
+			slog.Error("Could not get orchestrator auth token", "error", tokenErr)
 			return JobExecutionResult{}, tokenErr
 
 		}
@@ -398,28 +693,28 @@ func GetResultsForJob(jobID string) (JobExecutionResult, error) {
 	}
 
 	executionsURL := fmt.Sprintf("%s/api/v1/orchestrator/jobs/%s/executions", orchestratorURL, jobID)
-	fmt.Println("executionsURL:", executionsURL)
+	slog.Debug("Fetching executions", "job_id", jobID, "url", executionsURL)
 
 	req, reqErr := http.NewRequest("GET", executionsURL, nil)
 	if reqErr != nil {
-		fmt.Printf("Error creating request for executions: %v\n", reqErr)
+		slog.Error("Could not create request for executions", "job_id", jobID, "error", reqErr)
 		return JobExecutionResult{}, errors.New(fmt.Sprintf(`Error creating request for executions: %s`, reqErr.Error()))
 	}
 
-	if os.Getenv("USING_SECURE_ORCHESTRATOR") == "true" {
+	if config.Secure {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token) )
 	}
 
 	client := &http.Client{}
-	resp, respErr := client.Do(req)
+	resp, respErr := doRequest(ctx, client, req, "executions")
 	if respErr != nil {
-		fmt.Printf("Error fetching executions: %v\n", respErr)
+		slog.Error("Could not fetch executions", "job_id", jobID, "error", respErr)
 		return JobExecutionResult{}, errors.New(fmt.Sprintf(`Error fetching executions: %s`, respErr.Error()))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Failed to fetch executions, status code: %d\n", resp.StatusCode)
+		slog.Error("Failed to fetch executions", "job_id", jobID, "status", resp.StatusCode)
 		return JobExecutionResult{}, errors.New(fmt.Sprintf(`Failed to fetch executions (HTTP status code: %d)`, resp.StatusCode))
 	}
 
@@ -428,17 +723,23 @@ func GetResultsForJob(jobID string) (JobExecutionResult, error) {
 			ID        string `json:"ID"`
 			RunOutput struct {
 				Stdout string `json:"Stdout"`
+				Stderr string `json:"Stderr"`
 			} `json:"RunOutput"`
+			ComputeState struct {
+				StateType executionState `json:"StateType"`
+			} `json:"ComputeState"`
+			CreateTime int64 `json:"CreateTime"`
+			ModifyTime int64 `json:"ModifyTime"`
 		} `json:"Items"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&executionsResponse); err != nil {
-		fmt.Printf("Error decoding executions response: %v\n", err)
+		slog.Error("Could not decode executions response", "job_id", jobID, "error", err)
 		return JobExecutionResult{}, errors.New(fmt.Sprintf(`Error decoding executions response: %s`, err.Error()))
 	}
 
 	if len(executionsResponse.Items) == 0 {
-		fmt.Printf("No executions found for JobID: %s\n", jobID)
+		slog.Warn("No executions found", "job_id", jobID)
 		return JobExecutionResult{}, errors.New(fmt.Sprintf(`No executions found for JobID "%s"`, jobID))
 	}
 
@@ -447,9 +748,12 @@ func GetResultsForJob(jobID string) (JobExecutionResult, error) {
 	}
 
 	for _, thisExecution := range executionsResponse.Items {
-		if thisExecution.RunOutput.Stdout != "" {
+		if thisExecution.RunOutput.Stdout != "" || chosenJobToReturn.ExecutionID == "" {
 			chosenJobToReturn.ExecutionID = thisExecution.ID
 			chosenJobToReturn.Stdout = thisExecution.RunOutput.Stdout
+			chosenJobToReturn.Stderr = thisExecution.RunOutput.Stderr
+			chosenJobToReturn.State = string(thisExecution.ComputeState.StateType)
+			chosenJobToReturn.Duration = time.Duration(thisExecution.ModifyTime - thisExecution.CreateTime)
 		}
 	}
 
@@ -457,7 +761,74 @@ func GetResultsForJob(jobID string) (JobExecutionResult, error) {
 
 }
 
-func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
+// CreateJob submits a job, waits for it to run and returns its results. The
+// trace context in ctx is attached to the job as labels.
+func CreateJob(ctx context.Context, jobSpec string, timeToWaitForResults int) JobExecutionResult {
+
+	ctx, span := tracing.Start(ctx, "bacalhau.run_job", attribute.Int("bacalhau.wait_seconds", timeToWaitForResults))
+
+	labelledJobSpec, labelErr := addJobLabels(jobSpec, tracing.JobLabels(ctx))
+	if labelErr != nil {
+		slog.Warn("Could not add trace labels to job", "error", labelErr)
+		labelledJobSpec = jobSpec
+	}
+
+	result := createJob(ctx, labelledJobSpec, timeToWaitForResults)
+
+	span.SetAttributes(
+		attribute.String("bacalhau.job_id", result.JobID),
+		attribute.String("bacalhau.execution_id", result.ExecutionID),
+		attribute.String("bacalhau.state", result.State),
+	)
+
+	if result.JobID == "" {
+		tracing.End(span, errors.New("job did not run"))
+	} else {
+		span.End()
+	}
+
+	return result
+
+}
+
+// addJobLabels merges labels into the Labels of a wrapped job spec.
+func addJobLabels(jobSpec string, labels map[string]string) (string, error) {
+
+	if len(labels) == 0 {
+		return jobSpec, nil
+	}
+
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal([]byte(jobSpec), &wrapped); err != nil {
+		return "", fmt.Errorf("could not parse job spec: %w", err)
+	}
+
+	job, ok := wrapped["Job"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("job spec has no Job")
+	}
+
+	jobLabels, ok := job["Labels"].(map[string]interface{})
+	if !ok {
+		jobLabels = map[string]interface{}{}
+	}
+
+	for key, value := range labels {
+		jobLabels[key] = value
+	}
+
+	job["Labels"] = jobLabels
+
+	labelled, err := json.Marshal(wrapped)
+	if err != nil {
+		return "", fmt.Errorf("could not serialise job spec: %w", err)
+	}
+
+	return string(labelled), nil
+
+}
+
+func createJob(ctx context.Context, jobSpec string, timeToWaitForResults int) JobExecutionResult {
 
 	var token string
 	var tokenErr error
@@ -465,17 +836,17 @@ func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
 	orchestratorURL, orchErr := constructOrchestratorURL()
 
 	if orchErr != nil {
-		fmt.Println("Could not create Job:", orchErr.Error())
+		slog.Error("Could not construct orchestrator URL", "error", orchErr)
 		return JobExecutionResult{}
 	}
 
-	if os.Getenv("USING_SECURE_ORCHESTRATOR") == "true" {
+	if config.Secure {
 
 		token, tokenErr = getSignedAuthToken()
 
 		if tokenErr != nil {
 
-			fmt.Println("Could not create Job:", tokenErr.Error())
+			slog.Error("Could not get orchestrator auth token", "error", tokenErr)
 			return JobExecutionResult{}
 
 		}
@@ -483,32 +854,32 @@ func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
 	}
 
 	createJobURL := fmt.Sprintf("%s/api/v1/orchestrator/jobs", orchestratorURL)
-	fmt.Println("Sending job to:", createJobURL)
+	slog.Debug("Sending job", "url", createJobURL)
 
 	// Convert the job specification string to a JSON byte slice
 	jsonData := []byte(jobSpec)
 
 	req, err := http.NewRequest("PUT", createJobURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Error creating HTTP request: %v\n", err)
+		slog.Error("Could not create job request", "error", err)
 		return JobExecutionResult{}
 	}
 	req.Header.Set("Content-Type", "application/json")
 	
-	if os.Getenv("USING_SECURE_ORCHESTRATOR") == "true" {
+	if config.Secure {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token) )
 	}
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, client, req, "create_job")
 	if err != nil {
-		fmt.Printf("Error sending HTTP request: %v\n", err)
+		slog.Error("Could not send job request", "error", err)
 		return JobExecutionResult{}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Failed to create job, status code: %d\n", resp.StatusCode)
+		slog.Error("Failed to create job", "status", resp.StatusCode)
 		return JobExecutionResult{}
 	}
 
@@ -516,21 +887,24 @@ func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
 		JobID string `json:"JobID"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Printf("Error decoding job creation response: %v\n", err)
+		slog.Error("Could not decode job creation response", "error", err)
 		return JobExecutionResult{}
 	}
 
 	if response.JobID == "" {
-		fmt.Println("Job creation response missing JobID.")
+		slog.Error("Job creation response missing JobID")
 		return JobExecutionResult{}
 	}
 
-	fmt.Printf("Job created successfully with ID: %s\n", response.JobID)
+	slog.Info("Job created", "job_id", response.JobID)
 
 	// Wait for a given period before retrieving results...
-	fmt.Println(fmt.Sprintf(`Waiting for %d seconds before querying executions for Job "%s"...`, timeToWaitForResults, response.JobID))
+	slog.Info("Waiting before querying executions", "job_id", response.JobID, "seconds", timeToWaitForResults)
 	waitTime := time.Duration(timeToWaitForResults) * time.Second
+
+	_, waitSpan := tracing.Start(ctx, "bacalhau.wait_for_results")
 	time.Sleep(waitTime)
+	waitSpan.End()
 
 	// result, resultErr := GetResultsForJob(response.JobID)
 
@@ -539,22 +913,25 @@ func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
 
 	for i := 0; i < 5; i++ {
 
-		fmt.Println(fmt.Printf(`Making attempt no. %d to retrieve results for JobID "%s".`, i + 1, response.JobID))
+		slog.Debug("Retrieving job results", "job_id", response.JobID, "attempt", i + 1)
 
-		result, resultErr = GetResultsForJob(response.JobID)
+		result, resultErr = GetResultsForJob(ctx, response.JobID)
 
 		if result.JobID != "" {
 			break
 		} else {
-			fmt.Println(fmt.Printf(`Did not get results for JobID "%s".`, response.JobID))
+			slog.Debug("Did not get job results", "job_id", response.JobID, "attempt", i + 1)
 			time.Sleep(waitTime)
 		}
 
 	}
 
 	if resultErr != nil {
-		fmt.Println( fmt.Sprintf(`Failed to get results for JobID "%s": %s`, response.JobID, resultErr) )
-		go StopJob(response.JobID, "Failed to get results in allotted timeframe.", false)
+		slog.Error("Failed to get job results", "job_id", response.JobID, "error", resultErr)
+		// Stopped before returning, so callers' limits still count it until then
+		if _, stopErr := StopJob(response.JobID, "Failed to get results in allotted timeframe.", false); stopErr != nil {
+			slog.Warn("Could not stop job", "job_id", response.JobID, "error", stopErr)
+		}
 		return JobExecutionResult{}
 	}
 
@@ -565,12 +942,17 @@ func CreateJob(jobSpec string, timeToWaitForResults int) JobExecutionResult {
 func StopJob(jobID, reason string, wait bool) (string, error) {
 
 	if wait == true {
-		fmt.Println("Waiting 40 seconds before killing job with ID:", jobID)
+		slog.Info("Waiting 40 seconds before stopping job", "job_id", jobID)
 		time.Sleep(40 * time.Second)
 	}
 
+	orchestratorURL, err := constructOrchestratorURL()
+	if err != nil {
+		return "", err
+	}
+
 	// Construct the URL for stopping the job
-	url := fmt.Sprintf("http://%s/api/v1/orchestrator/jobs/%s", BACALHAU_HOST, jobID)
+	url := fmt.Sprintf("%s/api/v1/orchestrator/jobs/%s", orchestratorURL, jobID)
 
 	// Create the payload with the reason
 	payload := map[string]string{"reason": reason}
@@ -586,9 +968,20 @@ func StopJob(jobID, reason string, wait bool) (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	if config.Secure {
+
+		token, tokenErr := getSignedAuthToken()
+		if tokenErr != nil {
+			return "", tokenErr
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token) )
+
+	}
+
 	// Send the request using the default HTTP client
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(context.Background(), client, req, "stop_job")
 	if err != nil {
 		return "", fmt.Errorf("failed to send HTTP request: %v", err)
 	}
@@ -607,7 +1000,7 @@ func StopJob(jobID, reason string, wait bool) (string, error) {
 		return "", fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	fmt.Println("Job stopped:", jobID)
+	slog.Info("Job stopped", "job_id", jobID)
 
 	return response.EvaluationID, nil
 }
@@ -632,11 +1025,6 @@ func CheckPostIsCommand(post string, accountUsername string) (bool, bsky.PostCom
 	isClassifyJobCommand := classifyJobRegex.MatchString(post)
 	isHotDogJobCommand := hotDogJobRegex.MatchString(post)
 	isArbitraryClassCommand := arbitraryClassRegex.MatchString(post)
-	isAltTextCommand := false
-
-	if strings.Contains(accountUsername, "alt-text.bots.bacalhau.org") {
-		isAltTextCommand = true
-	}
 
 	components := bsky.PostComponents{}
 	parts := strings.Fields(post)
@@ -665,15 +1053,29 @@ func CheckPostIsCommand(post string, accountUsername string) (bool, bsky.PostCom
 		}
 	}
 
-	if isAltTextCommand {
-		commandType = "altText"
-	}
-
 	// Check if the post matches any of the patterns
-	return isJobRunCommand || isClassifyJobCommand || isHotDogJobCommand || isArbitraryClassCommand || isAltTextCommand, components, commandType, className
+	return isJobRunCommand || isClassifyJobCommand || isHotDogJobCommand || isArbitraryClassCommand, components, commandType, className
 
 }
 
+// doRequest sends a request to the orchestrator and records how long it took.
+// Requests made on behalf of a traced mention get a span of their own, and
+// pass the trace context on to the orchestrator.
+func doRequest(ctx context.Context, client *http.Client, req *http.Request, endpoint string) (*http.Response, error) {
+
+	ctx, span := tracing.StartChild(ctx, "bacalhau " + endpoint)
+	tracing.InjectHeaders(ctx, req.Header)
+
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	metrics.ObserveBacalhauRequest(endpoint, start, resp, err)
 
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+
+	tracing.End(span, err)
 
+	return resp, err
 
+}
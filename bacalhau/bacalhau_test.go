@@ -0,0 +1,259 @@
+package bacalhau
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseClassificationOptions(t *testing.T) {
+
+	Configure(Config{
+		ClassificationImage: "yolov8n",
+		ClassificationModels: []string{"yolov8s"},
+	})
+
+	tests := []struct {
+		name string
+		post string
+		want ClassificationOptions
+		wantErr bool
+	}{
+		{"defaults", "@bot classify", ClassificationOptions{Model: "yolov8n"}, false},
+		{"all options", "@bot classify model=yolov8s min=0.6 top=5", ClassificationOptions{Model: "yolov8s", MinConfidence: 0.6, TopN: 5}, false},
+		{"percentage without sign", "classify min=60", ClassificationOptions{Model: "yolov8n", MinConfidence: 0.6}, false},
+		{"percentage with sign", "classify min=60%", ClassificationOptions{Model: "yolov8n", MinConfidence: 0.6}, false},
+		{"small percentage", "classify min=0.5%", ClassificationOptions{Model: "yolov8n", MinConfidence: 0.005}, false},
+		{"keys ignore case", "classify MIN=0.2 Top=1", ClassificationOptions{Model: "yolov8n", MinConfidence: 0.2, TopN: 1}, false},
+		{"links are left alone", "classify https://example.com/cat.jpg?size=large&x=1 top=2", ClassificationOptions{Model: "yolov8n", TopN: 2}, false},
+		{"unknown keys are left alone", "classify colour=red", ClassificationOptions{Model: "yolov8n"}, false},
+		{"options before the command are ignored", "top=3 classify", ClassificationOptions{Model: "yolov8n"}, false},
+		{"unavailable model", "classify model=gpt", ClassificationOptions{}, true},
+		{"invalid minimum", "classify min=lots", ClassificationOptions{}, true},
+		{"minimum over 100%", "classify min=150%", ClassificationOptions{}, true},
+		{"negative minimum", "classify min=-0.5", ClassificationOptions{}, true},
+		{"invalid top", "classify top=0", ClassificationOptions{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got, err := ParseClassificationOptions(test.post)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.Model != test.want.Model || got.TopN != test.want.TopN || !closeTo(got.MinConfidence, test.want.MinConfidence) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+
+		})
+	}
+
+}
+
+func TestFilterDetections(t *testing.T) {
+
+	detections := []Detection{
+		{Label: "cat", Confidence: 0.4},
+		{Label: "dog", Confidence: 0.9},
+		{Label: "hotdog", Confidence: 0.7},
+	}
+
+	tests := []struct {
+		name string
+		options ClassificationOptions
+		want []string
+	}{
+		{"no limits", ClassificationOptions{}, []string{"dog", "hotdog", "cat"}},
+		{"minimum confidence", ClassificationOptions{MinConfidence: 0.5}, []string{"dog", "hotdog"}},
+		{"minimum is inclusive", ClassificationOptions{MinConfidence: 0.7}, []string{"dog", "hotdog"}},
+		{"top", ClassificationOptions{TopN: 1}, []string{"dog"}},
+		{"top larger than results", ClassificationOptions{MinConfidence: 0.8, TopN: 5}, []string{"dog"}},
+		{"nothing left", ClassificationOptions{MinConfidence: 0.95}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			labels := []string{}
+			for _, detection := range FilterDetections(detections, test.options) {
+				labels = append(labels, detection.Label)
+			}
+
+			if !reflect.DeepEqual(labels, test.want) {
+				t.Errorf("got %v, want %v", labels, test.want)
+			}
+
+		})
+	}
+
+}
+
+func TestParseClassificationOutput(t *testing.T) {
+
+	tests := []struct {
+		name string
+		stdout string
+		want ClassificationOutput
+		wantErr bool
+	}{
+		{
+			"results after the marker",
+			"loading model\n>>> Results <<<\n{\"resultsText\":\"a cat\",\"detections\":[{\"label\":\"cat\",\"confidence\":0.8}]}\n",
+			ClassificationOutput{ResultsText: "a cat", Detections: []Detection{{Label: "cat", Confidence: 0.8}}},
+			false,
+		},
+		{"missing marker", "{\"resultsText\":\"a cat\"}", ClassificationOutput{}, true},
+		{"invalid JSON", ">>> Results <<<\nnot json", ClassificationOutput{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got, err := ParseClassificationOutput(test.stdout)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+
+		})
+	}
+
+}
+
+func TestGetJobFileFromURL(t *testing.T) {
+
+	const job = `Type: batch
+Tasks:
+  - Name: main
+    Engine:
+      Type: docker
+      Params:
+        Image: ubuntu
+        Entrypoint: ["echo", "hello"]
+`
+
+	tests := []struct {
+		name string
+		jobFile string
+		wantErr string
+	}{
+		{"job", job, ""},
+		{"plain environment variables", job + "    Env:\n      GREETING: hello\n", ""},
+		{"secret reference", job + "    Env:\n      KEY: env:OPEN_AI_KEY\n", "Tasks[0].Env.KEY"},
+		{"secret reference in capitals", job + "    Env:\n      KEY: ENV:OPEN_AI_KEY\n", "Tasks[0].Env.KEY"},
+		{"secret reference anywhere else", strings.Replace(job, "hello", "env:AWS_SECRET_ACCESS_KEY", 1), "Tasks[0].Engine.Params.Entrypoint[1]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(test.jobFile))
+			}))
+			defer server.Close()
+
+			jobSpec, err := GetJobFileFromURL(server.URL)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.Contains(jobSpec, `"Job"`) {
+				t.Errorf("the job wasn't wrapped: %s", jobSpec)
+			}
+
+		})
+	}
+
+}
+
+func TestDockerRunArgsSecrets(t *testing.T) {
+
+	secrets := map[string]string{"BBB_WEATHER__API_KEY": "abc123"}
+
+	tests := []struct {
+		name string
+		env map[string]string
+		want []string
+		wantErr string
+	}{
+		{"plain value", map[string]string{"UNITS": "metric"}, []string{"UNITS=metric"}, ""},
+		{"given secret", map[string]string{"API_KEY": "env:BBB_WEATHER__API_KEY"}, []string{"API_KEY=abc123"}, ""},
+		{"secret that wasn't given", map[string]string{"TOKEN": "env:BACALHAU_ACCESS_TOKEN"}, nil, "BACALHAU_ACCESS_TOKEN, which wasn't given"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Secrets must never come from this process's environment
+			t.Setenv("BACALHAU_ACCESS_TOKEN", "the bot's own token")
+
+			args, err := dockerRunArgs(Task{Env: test.env}, DockerParams{Image: "example/weather"}, t.TempDir(), secrets)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			env := []string{}
+			for index, arg := range args {
+				if arg == "--env" {
+					env = append(env, args[index + 1])
+				}
+			}
+
+			if !reflect.DeepEqual(env, test.want) {
+				t.Errorf("got environment %q, want %q", env, test.want)
+			}
+
+		})
+	}
+
+}
+
+func closeTo(a, b float64) bool {
+
+	difference := a - b
+	if difference < 0 {
+		difference = -difference
+	}
+
+	return difference < 1e-9
+
+}
@@ -0,0 +1,54 @@
+package bacalhau
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Small inputs are sent with the job itself as inline sources, which Bacalhau
+// writes to a file in the task's container before it starts. That saves
+// putting them in storage first, and avoids the limits of environment variables.
+
+// AddInlineInput mounts content as a read-only file at target in the task.
+func AddInlineInput(task map[string]interface{}, target, mediaType string, content []byte) {
+
+	sources, _ := task["InputSources"].([]interface{})
+
+	sources = append(sources, map[string]interface{}{
+		"Source": map[string]interface{}{
+			"Type": "inline",
+			"Params": map[string]interface{}{
+				"URL": fmt.Sprintf("data:%s;base64,%s", mediaType, base64.StdEncoding.EncodeToString(content)),
+			},
+		},
+		"Target": target,
+	})
+
+	task["InputSources"] = sources
+
+}
+
+// inlineContent reads the content of an inline source's data URL.
+func inlineContent(source SpecConfig) ([]byte, error) {
+
+	dataURL, _ := source.Params["URL"].(string)
+
+	header, data, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !found || !strings.HasPrefix(dataURL, "data:") {
+		return nil, fmt.Errorf("inline source must have a data URL")
+	}
+
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inline source data: %w", err)
+	}
+
+	return []byte(decoded), nil
+
+}
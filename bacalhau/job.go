@@ -0,0 +1,216 @@
+package bacalhau
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Job is the Bacalhau job model, as written in a job.yaml file. It covers the
+// fields the v1 API accepts for batch and ops jobs, so that a misspelt field
+// is reported rather than silently ignored.
+type Job struct {
+	ID          string            `yaml:"ID"`
+	Name        string            `yaml:"Name"`
+	Namespace   string            `yaml:"Namespace"`
+	Type        string            `yaml:"Type"`
+	Priority    int               `yaml:"Priority"`
+	Count       int               `yaml:"Count"`
+	Constraints []Constraint      `yaml:"Constraints"`
+	Meta        map[string]string `yaml:"Meta"`
+	Labels      map[string]string `yaml:"Labels"`
+	Tasks       []Task            `yaml:"Tasks"`
+}
+
+type Constraint struct {
+	Key      string   `yaml:"Key"`
+	Operator string   `yaml:"Operator"`
+	Values   []string `yaml:"Values"`
+}
+
+type Task struct {
+	Name         string            `yaml:"Name"`
+	Engine       SpecConfig        `yaml:"Engine"`
+	Publisher    *SpecConfig       `yaml:"Publisher"`
+	Env          map[string]string `yaml:"Env"`
+	Meta         map[string]string `yaml:"Meta"`
+	InputSources []InputSource     `yaml:"InputSources"`
+	ResultPaths  []ResultPath      `yaml:"ResultPaths"`
+	Resources    Resources         `yaml:"Resources"`
+	Network      Network           `yaml:"Network"`
+	Timeouts     Timeouts          `yaml:"Timeouts"`
+}
+
+// SpecConfig names an engine, publisher or storage source. Its parameters
+// depend on the type.
+type SpecConfig struct {
+	Type   string                 `yaml:"Type"`
+	Params map[string]interface{} `yaml:"Params"`
+}
+
+type InputSource struct {
+	Source SpecConfig `yaml:"Source"`
+	Alias  string     `yaml:"Alias"`
+	Target string     `yaml:"Target"`
+}
+
+type ResultPath struct {
+	Name string `yaml:"Name"`
+	Path string `yaml:"Path"`
+}
+
+type Resources struct {
+	CPU    string `yaml:"CPU"`
+	Memory string `yaml:"Memory"`
+	Disk   string `yaml:"Disk"`
+	GPU    string `yaml:"GPU"`
+}
+
+type Network struct {
+	Type    string   `yaml:"Type"`
+	Domains []string `yaml:"Domains"`
+}
+
+// Timeouts are in seconds
+type Timeouts struct {
+	ExecutionTimeout int64 `yaml:"ExecutionTimeout"`
+	QueueTimeout     int64 `yaml:"QueueTimeout"`
+	TotalTimeout     int64 `yaml:"TotalTimeout"`
+}
+
+// DockerParams are the parameters of the docker engine.
+type DockerParams struct {
+	Image                string   `yaml:"Image"`
+	Entrypoint           []string `yaml:"Entrypoint"`
+	Parameters           []string `yaml:"Parameters"`
+	EnvironmentVariables []string `yaml:"EnvironmentVariables"`
+	WorkingDirectory     string   `yaml:"WorkingDirectory"`
+}
+
+// ParseJob reads a job.yaml file into the job model. Unknown fields are an error.
+func ParseJob(jobFile string) (Job, error) {
+
+	var job Job
+
+	if err := decodeStrict([]byte(jobFile), &job); err != nil {
+		return job, fmt.Errorf("an error occurred parsing the job file: %w", err)
+	}
+
+	return job, nil
+
+}
+
+// DockerParams reads the task's engine parameters, if it uses the docker engine.
+func (t Task) DockerParams() (DockerParams, error) {
+
+	var params DockerParams
+
+	if !strings.EqualFold(t.Engine.Type, "docker") {
+		return params, fmt.Errorf(`engine is "%s", not docker`, t.Engine.Type)
+	}
+
+	encoded, err := yaml.Marshal(t.Engine.Params)
+	if err != nil {
+		return params, err
+	}
+
+	// Line numbers would refer to the re-encoded parameters, so they're dropped
+	if err := decodeStrict(encoded, &params); err != nil {
+
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for index, message := range typeErr.Errors {
+				typeErr.Errors[index] = "Params: " + lineNumberPattern.ReplaceAllString(message, "")
+			}
+			return params, typeErr
+		}
+
+		return params, fmt.Errorf("invalid docker engine parameters: %w", err)
+
+	}
+
+	return params, nil
+
+}
+
+func decodeStrict(content []byte, target interface{}) error {
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(target); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+
+}
+
+var lineNumberPattern = regexp.MustCompile(`^line \d+: `)
+
+var quantityPattern = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*([A-Za-z]*)$`)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseCPU reads a CPU quantity like "1", "0.5" or "500m" as a number of cores.
+func ParseCPU(quantity string) (float64, error) {
+
+	matches := quantityPattern.FindStringSubmatch(strings.TrimSpace(quantity))
+	if matches == nil || (matches[2] != "" && matches[2] != "m") {
+		return 0, fmt.Errorf(`"%s" isn't a CPU quantity like 1, 0.5 or 500m`, quantity)
+	}
+
+	cores, _ := strconv.ParseFloat(matches[1], 64)
+
+	if matches[2] == "m" {
+		cores = cores / 1000
+	}
+
+	return cores, nil
+
+}
+
+// ParseBytes reads a memory or disk quantity like "128MB", "1.5GB" or "2GiB".
+func ParseBytes(quantity string) (int64, error) {
+
+	matches := quantityPattern.FindStringSubmatch(strings.TrimSpace(quantity))
+	if matches == nil {
+		return 0, fmt.Errorf(`"%s" isn't a size like 128MB or 2GiB`, quantity)
+	}
+
+	multiplier, known := byteUnits[strings.ToLower(matches[2])]
+	if !known {
+		return 0, fmt.Errorf(`"%s" has an unknown unit "%s"`, quantity, matches[2])
+	}
+
+	value, _ := strconv.ParseFloat(matches[1], 64)
+
+	return int64(math.Round(value * multiplier)), nil
+
+}
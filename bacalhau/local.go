@@ -0,0 +1,190 @@
+package bacalhau
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalJobID is the JobID of results from RunLocally
+const LocalJobID = "local"
+
+// RunLocally runs a job with the local Docker daemon instead of submitting it
+// to the network, so that jobs can be tried out without an orchestrator. The
+// job must have a single docker task. Its image, entrypoint, parameters,
+// environment, inline inputs, resources, network and execution timeout are
+// honoured. Secret references are filled in from secrets, which is keyed by
+// the name a compute node would have them under, and any other reference is
+// an error rather than being read from this process's environment.
+func RunLocally(ctx context.Context, jobSpec string, secrets map[string]string) (JobExecutionResult, error) {
+
+	result := JobExecutionResult{JobID: LocalJobID}
+
+	var wrapped struct {
+		Job Job `json:"Job"`
+	}
+
+	if err := json.Unmarshal([]byte(jobSpec), &wrapped); err != nil {
+		return result, fmt.Errorf("could not parse job: %w", err)
+	}
+
+	if len(wrapped.Job.Tasks) != 1 {
+		return result, fmt.Errorf("jobs run locally must have exactly 1 task, not %d", len(wrapped.Job.Tasks))
+	}
+
+	task := wrapped.Job.Tasks[0]
+
+	params, err := task.DockerParams()
+	if err != nil {
+		return result, err
+	}
+
+	inputDir, err := os.MkdirTemp("", "bbb-inputs-")
+	if err != nil {
+		return result, fmt.Errorf("could not create a directory for the job's inputs: %w", err)
+	}
+	defer os.RemoveAll(inputDir)
+
+	args, err := dockerRunArgs(task, params, inputDir, secrets)
+	if err != nil {
+		return result, err
+	}
+
+	if task.Timeouts.ExecutionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeouts.ExecutionTimeout) * time.Second)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	command := exec.CommandContext(ctx, "docker", args...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	start := time.Now()
+	runErr := command.Run()
+
+	result.Duration = time.Since(start)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.State = "Completed"
+
+	if runErr != nil {
+
+		var exitErr *exec.ExitError
+		if !errors.As(runErr, &exitErr) {
+			return result, fmt.Errorf("could not run docker: %w", runErr)
+		}
+
+		result.State = "Failed"
+
+	}
+
+	return result, nil
+
+}
+
+// dockerRunArgs builds the arguments to docker for the task. Inline inputs are
+// written to inputDir and mounted from there, and secret references are filled
+// in from secrets.
+func dockerRunArgs(task Task, params DockerParams, inputDir string, secrets map[string]string) ([]string, error) {
+
+	args := []string{"run", "--rm"}
+
+	switch strings.ToLower(task.Network.Type) {
+		case "", "none":
+			args = append(args, "--network", "none")
+	}
+
+	if task.Resources.CPU != "" {
+		cpu, err := ParseCPU(task.Resources.CPU)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--cpus", fmt.Sprintf("%g", cpu))
+	}
+
+	if task.Resources.Memory != "" {
+		memory, err := ParseBytes(task.Resources.Memory)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--memory", fmt.Sprintf("%db", memory))
+	}
+
+	for _, variable := range params.EnvironmentVariables {
+		args = append(args, "--env", variable)
+	}
+
+	envNames := []string{}
+	for name := range task.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+
+	for _, name := range envNames {
+
+		value := task.Env[name]
+
+		if secretName, isSecret := strings.CutPrefix(value, "env:"); isSecret {
+			secret, found := secrets[secretName]
+			if !found {
+				return nil, fmt.Errorf("Env.%s refers to secret %s, which wasn't given", name, secretName)
+			}
+			value = secret
+		}
+
+		args = append(args, "--env", fmt.Sprintf("%s=%s", name, value))
+
+	}
+
+	for index, input := range task.InputSources {
+
+		if !strings.EqualFold(input.Source.Type, "inline") {
+			return nil, fmt.Errorf(`input sources of type "%s" can't be run locally, only inline ones`, input.Source.Type)
+		}
+
+		content, err := inlineContent(input.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		inputPath := filepath.Join(inputDir, strconv.Itoa(index))
+		if err := os.WriteFile(inputPath, content, 0644); err != nil {
+			return nil, fmt.Errorf("could not write the job's inputs: %w", err)
+		}
+
+		args = append(args, "--volume", fmt.Sprintf("%s:%s:ro", inputPath, input.Target))
+
+	}
+
+	if params.WorkingDirectory != "" {
+		args = append(args, "--workdir", params.WorkingDirectory)
+	}
+
+	// Docker only takes the first part of the entrypoint as a flag, so the
+	// rest goes before the parameters
+	command := []string{}
+
+	if len(params.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", params.Entrypoint[0])
+		command = append(command, params.Entrypoint[1:]...)
+	}
+
+	args = append(args, params.Image)
+	args = append(args, command...)
+	args = append(args, params.Parameters...)
+
+	return args, nil
+
+}
@@ -0,0 +1,80 @@
+package bacalhau
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Jobs run on compute nodes we don't control, so nothing in a job spec should
+// be a credential the bot itself holds. Jobs that write to storage are given a
+// presigned upload URL that's only good for one object, and jobs that need an
+// API key get a reference to a secret that the compute node resolves from its
+// own environment when the job starts.
+
+// SecretReference returns the value Bacalhau resolves to the compute node's
+// environment variable of the same name. Nodes must list the name in their
+// Compute.Env.AllowList for the job to be accepted.
+func SecretReference(name string) string {
+	return "env:" + name
+}
+
+// SetSecretReferences points each of the named environment variables of the
+// task at the compute node's own secret, leaving EnvironmentVariables for
+// values that are safe to publish.
+func SetSecretReferences(task map[string]interface{}, names ...string) {
+
+	nodeNames := map[string]string{}
+	for _, name := range names {
+		nodeNames[name] = name
+	}
+
+	SetRenamedSecretReferences(task, nodeNames)
+
+}
+
+// SetRenamedSecretReferences is SetSecretReferences for secrets that the
+// compute node keeps under another name. nodeNames maps each of the task's
+// environment variables to the node's name for it.
+func SetRenamedSecretReferences(task map[string]interface{}, nodeNames map[string]string) {
+
+	env, ok := task["Env"].(map[string]interface{})
+	if !ok {
+		env = map[string]interface{}{}
+	}
+
+	for name, nodeName := range nodeNames {
+		env[name] = SecretReference(nodeName)
+	}
+
+	task["Env"] = env
+
+}
+
+// findSecretReferences returns the path to every value in document that a
+// compute node would fill in from its own secrets. Jobs that people submit
+// can't have any, or they could read the secrets meant for the bot's jobs.
+func findSecretReferences(document interface{}, path string) []string {
+
+	found := []string{}
+
+	switch value := document.(type) {
+		case string:
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "env:") {
+				found = append(found, path)
+			}
+		case map[string]interface{}:
+			for key, child := range value {
+				found = append(found, findSecretReferences(child, strings.TrimPrefix(path + "." + key, "."))...)
+			}
+		case []interface{}:
+			for index, child := range value {
+				found = append(found, findSecretReferences(child, fmt.Sprintf("%s[%d]", path, index))...)
+			}
+	}
+
+	sort.Strings(found)
+
+	return found
+
+}
@@ -0,0 +1,160 @@
+package botsecrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Secrets are passed to jobs as environment variables, so they're named like
+// them. Names can't have "__" in them or end with "_", which keeps NodeName
+// from giving two bots' secrets the same name.
+var namePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+
+// Store is the secrets that operators have attached to community bots, by bot
+// and then by name. It's read from a file that's kept out of the repo, so
+// secrets can be changed without changing the bots.
+type Store map[string]map[string]string
+
+// Load reads the secrets file at path. There are no secrets if path is empty.
+// Errors never include the secrets themselves.
+func Load(path string) (Store, error) {
+
+	store := Store{}
+
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read secrets file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(content, &store); err != nil {
+		return nil, fmt.Errorf("could not parse secrets file %s. It should map each bot's name to its secrets", path)
+	}
+
+	errs := []error{}
+
+	for bot, secrets := range store {
+		for name, value := range secrets {
+
+			if !namePattern.MatchString(name) {
+				errs = append(errs, fmt.Errorf(`%s: secret "%s" must be named like an environment variable, in capitals and without "__"`, bot, name))
+			}
+
+			if value == "" {
+				errs = append(errs, fmt.Errorf("%s: secret %s is empty", bot, name))
+			}
+
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, errors.Join(errs...))
+	}
+
+	return store, nil
+
+}
+
+// For returns the secrets attached to bot that are in names, which are the
+// ones it declares, along with the names of any that aren't attached.
+func (s Store) For(bot string, names []string) (map[string]string, []string) {
+
+	secrets := map[string]string{}
+	missing := []string{}
+
+	for _, name := range names {
+		if value, found := s[bot][name]; found {
+			secrets[name] = value
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	return secrets, missing
+
+}
+
+// Undeclared returns the names of the secrets attached to bot that aren't in
+// names, which are never passed to it.
+func (s Store) Undeclared(bot string, names []string) []string {
+
+	undeclared := []string{}
+
+	for name := range s[bot] {
+		if !contains(names, name) {
+			undeclared = append(undeclared, name)
+		}
+	}
+
+	sort.Strings(undeclared)
+
+	return undeclared
+
+}
+
+// NodeName returns the environment variable that compute nodes keep bot's
+// secret called name in.
+// Nodes hold the secrets of every bot they run, so each is prefixed with its
+// bot's name, and a bot's job can't refer to another bot's secrets.
+func NodeName(bot, name string) string {
+	return "BBB_" + strings.ToUpper(strings.ReplaceAll(bot, "-", "_")) + "__" + name
+}
+
+// Values returns every secret, so they can be kept out of the logs.
+func (s Store) Values() []string {
+
+	values := []string{}
+
+	for _, secrets := range s {
+		for _, value := range secrets {
+			values = append(values, value)
+		}
+	}
+
+	return values
+
+}
+
+// Redact replaces every one of secrets in text.
+func Redact(text string, secrets map[string]string) string {
+
+	values := []string{}
+	for _, value := range secrets {
+		values = append(values, value)
+	}
+
+	// Replace the longest values first so a secret that contains another is replaced whole
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	for _, value := range values {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+
+	return text
+
+}
+
+func contains(values []string, value string) bool {
+
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,161 @@
+package botsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		want    Store
+		wantErr string
+	}{
+		{"valid", "weather:\n  WEATHER_API_KEY: abc123\n", Store{"weather": {"WEATHER_API_KEY": "abc123"}}, ""},
+		{"empty file", "", Store{}, ""},
+		{"lowercase name", "weather:\n  api_key: abc123\n", nil, `secret "api_key" must be named like an environment variable`},
+		{"double underscore", "weather:\n  WEATHER__API_KEY: abc123\n", nil, `secret "WEATHER__API_KEY" must be named like an environment variable`},
+		{"empty value", "weather:\n  WEATHER_API_KEY: \"\"\n", nil, "secret WEATHER_API_KEY is empty"},
+		{"not a map of bots", "- abc123\n", nil, "should map each bot's name to its secrets"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "secrets.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Load(path)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", test.wantErr, err)
+				}
+				if strings.Contains(err.Error(), "abc123") {
+					t.Errorf("error includes the secret: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+		})
+	}
+
+	if store, err := Load(""); err != nil || len(store) != 0 {
+		t.Errorf("expected no secrets without a file, got %v, %v", store, err)
+	}
+
+}
+
+func TestFor(t *testing.T) {
+
+	store := Store{
+		"weather": {"WEATHER_API_KEY": "abc123", "MAPS_API_KEY": "def456"},
+		"poet":    {"POET_API_KEY": "ghi789"},
+	}
+
+	tests := []struct {
+		name           string
+		bot            string
+		names          []string
+		want           map[string]string
+		wantMissing    []string
+		wantUndeclared []string
+	}{
+		{"all declared", "weather", []string{"WEATHER_API_KEY", "MAPS_API_KEY"}, map[string]string{"WEATHER_API_KEY": "abc123", "MAPS_API_KEY": "def456"}, []string{}, []string{}},
+		{"some undeclared", "weather", []string{"WEATHER_API_KEY"}, map[string]string{"WEATHER_API_KEY": "abc123"}, []string{}, []string{"MAPS_API_KEY"}},
+		{"missing", "weather", []string{"WEATHER_API_KEY", "SEARCH_API_KEY"}, map[string]string{"WEATHER_API_KEY": "abc123"}, []string{"SEARCH_API_KEY"}, []string{"MAPS_API_KEY"}},
+		{"another bot's secret", "poet", []string{"WEATHER_API_KEY"}, map[string]string{}, []string{"WEATHER_API_KEY"}, []string{"POET_API_KEY"}},
+		{"unknown bot", "calculator", []string{}, map[string]string{}, []string{}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got, missing := store.For(test.bot, test.names)
+
+			if !reflect.DeepEqual(got, test.want) || !reflect.DeepEqual(missing, test.wantMissing) {
+				t.Errorf("got %v and missing %v, want %v and %v", got, missing, test.want, test.wantMissing)
+			}
+
+			if undeclared := store.Undeclared(test.bot, test.names); !reflect.DeepEqual(undeclared, test.wantUndeclared) {
+				t.Errorf("got undeclared %v, want %v", undeclared, test.wantUndeclared)
+			}
+
+		})
+	}
+
+}
+
+func TestNodeName(t *testing.T) {
+
+	tests := []struct {
+		bot  string
+		name string
+		want string
+	}{
+		{"weather", "API_KEY", "BBB_WEATHER__API_KEY"},
+		{"rain-radar", "API_KEY", "BBB_RAIN_RADAR__API_KEY"},
+		{"rain--radar", "API_KEY", "BBB_RAIN__RADAR__API_KEY"},
+		{"rain", "RADAR_API_KEY", "BBB_RAIN__RADAR_API_KEY"},
+		{"rain-radar", "KEY", "BBB_RAIN_RADAR__KEY"},
+		{"rain", "RADAR__KEY", ""},
+	}
+
+	for _, test := range tests {
+
+		// Names that could give two bots' secrets the same node name aren't allowed
+		if test.want == "" {
+			if namePattern.MatchString(test.name) {
+				t.Errorf("%s is allowed as a secret name", test.name)
+			}
+			continue
+		}
+
+		if got := NodeName(test.bot, test.name); got != test.want {
+			t.Errorf("%s's %s: got %s, want %s", test.bot, test.name, got, test.want)
+		}
+
+	}
+
+}
+
+func TestRedact(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		text    string
+		secrets map[string]string
+		want    string
+	}{
+		{"no secrets", "the key is abc123", map[string]string{}, "the key is abc123"},
+		{"every occurrence", "abc123 and abc123", map[string]string{"API_KEY": "abc123"}, "[REDACTED] and [REDACTED]"},
+		{"several secrets", "abc123:def456", map[string]string{"API_KEY": "abc123", "API_SECRET": "def456"}, "[REDACTED]:[REDACTED]"},
+		{"secret containing another", "token abc123xyz", map[string]string{"SHORT_KEY": "abc", "LONG_KEY": "abc123xyz"}, "token [REDACTED]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if got := Redact(test.text, test.secrets); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+
+		})
+	}
+
+}
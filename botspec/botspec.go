@@ -0,0 +1,434 @@
+package botspec
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bbb/bacalhau"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed info.schema.json
+var InfoSchema string
+
+var infoSchema = jsonschema.MustCompileString("info.schema.json", InfoSchema)
+
+// Bot is a community bot, read from the info.json and job.yaml files in its
+// directory.
+type Bot struct {
+	Name                 string   `json:"name"`
+	Storage              bool     `json:"storage"`
+	EnvironmentVariables []string `json:"environmentVariables"`
+	Repo                 string   `json:"repo"`
+	Author               string   `json:"author"`
+
+	// Secrets are the names of the secrets the bot needs. Their values are
+	// attached by the operators, and kept out of the repo.
+	Secrets []string `json:"secrets"`
+
+	// Type is the single trigger of bots that don't list their triggers. It's
+	// read into Triggers, so Triggers is all that needs to be checked.
+	Type     string    `json:"type,omitempty"`
+	Triggers []Trigger `json:"triggers"`
+
+	// JobFile is the contents of job.yaml
+	JobFile string `json:"-"`
+}
+
+// reservedNames are the environment variables that can be asked for in
+// environmentVariables, which secrets can't replace.
+var reservedNames = map[string]bool{
+	"POST": true,
+	"FROM": true,
+	"IMAGES": true,
+	"PROCESSED_POST": true,
+	"WHOAMI": true,
+	"INPUT": true,
+	"INPUT_FILE": true,
+	"OUTPUT_URL": true,
+	"TRIGGER": true,
+	"MESSAGE": true,
+	"KEYWORDS": true,
+	"SCHEDULED_AT": true,
+}
+
+// ResourcePolicy caps what a community bot's job can ask for.
+type ResourcePolicy struct {
+	MaxCPU    float64
+	MaxMemory int64
+	MaxDisk   int64
+	MaxGPU    int
+
+	// MaxExecutionTimeout is in seconds
+	MaxExecutionTimeout int64
+}
+
+// Problem is one thing wrong with a bot definition.
+type Problem struct {
+	File    string
+	Field   string
+	Message string
+}
+
+func (p Problem) Error() string {
+
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Field, p.Message)
+
+}
+
+// Problems is every problem found with a bot definition.
+type Problems []Problem
+
+func (p Problems) Error() string {
+
+	messages := make([]string, len(p))
+	for index, problem := range p {
+		messages[index] = problem.Error()
+	}
+
+	return strings.Join(messages, "\n")
+
+}
+
+// IsBotDir reports whether dir holds a bot definition, rather than a
+// directory of them.
+func IsBotDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "info.json"))
+	return err == nil
+}
+
+// Load reads and validates the bot in dir. The bot is only usable if there
+// are no problems.
+func Load(dir string, policy ResourcePolicy) (Bot, Problems) {
+
+	bot := Bot{}
+	problems := Problems{}
+
+	infoPath := filepath.Join(dir, "info.json")
+	jobPath := filepath.Join(dir, "job.yaml")
+
+	infoFile, iErr := os.ReadFile(infoPath)
+	if iErr != nil {
+		problems = append(problems, Problem{File: infoPath, Message: fmt.Sprintf("could not read the file: %v", iErr)})
+	} else {
+		problems = append(problems, validateInfo(infoPath, infoFile, &bot)...)
+	}
+
+	if bot.Name != "" && bot.Name != filepath.Base(filepath.Clean(dir)) {
+		problems = append(problems, Problem{File: infoPath, Field: "name", Message: fmt.Sprintf(`"%s" must match the bot's directory, "%s"`, bot.Name, filepath.Base(filepath.Clean(dir)))})
+	}
+
+	jobFile, jErr := os.ReadFile(jobPath)
+	if jErr != nil {
+		problems = append(problems, Problem{File: jobPath, Message: fmt.Sprintf("could not read the file: %v", jErr)})
+	} else {
+		bot.JobFile = string(jobFile)
+		problems = append(problems, validateJob(jobPath, bot.JobFile, policy)...)
+	}
+
+	return bot, problems
+
+}
+
+// LoadAll reads every bot in root, checking each against the policy that
+// policyFor returns for its name. It returns the bots that are valid, the
+// names of any that aren't, and an error listing their problems.
+func LoadAll(root string, policyFor func(name string) ResourcePolicy) ([]Bot, []string, error) {
+
+	bots := []Bot{}
+	broken := []string{}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return bots, broken, err
+	}
+
+	errs := []error{}
+
+	for _, entry := range entries {
+
+		if !entry.IsDir() {
+			continue
+		}
+
+		// A bot's name has to match its directory
+		bot, problems := Load(filepath.Join(root, entry.Name()), policyFor(entry.Name()))
+
+		if len(problems) > 0 {
+			errs = append(errs, problems)
+			broken = append(broken, entry.Name())
+			continue
+		}
+
+		bots = append(bots, bot)
+
+	}
+
+	return bots, broken, errors.Join(errs...)
+
+}
+
+// Lint checks path, which is either a single bot's directory or a directory
+// of them, checking each bot against the policy that policyFor returns for
+// its name. It returns the names of the bots it checked and any problems.
+func Lint(path string, policyFor func(name string) ResourcePolicy) ([]string, Problems) {
+
+	if IsBotDir(path) {
+		name := filepath.Base(filepath.Clean(path))
+		_, problems := Load(path, policyFor(name))
+		return []string{name}, problems
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, Problems{{File: path, Message: fmt.Sprintf("could not read the directory: %v", err)}}
+	}
+
+	checked := []string{}
+	problems := Problems{}
+
+	for _, entry := range entries {
+
+		if !entry.IsDir() {
+			continue
+		}
+
+		_, botProblems := Load(filepath.Join(path, entry.Name()), policyFor(entry.Name()))
+
+		checked = append(checked, entry.Name())
+		problems = append(problems, botProblems...)
+
+	}
+
+	if len(checked) == 0 {
+		problems = append(problems, Problem{File: path, Message: "no bots found. Expected a bot directory with an info.json, or a directory of them"})
+	}
+
+	return checked, problems
+
+}
+
+// validateInfo checks info.json against the schema, and reads it into bot.
+func validateInfo(path string, content []byte, bot *Bot) Problems {
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return Problems{{File: path, Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	problems := Problems{}
+
+	if err := infoSchema.Validate(document); err != nil {
+
+		var validationErr *jsonschema.ValidationError
+		if !errors.As(err, &validationErr) {
+			return Problems{{File: path, Message: err.Error()}}
+		}
+
+		for _, cause := range leafCauses(validationErr) {
+			problems = append(problems, Problem{
+				File: path,
+				Field: strings.TrimPrefix(cause.InstanceLocation, "/"),
+				Message: cause.Message,
+			})
+		}
+
+	}
+
+	// Read as much as we can, even if it's invalid, so later checks can run
+	json.Unmarshal(content, bot)
+
+	_, hasType := fields(document)["type"]
+	_, hasTriggers := fields(document)["triggers"]
+
+	switch {
+		case hasType && hasTriggers:
+			problems = append(problems, Problem{File: path, Field: "type", Message: "can't be set as well as triggers. List every trigger in triggers"})
+		case !hasType && !hasTriggers:
+			problems = append(problems, Problem{File: path, Message: "needs triggers, to say what the bot is invoked for"})
+		case len(bot.Triggers) == 0 && bot.Type != "":
+			bot.Triggers = []Trigger{{Type: bot.Type}}
+	}
+
+	for index, name := range bot.Secrets {
+		if reservedNames[name] {
+			problems = append(problems, Problem{File: path, Field: fmt.Sprintf("secrets/%d", index), Message: fmt.Sprintf("%s is set by the bot, so it can't be a secret", name)})
+		}
+	}
+
+	return append(problems, validateTriggers(path, *bot)...)
+
+}
+
+// fields returns the fields of a JSON object, or nothing if it isn't one.
+func fields(document interface{}) map[string]interface{} {
+	object, _ := document.(map[string]interface{})
+	return object
+}
+
+func leafCauses(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+
+	leaves := []*jsonschema.ValidationError{}
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafCauses(cause)...)
+	}
+
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return leaves[i].InstanceLocation < leaves[j].InstanceLocation
+	})
+
+	return leaves
+
+}
+
+// validateJob checks job.yaml against the job model, and what it asks for
+// against the resource policy.
+func validateJob(path, jobFile string, policy ResourcePolicy) Problems {
+
+	problems := Problems{}
+
+	invalid := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: path, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	job, err := bacalhau.ParseJob(jobFile)
+	if err != nil {
+		for _, message := range yamlErrors(err) {
+			invalid("", "%s", message)
+		}
+		return problems
+	}
+
+	// Bots are run once per mention, and the bot waits for their output
+	if job.Type != "batch" {
+		invalid("Type", `must be batch, not "%s"`, job.Type)
+	}
+
+	if job.Count > 1 {
+		invalid("Count", "must be 1, not %d", job.Count)
+	}
+
+	if len(job.Tasks) != 1 {
+		invalid("Tasks", "must have exactly 1 task, not %d", len(job.Tasks))
+		return problems
+	}
+
+	task := job.Tasks[0]
+
+	params, paramsErr := task.DockerParams()
+	if paramsErr != nil {
+		for _, message := range yamlErrors(paramsErr) {
+			invalid("Tasks[0].Engine", "%s", message)
+		}
+	} else {
+
+		if params.Image == "" {
+			invalid("Tasks[0].Engine.Params.Image", "is required")
+		}
+
+		if len(params.EnvironmentVariables) > 0 {
+			invalid("Tasks[0].Engine.Params.EnvironmentVariables", "is set by the bot from environmentVariables in info.json, so it must be left out")
+		}
+
+	}
+
+	// Compute nodes fill in Env from their own secrets, which includes other bots'
+	if len(task.Env) > 0 {
+		invalid("Tasks[0].Env", "is set by the bot from secrets in info.json, so it must be left out")
+	}
+
+	problems = append(problems, validateResources(path, task, policy)...)
+
+	if task.Timeouts.ExecutionTimeout > policy.MaxExecutionTimeout {
+		invalid("Tasks[0].Timeouts.ExecutionTimeout", "must be at most %d seconds, not %d", policy.MaxExecutionTimeout, task.Timeouts.ExecutionTimeout)
+	}
+
+	return problems
+
+}
+
+// yamlErrors splits an error from decoding YAML into one message per problem.
+func yamlErrors(err error) []string {
+
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return []string{err.Error()}
+	}
+
+	return typeErr.Errors
+
+}
+
+func validateResources(path string, task bacalhau.Task, policy ResourcePolicy) Problems {
+
+	problems := Problems{}
+
+	invalid := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: path, Field: "Tasks[0].Resources." + field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if task.Resources.CPU == "" {
+		invalid("CPU", "is required")
+	} else if cpu, err := bacalhau.ParseCPU(task.Resources.CPU); err != nil {
+		invalid("CPU", "%v", err)
+	} else if cpu > policy.MaxCPU {
+		invalid("CPU", "must be at most %g, not %s", policy.MaxCPU, task.Resources.CPU)
+	}
+
+	if task.Resources.Memory == "" {
+		invalid("Memory", "is required")
+	} else if memory, err := bacalhau.ParseBytes(task.Resources.Memory); err != nil {
+		invalid("Memory", "%v", err)
+	} else if memory > policy.MaxMemory {
+		invalid("Memory", "must be at most %s, not %s", formatBytes(policy.MaxMemory), task.Resources.Memory)
+	}
+
+	if task.Resources.Disk != "" {
+		if disk, err := bacalhau.ParseBytes(task.Resources.Disk); err != nil {
+			invalid("Disk", "%v", err)
+		} else if disk > policy.MaxDisk {
+			invalid("Disk", "must be at most %s, not %s", formatBytes(policy.MaxDisk), task.Resources.Disk)
+		}
+	}
+
+	if task.Resources.GPU != "" {
+		if gpu, err := bacalhau.ParseCPU(task.Resources.GPU); err != nil || gpu != float64(int(gpu)) {
+			invalid("GPU", `"%s" must be a whole number of GPUs`, task.Resources.GPU)
+		} else if int(gpu) > policy.MaxGPU {
+			invalid("GPU", "must be at most %d, not %s", policy.MaxGPU, task.Resources.GPU)
+		}
+	}
+
+	return problems
+
+}
+
+func formatBytes(size int64) string {
+
+	if size >= 1000*1000*1000 && size%(1000*1000*1000) == 0 {
+		return fmt.Sprintf("%dGB", size/(1000*1000*1000))
+	}
+
+	return fmt.Sprintf("%dMB", size/(1000*1000))
+
+}
@@ -0,0 +1,174 @@
+package botspec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testPolicy = ResourcePolicy{
+	MaxCPU: 2,
+	MaxMemory: 2 * 1000 * 1000 * 1000,
+	MaxDisk: 10 * 1000 * 1000 * 1000,
+	MaxGPU: 0,
+	MaxExecutionTimeout: 300,
+}
+
+const testInfo = `{
+	"name": "calculator",
+	"storage": false,
+	"environmentVariables": ["POST"],
+	"type": "mention",
+	"repo": "https://github.com/example/calculator",
+	"author": "@example.bsky.social"
+}`
+
+const testJob = `Name: calculator
+Type: batch
+Count: 1
+Tasks:
+  - Name: main
+    Engine:
+      Type: docker
+      Params:
+        Image: example/calculator:latest
+    Resources:
+      CPU: "1"
+      Memory: 128MB
+`
+
+// writeBot writes a bot's files to a directory named after it in root.
+func writeBot(t *testing.T, root, name, info, job string) string {
+
+	dir := filepath.Join(root, name)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "info.json"), []byte(info), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "job.yaml"), []byte(job), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+
+}
+
+func TestLoad(t *testing.T) {
+
+	tests := []struct {
+		name string
+		dir string
+		info string
+		job string
+		wantFields []string
+	}{
+		{"valid", "calculator", testInfo, testJob, nil},
+		{"name doesn't match the directory", "calc", testInfo, testJob, []string{"name"}},
+		{"invalid JSON", "calculator", "{", testJob, []string{""}},
+		{"unknown field", "calculator", strings.Replace(testInfo, `"storage"`, `"colour": "red", "storage"`, 1), testJob, []string{""}},
+		{"type and triggers", "calculator", strings.Replace(testInfo, `"type": "mention"`, `"type": "mention", "triggers": [{"type": "dm"}]`, 1), testJob, []string{"type"}},
+		{"reserved secret", "calculator", strings.Replace(testInfo, `"storage"`, `"secrets": ["POST"], "storage"`, 1), testJob, []string{"secrets/0"}},
+		{"invalid schedule", "calculator", strings.Replace(testInfo, `"type": "mention"`, `"triggers": [{"type": "schedule", "schedule": "soon"}]`, 1), testJob, []string{"triggers/0/schedule"}},
+		{"service job", "calculator", testInfo, strings.Replace(testJob, "Type: batch", "Type: service", 1), []string{"Type"}},
+		{"misspelt field", "calculator", testInfo, strings.Replace(testJob, "Count: 1", "Cuont: 1", 1), []string{""}},
+		{"too much CPU", "calculator", testInfo, strings.Replace(testJob, `CPU: "1"`, `CPU: "4"`, 1), []string{"Tasks[0].Resources.CPU"}},
+		{"too much memory", "calculator", testInfo, strings.Replace(testJob, "Memory: 128MB", "Memory: 4GB", 1), []string{"Tasks[0].Resources.Memory"}},
+		{"secret with a double underscore", "calculator", strings.Replace(testInfo, `"storage"`, `"secrets": ["API__KEY"], "storage"`, 1), testJob, []string{"secrets/0"}},
+		{"Env in the job", "calculator", testInfo, testJob + "    Env:\n      API_KEY: env:OTHER_BOT_API_KEY\n", []string{"Tasks[0].Env"}},
+		{"environment variables in the job", "calculator", testInfo, strings.Replace(testJob, "Image: example/calculator:latest", "Image: example/calculator:latest\n        EnvironmentVariables: [\"A=1\"]", 1), []string{"Tasks[0].Engine.Params.EnvironmentVariables"}},
+		{"long timeout", "calculator", testInfo, testJob + "    Timeouts:\n      ExecutionTimeout: 600\n", []string{"Tasks[0].Timeouts.ExecutionTimeout"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dir := writeBot(t, t.TempDir(), test.dir, test.info, test.job)
+
+			bot, problems := Load(dir, testPolicy)
+
+			fields := []string{}
+			for _, problem := range problems {
+				fields = append(fields, problem.Field)
+			}
+
+			if len(test.wantFields) == 0 {
+				if len(problems) > 0 {
+					t.Fatalf("unexpected problems:\n%v", problems)
+				}
+				if bot.Name != "calculator" || !reflect.DeepEqual(bot.Triggers, []Trigger{{Type: TriggerMention}}) {
+					t.Errorf("unexpected bot %+v", bot)
+				}
+				return
+			}
+
+			for _, want := range test.wantFields {
+				if !contains(fields, want) {
+					t.Errorf("no problem with %q in:\n%v", want, problems)
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestLoadAllAndLint(t *testing.T) {
+
+	root := t.TempDir()
+
+	writeBot(t, root, "calculator", testInfo, testJob)
+	writeBot(t, root, "poet", strings.Replace(testInfo, "calculator", "poet", 1), strings.Replace(testJob, `CPU: "1"`, `CPU: "4"`, 1))
+
+	// poet has been allowed more CPU than other bots
+	policyFor := func(name string) ResourcePolicy {
+		policy := testPolicy
+		if name == "poet" {
+			policy.MaxCPU = 4
+		}
+		return policy
+	}
+
+	bots, broken, err := LoadAll(root, policyFor)
+	if err != nil || len(broken) > 0 || len(bots) != 2 {
+		t.Fatalf("expected both bots, got %d, broken %v, error %v", len(bots), broken, err)
+	}
+
+	bots, broken, err = LoadAll(root, func(name string) ResourcePolicy { return testPolicy })
+	if err == nil || !reflect.DeepEqual(broken, []string{"poet"}) || len(bots) != 1 || bots[0].Name != "calculator" {
+		t.Errorf("expected only poet to be broken, got %d bots, broken %v, error %v", len(bots), broken, err)
+	}
+
+	checked, problems := Lint(root, policyFor)
+	if !reflect.DeepEqual(checked, []string{"calculator", "poet"}) || len(problems) > 0 {
+		t.Errorf("linting the directory checked %v and found %v", checked, problems)
+	}
+
+	checked, problems = Lint(filepath.Join(root, "poet"), func(name string) ResourcePolicy { return testPolicy })
+	if !reflect.DeepEqual(checked, []string{"poet"}) || len(problems) != 1 {
+		t.Errorf("linting one bot checked %v and found %v", checked, problems)
+	}
+
+	if _, problems := Lint(t.TempDir(), policyFor); len(problems) != 1 {
+		t.Errorf("expected a problem for a directory without bots, got %v", problems)
+	}
+
+}
+
+func contains(values []string, value string) bool {
+
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,220 @@
+package botspec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bbb/bsky"
+)
+
+// InputFilePath is where the input is mounted for bots that ask for
+// INPUT_FILE.
+const InputFilePath = "/bbb/input.json"
+
+// StorageDir is where the storage of bots with storage enabled is mounted.
+// The bot's own storage is in bot.json, and its storage for whoever invoked
+// it is in user.json.
+const StorageDir = "/bbb/storage"
+
+// Input is everything a community bot is told about what invoked it. Bots
+// get it as JSON, in the INPUT environment variable or the file at
+// INPUT_FILE, and the other environment variables are taken from it.
+type Input struct {
+
+	// Version is the version of this format
+	Version int `json:"bbbInput"`
+
+	// Bot is the handle of the bot's account
+	Bot string `json:"bot"`
+
+	// Trigger is what invoked the bot
+	Trigger string `json:"trigger"`
+
+	// Post is the post that invoked the bot, for mention, reply, quote and
+	// keyword triggers
+	Post *InputPost `json:"post,omitempty"`
+
+	// ProcessedText is the post's text without the mention of the bot
+	ProcessedText string `json:"processedText"`
+
+	// Parent is the post that the post replied to, if it's a reply
+	Parent *InputPost `json:"parent,omitempty"`
+
+	// Quoted is the post that the post quoted, if it's a quote
+	Quoted *InputPost `json:"quoted,omitempty"`
+
+	// Keywords are the bot's keywords that the post has, for keyword triggers
+	Keywords []string `json:"keywords,omitempty"`
+
+	// Message is the direct message to the bot, for dm triggers
+	Message *InputMessage `json:"message,omitempty"`
+
+	// Follower is who followed the bot, for follow triggers
+	Follower *InputAuthor `json:"follower,omitempty"`
+
+	// ScheduledAt is when the bot was due to run, for schedule triggers
+	ScheduledAt string `json:"scheduledAt,omitempty"`
+}
+
+type InputMessage struct {
+	ID      string      `json:"id"`
+	ConvoID string      `json:"convoId"`
+	Text    string      `json:"text"`
+	SentAt  string      `json:"sentAt"`
+	Sender  InputAuthor `json:"sender"`
+}
+
+type InputPost struct {
+	URI       string       `json:"uri"`
+	CID       string       `json:"cid"`
+	Text      string       `json:"text"`
+	CreatedAt string       `json:"createdAt"`
+	Langs     []string     `json:"langs"`
+	Author    InputAuthor  `json:"author"`
+	Images    []InputImage `json:"images"`
+}
+
+type InputAuthor struct {
+	DID         string `json:"did"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName"`
+}
+
+type InputImage struct {
+	Alt          string `json:"alt"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	FullsizeURL  string `json:"fullsizeUrl"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+}
+
+// NewInput describes the post that invoked the bot with the handle bot.
+// parent and quoted are the posts it replied to or quoted, if there are any.
+func NewInput(bot, trigger string, notif bsky.Notification, parent, quoted *bsky.Post) Input {
+
+	// Only the images are read from notif.Post, as the rest comes from the notification
+	post := inputPost(bsky.Post{
+		Uri: notif.Uri,
+		Cid: notif.Cid,
+		Author: notif.Author,
+		Record: notif.Record,
+		Images: notif.Post.Images,
+	})
+
+	input := Input{
+		Version: 1,
+		Bot: bot,
+		Trigger: trigger,
+		Post: &post,
+		ProcessedText: strings.Replace(notif.Record.Text, fmt.Sprintf("@%s", bot), "", -1),
+	}
+
+	if parent != nil {
+		parentPost := inputPost(*parent)
+		input.Parent = &parentPost
+	}
+
+	if quoted != nil {
+		quotedPost := inputPost(*quoted)
+		input.Quoted = &quotedPost
+	}
+
+	return input
+
+}
+
+// NewMessageInput describes a direct message to the bot with the handle bot.
+func NewMessageInput(bot, convoID string, message bsky.ChatMessage, sender bsky.Author) Input {
+	return Input{
+		Version: 1,
+		Bot: bot,
+		Trigger: TriggerDM,
+		Message: &InputMessage{
+			ID: message.Id,
+			ConvoID: convoID,
+			Text: message.Text,
+			SentAt: message.SentAt,
+			Sender: inputAuthor(sender),
+		},
+	}
+}
+
+// NewFollowInput describes a new follower of the bot with the handle bot.
+func NewFollowInput(bot string, follower bsky.Author) Input {
+
+	author := inputAuthor(follower)
+
+	return Input{
+		Version: 1,
+		Bot: bot,
+		Trigger: TriggerFollow,
+		Follower: &author,
+	}
+
+}
+
+// NewScheduleInput describes a scheduled run of the bot with the handle bot.
+func NewScheduleInput(bot string, scheduledAt time.Time) Input {
+	return Input{
+		Version: 1,
+		Bot: bot,
+		Trigger: TriggerSchedule,
+		ScheduledAt: scheduledAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// User returns whoever invoked the bot, or nil if it was invoked by its
+// schedule.
+func (i Input) User() *InputAuthor {
+
+	switch {
+		case i.Post != nil:
+			return &i.Post.Author
+		case i.Message != nil:
+			return &i.Message.Sender
+		case i.Follower != nil:
+			return i.Follower
+	}
+
+	return nil
+
+}
+
+func inputPost(post bsky.Post) InputPost {
+
+	described := InputPost{
+		URI: post.Uri,
+		CID: post.Cid,
+		Text: post.Record.Text,
+		CreatedAt: post.Record.CreatedAt,
+		Langs: post.Record.Langs,
+		Author: inputAuthor(post.Author),
+		Images: []InputImage{},
+	}
+
+	if described.Langs == nil {
+		described.Langs = []string{}
+	}
+
+	for _, image := range post.Images {
+		described.Images = append(described.Images, InputImage{
+			Alt: image.Alt,
+			ThumbnailURL: image.Url,
+			FullsizeURL: image.FullsizeUrl,
+			Width: image.AspectRatio.Width,
+			Height: image.AspectRatio.Height,
+		})
+	}
+
+	return described
+
+}
+
+func inputAuthor(author bsky.Author) InputAuthor {
+	return InputAuthor{
+		DID: author.Did,
+		Handle: author.Handle,
+		DisplayName: author.DisplayName,
+	}
+}
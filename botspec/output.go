@@ -0,0 +1,312 @@
+package botspec
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"bbb/bsky"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed output.schema.json
+var OutputSchema string
+
+var outputSchema = jsonschema.MustCompileString("output.schema.json", OutputSchema)
+
+// Output is what a community bot replies with. Bots either print plain text,
+// which is posted as it is, or JSON in the format described by
+// output.schema.json.
+type Output struct {
+
+	// Version is 0 for plain text
+	Version int `json:"bbbOutput"`
+
+	// The first post, if there is one
+	Reply
+
+	Replies []Reply        `json:"replies"`
+	NoReply bool           `json:"noReply"`
+	Labels  []string       `json:"labels"`
+	Storage *OutputStorage `json:"storage"`
+	Error   string         `json:"error"`
+}
+
+// OutputStorage are changes to a bot's storage. Keys set to null are removed.
+type OutputStorage struct {
+	Bot  map[string]json.RawMessage `json:"bot"`
+	User map[string]json.RawMessage `json:"user"`
+}
+
+// Reply is a single post.
+type Reply struct {
+	Text   string        `json:"text"`
+	Images []OutputImage `json:"images"`
+	Link   *OutputLink   `json:"link"`
+}
+
+// OutputImage is an image to attach to a post, given either as a URL to
+// download it from or as base64 encoded data.
+type OutputImage struct {
+	URL  string `json:"url"`
+	Data string `json:"data"`
+	Alt  string `json:"alt"`
+}
+
+type OutputLink struct {
+	URI         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// IsEmpty reports whether the reply has nothing to post.
+func (r Reply) IsEmpty() bool {
+	return strings.TrimSpace(r.Text) == "" && len(r.Images) == 0 && r.Link == nil
+}
+
+// Posts returns every post in the output, in the order they're made.
+func (o Output) Posts() []Reply {
+
+	posts := []Reply{}
+
+	if !o.Reply.IsEmpty() {
+		posts = append(posts, o.Reply)
+	}
+
+	return append(posts, o.Replies...)
+
+}
+
+// ParseOutput reads what a bot printed. Output that's a JSON object with a
+// bbbOutput field is checked against the output format, and the problems
+// with it are returned if it doesn't match. Anything else is plain text.
+func ParseOutput(printed string) (Output, error) {
+
+	trimmed := strings.TrimSpace(printed)
+	plain := Output{Reply: Reply{Text: trimmed}}
+
+	if !strings.HasPrefix(trimmed, "{") {
+		return plain, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return plain, nil
+	}
+
+	// Bots that happen to print JSON keep having it posted as it is
+	fields, isObject := document.(map[string]interface{})
+	if _, hasVersion := fields["bbbOutput"]; !isObject || !hasVersion {
+		return plain, nil
+	}
+
+	problems := Problems{}
+
+	invalid := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: "output", Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := outputSchema.Validate(document); err != nil {
+
+		var validationErr *jsonschema.ValidationError
+		if !errors.As(err, &validationErr) {
+			return Output{}, err
+		}
+
+		for _, cause := range leafCauses(validationErr) {
+			invalid(strings.TrimPrefix(cause.InstanceLocation, "/"), "%s", cause.Message)
+		}
+
+		return Output{}, problems
+
+	}
+
+	var output Output
+	if err := json.Unmarshal([]byte(trimmed), &output); err != nil {
+		return Output{}, err
+	}
+
+	for index, post := range output.Posts() {
+
+		if len(post.Images) > 0 && post.Link != nil {
+			invalid(fmt.Sprintf("post %d", index+1), "can embed images or a link card, but not both")
+		}
+
+		for imageIndex, image := range post.Images {
+			if image.Data == "" {
+				continue
+			}
+			if _, err := base64.StdEncoding.DecodeString(image.Data); err != nil {
+				invalid(fmt.Sprintf("post %d: images/%d/data", index+1, imageIndex), "isn't valid base64: %v", err)
+			}
+		}
+
+	}
+
+	if len(output.Posts()) == 0 && !output.NoReply && output.Error == "" {
+		invalid("", "has nothing to post. Set text, images, link or replies, or set noReply to stay silent")
+	}
+
+	if len(problems) > 0 {
+		return Output{}, problems
+	}
+
+	return output, nil
+
+}
+
+// imageClient downloads the images that bots link to. Bots choose the URLs,
+// so it only connects to public addresses, and only over https, including
+// when it's redirected.
+var imageClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("redirected to %s, which isn't https", req.URL.Redacted())
+		}
+		if len(via) >= 10 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// sharedAddressSpace is used for carrier-grade NAT, and by some clouds for
+// their metadata services.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// dialPublicOnly refuses to connect to loopback, private, link-local and
+// other addresses that aren't on the public internet. It runs after the host
+// is resolved, so it also catches public names that resolve to them.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	ip = ip.Unmap()
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%s isn't a public address", ip)
+	}
+
+	return nil
+
+}
+
+// Load returns the image itself, downloading it if it was given as a URL.
+func (i OutputImage) Load() ([]byte, error) {
+
+	var content []byte
+
+	if i.Data != "" {
+
+		decoded, err := base64.StdEncoding.DecodeString(i.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %w", err)
+		}
+
+		content = decoded
+
+	} else {
+
+		imageURL, err := url.Parse(i.URL)
+		if err != nil || imageURL.Scheme != "https" {
+			return nil, fmt.Errorf("could not download image %s: only https URLs are allowed", i.URL)
+		}
+
+		resp, err := imageClient.Get(imageURL.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not download image: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("could not download image %s: status code %d", i.URL, resp.StatusCode)
+		}
+
+		var buffer bytes.Buffer
+		if _, err := io.Copy(&buffer, io.LimitReader(resp.Body, bsky.MaxImageSize + 1)); err != nil {
+			return nil, fmt.Errorf("could not download image: %w", err)
+		}
+
+		content = buffer.Bytes()
+
+	}
+
+	if len(content) > bsky.MaxImageSize {
+		return nil, fmt.Errorf("image is larger than Bluesky's limit of %d bytes", bsky.MaxImageSize)
+	}
+
+	if !strings.HasPrefix(http.DetectContentType(content), "image/") {
+		return nil, fmt.Errorf("isn't an image")
+	}
+
+	return content, nil
+
+}
+
+// Drafts turns the output into the posts to make, downloading or decoding
+// any images.
+func (o Output) Drafts() ([]bsky.Draft, error) {
+
+	drafts := []bsky.Draft{}
+
+	for index, post := range o.Posts() {
+
+		draft := bsky.Draft{
+			Text: post.Text,
+			Labels: o.Labels,
+		}
+
+		for imageIndex, image := range post.Images {
+
+			content, err := image.Load()
+			if err != nil {
+				return nil, fmt.Errorf("post %d, image %d: %w", index+1, imageIndex+1, err)
+			}
+
+			draft.Images = append(draft.Images, bsky.DraftImage{Data: content, Alt: image.Alt})
+
+		}
+
+		if post.Link != nil {
+			draft.Link = &bsky.LinkCard{
+				Uri: post.Link.URI,
+				Title: post.Link.Title,
+				Description: post.Link.Description,
+			}
+		}
+
+		drafts = append(drafts, draft)
+
+	}
+
+	return drafts, nil
+
+}
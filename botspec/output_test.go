@@ -0,0 +1,130 @@
+package botspec
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+
+	tests := []struct {
+		name string
+		printed string
+		wantVersion int
+		wantPosts []string
+		wantFields []string
+	}{
+		{"plain text", "  4\n", 0, []string{"4"}, nil},
+		{"JSON without a version is plain text", `{"answer": 4}`, 0, []string{`{"answer": 4}`}, nil},
+		{"JSON followed by text is plain text", `{"answer": 4} and more`, 0, []string{`{"answer": 4} and more`}, nil},
+		{"text", `{"bbbOutput": 1, "text": "4"}`, 1, []string{"4"}, nil},
+		{"thread", `{"bbbOutput": 1, "text": "first", "replies": [{"text": "second"}, {"text": "third"}]}`, 1, []string{"first", "second", "third"}, nil},
+		{"replies only", `{"bbbOutput": 1, "replies": [{"text": "second"}]}`, 1, []string{"second"}, nil},
+		{"no reply", `{"bbbOutput": 1, "noReply": true}`, 1, []string{}, nil},
+		{"error", `{"bbbOutput": 1, "error": "no such city"}`, 1, []string{}, nil},
+		{"unknown version", `{"bbbOutput": 2, "text": "4"}`, 0, nil, []string{"bbbOutput"}},
+		{"unknown field", `{"bbbOutput": 1, "txt": "4"}`, 0, nil, []string{""}},
+		{"nothing to post", `{"bbbOutput": 1}`, 0, nil, []string{""}},
+		{"images and a link", `{"bbbOutput": 1, "images": [{"data": "aGk=", "alt": "hi"}], "link": {"uri": "https://example.com"}}`, 0, nil, []string{"post 1"}},
+		{"invalid image data", `{"bbbOutput": 1, "images": [{"data": "not base64!", "alt": "hi"}]}`, 0, nil, []string{"post 1: images/0/data"}},
+		{"image over http", `{"bbbOutput": 1, "images": [{"url": "http://example.com/cat.png", "alt": "a cat"}]}`, 0, nil, []string{"images/0/url"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			output, err := ParseOutput(test.printed)
+
+			if len(test.wantFields) > 0 {
+
+				problems, isProblems := err.(Problems)
+				if !isProblems {
+					t.Fatalf("expected problems, got %v", err)
+				}
+
+				fields := []string{}
+				for _, problem := range problems {
+					fields = append(fields, problem.Field)
+				}
+
+				for _, want := range test.wantFields {
+					if !contains(fields, want) {
+						t.Errorf("no problem with %q in:\n%v", want, problems)
+					}
+				}
+
+				return
+
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			posts := []string{}
+			for _, post := range output.Posts() {
+				posts = append(posts, post.Text)
+			}
+
+			if output.Version != test.wantVersion || strings.Join(posts, "|") != strings.Join(test.wantPosts, "|") {
+				t.Errorf("got version %d and posts %q, want %d and %q", output.Version, posts, test.wantVersion, test.wantPosts)
+			}
+
+		})
+	}
+
+}
+
+func TestOutputImageLoad(t *testing.T) {
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+	// A server on the bot's own network, which bots mustn't be able to reach
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		image OutputImage
+		wantErr string
+	}{
+		{"data", OutputImage{Data: base64.StdEncoding.EncodeToString(png)}, ""},
+		{"invalid data", OutputImage{Data: "not base64!"}, "invalid image data"},
+		{"not an image", OutputImage{Data: base64.StdEncoding.EncodeToString([]byte("hello"))}, "isn't an image"},
+		{"too large", OutputImage{Data: base64.StdEncoding.EncodeToString(append(png, make([]byte, 1000000)...))}, "larger than Bluesky's limit"},
+		{"http", OutputImage{URL: "http://example.com/cat.png"}, "only https URLs are allowed"},
+		{"loopback", OutputImage{URL: server.URL + "/cat.png"}, "isn't a public address"},
+		{"private", OutputImage{URL: "https://10.0.0.1/cat.png"}, "isn't a public address"},
+		{"link-local", OutputImage{URL: "https://169.254.169.254/latest/meta-data"}, "isn't a public address"},
+		{"IPv6 loopback", OutputImage{URL: "https://[::1]/cat.png"}, "isn't a public address"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			content, err := test.image.Load()
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(content) != string(png) {
+				t.Errorf("got %q", content)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,161 @@
+package botspec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/robfig/cron/v3"
+)
+
+// What a community bot can be invoked for.
+const (
+	// TriggerMention is a post that mentions the bot
+	TriggerMention = "mention"
+
+	// TriggerReply is a reply in a thread the bot posted in
+	TriggerReply = "reply"
+
+	// TriggerQuote is a post that quotes one of the bot's posts
+	TriggerQuote = "quote"
+
+	// TriggerDM is a direct message to the bot
+	TriggerDM = "dm"
+
+	// TriggerFollow is someone following the bot
+	TriggerFollow = "follow"
+
+	// TriggerKeyword is a post anywhere on Bluesky with one of the bot's keywords
+	TriggerKeyword = "keyword"
+
+	// TriggerSchedule is a time set by the bot's cron schedule
+	TriggerSchedule = "schedule"
+)
+
+// Trigger is one of the things a community bot is invoked for.
+type Trigger struct {
+	Type string `json:"type"`
+
+	// Keywords are the words and hashtags a keyword trigger looks for
+	Keywords []string `json:"keywords,omitempty"`
+
+	// Schedule is when a schedule trigger runs, as a cron expression in UTC
+	Schedule string `json:"schedule,omitempty"`
+
+	// MaxPerHour is how often the trigger can invoke the bot, if it's less
+	// often than the bot as a whole can be invoked
+	MaxPerHour int `json:"maxPerHour,omitempty"`
+}
+
+// IsPost reports whether the trigger is a post the bot replies to. Bots reply
+// to DMs with a message, and post on their own for everything else.
+func (t Trigger) IsPost() bool {
+	return t.Type == TriggerMention || t.Type == TriggerReply || t.Type == TriggerQuote || t.Type == TriggerKeyword
+}
+
+// Matches returns the trigger's keywords that appear in text. Hashtags have to
+// match a whole hashtag, and other keywords a whole word, ignoring case.
+func (t Trigger) Matches(text string) []string {
+
+	words := map[string]bool{}
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isKeywordSeparator) {
+		words[word] = true
+	}
+
+	matched := []string{}
+
+	for _, keyword := range t.Keywords {
+		if words[strings.ToLower(keyword)] {
+			matched = append(matched, keyword)
+		}
+	}
+
+	return matched
+
+}
+
+func isKeywordSeparator(r rune) bool {
+	return !(r == '#' || r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// Next returns the first time after after that a schedule trigger runs.
+func (t Trigger) Next(after time.Time) (time.Time, error) {
+
+	schedule, err := parseSchedule(t.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return schedule.Next(after), nil
+
+}
+
+// parseSchedule reads a standard five-field cron expression, or a descriptor
+// like @daily. Schedules are in UTC unless they start with CRON_TZ=.
+func parseSchedule(spec string) (cron.Schedule, error) {
+
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		spec = "CRON_TZ=UTC " + spec
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	return schedule, nil
+
+}
+
+// Trigger returns the bot's trigger of the given type, if it has one.
+func (b Bot) Trigger(triggerType string) (Trigger, bool) {
+
+	for _, trigger := range b.Triggers {
+		if trigger.Type == triggerType {
+			return trigger, true
+		}
+	}
+
+	return Trigger{}, false
+
+}
+
+// validateTriggers checks the settings of each trigger that the schema can't.
+func validateTriggers(path string, bot Bot) Problems {
+
+	problems := Problems{}
+
+	invalid := func(index int, field, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: path, Field: fmt.Sprintf("triggers/%d%s", index, field), Message: fmt.Sprintf(format, args...)})
+	}
+
+	seen := map[string]bool{}
+
+	for index, trigger := range bot.Triggers {
+
+		if seen[trigger.Type] {
+			invalid(index, "/type", `"%s" is listed more than once`, trigger.Type)
+		}
+		seen[trigger.Type] = true
+
+		if trigger.Type != TriggerKeyword && len(trigger.Keywords) > 0 {
+			invalid(index, "/keywords", "can only be set for keyword triggers")
+		}
+
+		if trigger.Type != TriggerSchedule && trigger.Schedule != "" {
+			invalid(index, "/schedule", "can only be set for schedule triggers")
+		}
+
+		if trigger.Type == TriggerSchedule && trigger.Schedule != "" {
+			if _, err := parseSchedule(trigger.Schedule); err != nil {
+				invalid(index, "/schedule", "%v", err)
+			}
+		}
+
+	}
+
+	return problems
+
+}
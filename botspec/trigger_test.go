@@ -0,0 +1,82 @@
+package botspec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTriggerMatches(t *testing.T) {
+
+	trigger := Trigger{Type: TriggerKeyword, Keywords: []string{"#caturday", "kittens", "tabby-cat"}}
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"word", "Look at these kittens!", []string{"kittens"}},
+		{"hashtag", "Sleepy boy #caturday", []string{"#caturday"}},
+		{"ignores case", "KITTENS on #Caturday", []string{"#caturday", "kittens"}},
+		{"hyphenated keyword", "my tabby-cat", []string{"tabby-cat"}},
+		{"hashtag without the hash", "it's caturday", []string{}},
+		{"part of a hashtag", "#caturdaynight", []string{}},
+		{"part of a word", "kittenshire", []string{}},
+		{"keyword in a hashtag", "#kittens", []string{}},
+		{"nothing", "dogs are great", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if got := trigger.Matches(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+		})
+	}
+
+}
+
+func TestTriggerNext(t *testing.T) {
+
+	after := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		schedule string
+		want time.Time
+		wantErr bool
+	}{
+		{"daily in UTC", "0 9 * * *", time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), false},
+		{"descriptor", "@hourly", time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), false},
+		{"already passed today", "0 8 * * *", time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC), false},
+		{"time zone", "CRON_TZ=America/New_York 0 9 * * *", time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC), false},
+		{"invalid", "every morning", time.Time{}, true},
+		{"too many fields", "0 0 9 * * *", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got, err := Trigger{Type: TriggerSchedule, Schedule: test.schedule}.Next(after)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !got.Equal(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,193 @@
+package botstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"bbb/storage"
+)
+
+// KeyPrefix is where community bots' storage is kept in the storage backend.
+// It's kept apart from results, so it isn't removed when results expire.
+const KeyPrefix = "community/"
+
+// State is a key-value store. Values can be any JSON.
+type State map[string]json.RawMessage
+
+// Changes are applied to a State. Keys set to null are removed, and every
+// other key is set to its new value.
+type Changes map[string]json.RawMessage
+
+// Quota is the most a bot can keep, in bytes of JSON, for itself and for each
+// user that mentions it.
+type Quota struct {
+	Bot  int
+	User int
+}
+
+// QuotaError is returned when changes would take a bot over its quota.
+type QuotaError struct {
+	Scope string
+	Size  int
+	Quota int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s storage would be %d bytes, which is over the quota of %d bytes", e.Scope, e.Size, e.Quota)
+}
+
+// Store gives each community bot its own storage, with an area for the bot as
+// a whole and one for each user.
+type Store struct {
+	storage storage.Storage
+	quota   Quota
+
+	// Changes to a bot's storage are applied one at a time
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewStore(backend storage.Storage, quota Quota) *Store {
+	return &Store{
+		storage: backend,
+		quota:   quota,
+		locks:   map[string]*sync.Mutex{},
+	}
+}
+
+func botKey(bot string) string {
+	return fmt.Sprintf("%s%s/state.json", KeyPrefix, url.PathEscape(bot))
+}
+
+func userKey(bot, user string) string {
+	return fmt.Sprintf("%s%s/users/%s.json", KeyPrefix, url.PathEscape(bot), url.PathEscape(user))
+}
+
+func (s *Store) lock(bot string) *sync.Mutex {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lock, exists := s.locks[bot]
+	if !exists {
+		lock = &sync.Mutex{}
+		s.locks[bot] = lock
+	}
+
+	return lock
+
+}
+
+// Load returns the bot's own storage and its storage for user. Either is
+// empty if nothing has been stored yet, and the user's is always empty if
+// there's no user.
+func (s *Store) Load(bot, user string) (State, State, error) {
+
+	botState, err := s.load(botKey(bot))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user == "" {
+		return botState, State{}, nil
+	}
+
+	userState, err := s.load(userKey(bot, user))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return botState, userState, nil
+
+}
+
+func (s *Store) load(key string) (State, error) {
+
+	content, err := s.storage.Get(key)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return State{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read bot storage: %w", err)
+	}
+
+	state := State{}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("could not parse bot storage %s: %w", key, err)
+	}
+
+	return state, nil
+
+}
+
+// Apply makes the changes to the bot's own storage and to its storage for
+// user. Neither is changed if either would go over its quota, or if there are
+// changes for a user when there's no user.
+func (s *Store) Apply(bot, user string, botChanges, userChanges Changes) error {
+
+	if user == "" && len(userChanges) > 0 {
+		return errors.New("there's no user to change the storage of")
+	}
+
+	lock := s.lock(bot)
+	lock.Lock()
+	defer lock.Unlock()
+
+	botState, userState, err := s.Load(bot, user)
+	if err != nil {
+		return err
+	}
+
+	botContent, err := applyChanges(botState, botChanges, "bot", s.quota.Bot)
+	if err != nil {
+		return err
+	}
+
+	userContent, err := applyChanges(userState, userChanges, "user", s.quota.User)
+	if err != nil {
+		return err
+	}
+
+	if len(botChanges) > 0 {
+		if err := s.storage.Put(botKey(bot), botContent, "application/json"); err != nil {
+			return fmt.Errorf("could not write bot storage: %w", err)
+		}
+	}
+
+	if len(userChanges) > 0 {
+		if err := s.storage.Put(userKey(bot, user), userContent, "application/json"); err != nil {
+			return fmt.Errorf("could not write user storage: %w", err)
+		}
+	}
+
+	return nil
+
+}
+
+func applyChanges(state State, changes Changes, scope string, quota int) ([]byte, error) {
+
+	for key, value := range changes {
+		if value == nil || string(value) == "null" {
+			delete(state, key)
+		} else {
+			state[key] = value
+		}
+	}
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal %s storage: %w", scope, err)
+	}
+
+	if len(content) > quota {
+		return nil, &QuotaError{Scope: scope, Size: len(content), Quota: quota}
+	}
+
+	return content, nil
+
+}
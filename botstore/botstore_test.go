@@ -0,0 +1,103 @@
+package botstore
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"bbb/storage"
+)
+
+func TestApplyChanges(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		state     State
+		changes   Changes
+		quota     int
+		want      string
+		wantQuota bool
+	}{
+		{"set", State{}, Changes{"count": json.RawMessage(`1`)}, 100, `{"count":1}`, false},
+		{"replace", State{"count": json.RawMessage(`1`)}, Changes{"count": json.RawMessage(`2`)}, 100, `{"count":2}`, false},
+		{"remove with null", State{"count": json.RawMessage(`1`), "name": json.RawMessage(`"cat"`)}, Changes{"count": json.RawMessage(`null`)}, 100, `{"name":"cat"}`, false},
+		{"remove a missing key", State{}, Changes{"count": nil}, 100, `{}`, false},
+		{"exactly the quota", State{}, Changes{"count": json.RawMessage(`1`)}, len(`{"count":1}`), `{"count":1}`, false},
+		{"over the quota", State{}, Changes{"count": json.RawMessage(`10`)}, len(`{"count":1}`), "", true},
+		{"removing gets back under the quota", State{"big": json.RawMessage(`"xxxxxxxxxx"`)}, Changes{"big": nil, "count": json.RawMessage(`1`)}, len(`{"count":1}`), `{"count":1}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			content, err := applyChanges(test.state, test.changes, "bot", test.quota)
+
+			if test.wantQuota {
+				var quotaErr *QuotaError
+				if !errors.As(err, &quotaErr) {
+					t.Fatalf("expected a QuotaError, got %v", err)
+				}
+				if quotaErr.Scope != "bot" || quotaErr.Quota != test.quota {
+					t.Errorf("unexpected QuotaError %+v", quotaErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(content) != test.want {
+				t.Errorf("got %s, want %s", content, test.want)
+			}
+
+		})
+	}
+
+}
+
+func TestApply(t *testing.T) {
+
+	backend, err := storage.NewLocal(storage.LocalOptions{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore(backend, Quota{Bot: 32, User: 16})
+
+	if err := store.Apply("counter", "did:plc:alice", Changes{"total": json.RawMessage(`1`)}, Changes{"mine": json.RawMessage(`1`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user's storage would go over its quota, so neither is changed
+	err = store.Apply("counter", "did:plc:alice", Changes{"total": json.RawMessage(`2`)}, Changes{"mine": json.RawMessage(`"far too much to keep"`)})
+
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Scope != "user" {
+		t.Fatalf("expected a user QuotaError, got %v", err)
+	}
+
+	botState, userState, err := store.Load("counter", "did:plc:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(botState["total"]) != "1" || string(userState["mine"]) != "1" {
+		t.Errorf("storage changed: bot %v, user %v", botState, userState)
+	}
+
+	// Each user has their own storage
+	_, otherState, err := store.Load("counter", "did:plc:bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(otherState) != 0 {
+		t.Errorf("another user's storage wasn't empty: %v", otherState)
+	}
+
+	if err := store.Apply("counter", "", nil, Changes{"mine": json.RawMessage(`1`)}); err == nil {
+		t.Error("expected an error for user changes without a user")
+	}
+
+}
@@ -7,15 +7,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
+
+	"bbb/metrics"
 )
 
 type Session struct {
-	AccessJwt string `json:"accessJwt"`
-	Did       string `json:"did"`
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+	Did        string `json:"did"`
 }
 
 type NotificationResponse struct {
@@ -104,6 +108,7 @@ type EmbedRecord struct {
 type Image struct {
 	Alt         string   `json:"alt"`
 	Url         string
+	FullsizeUrl string
 	AspectRatio struct {
 		Height int `json:"height"`
 		Width  int `json:"width"`
@@ -131,6 +136,31 @@ type Label struct {
 	Type string `json:"$type"`
 }
 
+// Draft is a post that's yet to be made. A post can embed images or a link
+// card, but not both.
+type Draft struct {
+	Text   string
+	Images []DraftImage
+	Link   *LinkCard
+
+	// Labels are self-labels, like "nudity" or "graphic-media"
+	Labels []string
+}
+
+type DraftImage struct {
+	Data []byte
+	Alt  string
+}
+
+type LinkCard struct {
+	Uri         string
+	Title       string
+	Description string
+}
+
+// MaxImageSize is the largest image, in bytes, that Bluesky accepts.
+const MaxImageSize = 1000000
+
 type PostComponents struct {
 	Text string
 	Url string
@@ -138,7 +168,20 @@ type PostComponents struct {
 
 var blueskyAPIBase = "https://bsky.social/xrpc"
 var StartTime time.Time
-var RespondedFile = "responded_to.txt"
+
+type Config struct {
+	// RespondedFile records the posts we've replied to, so we never reply twice
+	RespondedFile string
+}
+
+var config = Config{
+	RespondedFile: "responded_to.txt",
+}
+
+// Configure sets the configuration used by every function in the package.
+func Configure(c Config) {
+	config = c
+}
 
 func Authenticate(username, password string) (*Session, error) {
 	url := fmt.Sprintf("%s/com.atproto.server.createSession", blueskyAPIBase)
@@ -151,7 +194,7 @@ func Authenticate(username, password string) (*Session, error) {
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "createSession")
 	if err != nil {
 		return nil, err
 	}
@@ -174,6 +217,36 @@ func Authenticate(username, password string) (*Session, error) {
 	return &session, nil
 }
 
+// RefreshSession swaps a session's refresh token for a new session, without
+// signing in again.
+func RefreshSession(refreshJwt string) (*Session, error) {
+	url := fmt.Sprintf("%s/com.atproto.server.refreshSession", blueskyAPIBase)
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+refreshJwt)
+
+	client := &http.Client{}
+	resp, err := doRequest(client, req, "refreshSession")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to refresh session, status code: %d", resp.StatusCode)
+	}
+
+	var session Session
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
 func FetchNotifications(jwt string) ([]Notification, error) {
 	url := fmt.Sprintf("%s/app.bsky.notification.listNotifications", blueskyAPIBase)
 
@@ -184,7 +257,7 @@ func FetchNotifications(jwt string) ([]Notification, error) {
 	req.Header.Set("Authorization", "Bearer "+jwt)
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "listNotifications")
 	if err != nil {
 		return nil, err
 	}
@@ -229,10 +302,10 @@ func UploadImage(jwt string, imageData []byte) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to create image upload request: %v", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+jwt)
-	req.Header.Set("Content-Type", "image/jpeg") // Adjust for other formats like "image/png" if needed
+	req.Header.Set("Content-Type", http.DetectContentType(imageData))
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "uploadBlob")
 	if err != nil {
 		return nil, fmt.Errorf("image upload failed: %v", err)
 	}
@@ -309,7 +382,7 @@ func ReplyToMentionWithImage(jwt string, notif Notification, text string, imageD
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "createRecord")
 	if err != nil {
 		return "", fmt.Errorf("request failed: %v", err)
 	}
@@ -335,8 +408,98 @@ func ReplyToMentionWithImage(jwt string, notif Notification, text string, imageD
 
 
 func ReplyToMention(jwt string, notif Notification, text string, userDid string) (string, error) {
+
+	root, parent := replyReferences(notif)
+
+	post, err := createReply(jwt, Draft{Text: text}, root, parent, userDid)
+	if err != nil {
+		return "", err
+	}
+
+	return post["uri"], nil
+
+}
+
+// ReplyToMentionInThread replies to the mention with the first post, then
+// replies to each post with the next, so a long reply reads as a thread. It
+// returns the URI of every post it made, even if a later one failed.
+func ReplyToMentionInThread(jwt string, notif Notification, posts []Draft, userDid string) ([]string, error) {
+
+	root, parent := replyReferences(notif)
+	uris := []string{}
+
+	for _, draft := range posts {
+
+		post, err := createReply(jwt, draft, root, parent, userDid)
+		if err != nil {
+			return uris, err
+		}
+
+		uris = append(uris, post["uri"])
+		parent = post
+
+	}
+
+	return uris, nil
+
+}
+
+// PostThread makes the first post on its own, then replies to each post with
+// the next, so a long post reads as a thread. It returns the URI of every post
+// it made, even if a later one failed.
+func PostThread(jwt string, posts []Draft, userDid string) ([]string, error) {
+
+	var root, parent map[string]string
+	uris := []string{}
+
+	for _, draft := range posts {
+
+		post, err := createReply(jwt, draft, root, parent, userDid)
+		if err != nil {
+			return uris, err
+		}
+
+		uris = append(uris, post["uri"])
+
+		if root == nil {
+			root = post
+		}
+		parent = post
+
+	}
+
+	return uris, nil
+
+}
+
+// replyReferences identifies the correct 'root' and 'parent' for a reply to the notification
+func replyReferences(notif Notification) (map[string]string, map[string]string) {
+
+	parent := map[string]string{
+		"uri": notif.Uri,
+		"cid": notif.Cid,
+	}
+
+	// If this notification itself is a reply, extract the root
+	if notif.Record.Reply != nil {
+		return map[string]string{
+			"uri": notif.Record.Reply.Root["uri"],
+			"cid": notif.Record.Reply.Root["cid"],
+		}, parent
+	}
+
+	// Otherwise, it's a top-level post — set itself as both root and parent
+	return parent, parent
+
+}
+
+// createReply posts the draft as a reply, or on its own if there's no parent,
+// and returns the uri and cid of the new post.
+func createReply(jwt string, draft Draft, root, parent map[string]string, userDid string) (map[string]string, error) {
 	url := fmt.Sprintf("%s/com.atproto.repo.createRecord", blueskyAPIBase)
 
+	text := draft.Text
+
 	// Initialize facets as an empty slice
 	var facets []map[string]interface{}
 
@@ -362,24 +525,6 @@ func ReplyToMention(jwt string, notif Notification, text string, userDid string)
 		})
 	}
 
-	// Identify the correct 'root' and 'parent'
-	var rootUri, rootCid string
-	var parentUri, parentCid string
-
-	// If this notification itself is a reply, extract the root
-	if notif.Record.Reply != nil {
-		rootUri = notif.Record.Reply.Root["uri"]
-		rootCid = notif.Record.Reply.Root["cid"]
-		parentUri = notif.Uri
-		parentCid = notif.Cid
-	} else {
-		// Otherwise, it's a top-level post — set itself as both root and parent
-		rootUri = notif.Uri
-		rootCid = notif.Cid
-		parentUri = notif.Uri
-		parentCid = notif.Cid
-	}
-
 	// Construct the payload with optional facets
 	payload := map[string]interface{}{
 		"collection": "app.bsky.feed.post",
@@ -388,61 +533,127 @@ func ReplyToMention(jwt string, notif Notification, text string, userDid string)
 			"$type":     "app.bsky.feed.post",
 			"text":      text,
 			"createdAt": time.Now().Format(time.RFC3339),
-			"reply": map[string]interface{}{
-				"root": map[string]string{
-					"uri": rootUri,
-					"cid": rootCid,
-				},
-				"parent": map[string]string{
-					"uri": parentUri,
-					"cid": parentCid,
-				},
-			},
 		},
 	}
 
+	if parent != nil {
+		payload["record"].(map[string]interface{})["reply"] = map[string]interface{}{
+			"root": root,
+			"parent": parent,
+		}
+	}
+
 	// Add facets only if they exist
 	if len(facets) > 0 {
 		payload["record"].(map[string]interface{})["facets"] = facets
 	}
 
+	embed, err := draftEmbed(jwt, draft)
+	if err != nil {
+		return nil, err
+	}
+
+	if embed != nil {
+		payload["record"].(map[string]interface{})["embed"] = embed
+	}
+
+	if len(draft.Labels) > 0 {
+		labels := []map[string]string{}
+		for _, label := range draft.Labels {
+			labels = append(labels, map[string]string{"val": label})
+		}
+		payload["record"].(map[string]interface{})["labels"] = map[string]interface{}{
+			"$type":  "com.atproto.label.defs#selfLabels",
+			"values": labels,
+		}
+	}
+
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal payload: %v", err)
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+jwt)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "createRecord")
 	if err != nil {
-		return "", fmt.Errorf("request failed: %v", err)
+		return nil, fmt.Errorf("request failed: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to post reply, status code: %d, response: %s", resp.StatusCode, string(respBody))
+		return nil, fmt.Errorf("failed to post reply, status code: %d, response: %s", resp.StatusCode, string(respBody))
 	}
 
 	var response map[string]interface{}
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	if uri, ok := response["uri"].(string); ok {
-		return uri, nil
+	uri, hasUri := response["uri"].(string)
+	cid, hasCid := response["cid"].(string)
+
+	if !hasUri || !hasCid {
+		return nil, fmt.Errorf("response URI not found")
 	}
 
-	return "", fmt.Errorf("response URI not found")
+	return map[string]string{
+		"uri": uri,
+		"cid": cid,
+	}, nil
 }
 
+// draftEmbed uploads the draft's images, or describes its link card, as the
+// post's embed. Drafts with neither have no embed.
+func draftEmbed(jwt string, draft Draft) (map[string]interface{}, error) {
+
+	if len(draft.Images) > 0 {
+
+		images := []map[string]interface{}{}
+
+		for index, image := range draft.Images {
+
+			blob, err := UploadImage(jwt, image.Data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to upload image %d: %v", index+1, err)
+			}
+
+			images = append(images, map[string]interface{}{
+				"image": blob,
+				"alt":   image.Alt,
+			})
+
+		}
+
+		return map[string]interface{}{
+			"$type":  "app.bsky.embed.images",
+			"images": images,
+		}, nil
+
+	}
+
+	if draft.Link != nil {
+		return map[string]interface{}{
+			"$type": "app.bsky.embed.external",
+			"external": map[string]string{
+				"uri":         draft.Link.Uri,
+				"title":       draft.Link.Title,
+				"description": draft.Link.Description,
+			},
+		}, nil
+	}
+
+	return nil, nil
+
+}
 
 func GetRepliedToPost(jwt string, notif Notification) (*Post, error) {
 
@@ -460,7 +671,7 @@ func GetRepliedToPost(jwt string, notif Notification) (*Post, error) {
 	req.Header.Set("Authorization", "Bearer "+jwt)
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "getPostThread")
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch post: %v", err)
 	}
@@ -504,12 +715,7 @@ func GetRepliedToPost(jwt string, notif Notification) (*Post, error) {
 	// Extract and set image URLs if present
 	if parentNotification.Record.Embed != nil && parentNotification.Record.Embed.Type == "app.bsky.embed.images" {
 		for _, img := range parentNotification.Record.Embed.Images {
-			imageRef := img.Image.Ref["$link"]
-			img.Url = fmt.Sprintf(
-				"https://cdn.bsky.app/img/feed_thumbnail/plain/%s/%s@jpeg",
-				parentNotification.Author.Did,
-				imageRef,
-			)
+			setImageURLs(&img, parentNotification.Author.Did)
 			parentPost.Images = append(parentPost.Images, img)
 		}
 	}
@@ -528,7 +734,7 @@ func GetPostByUri(jwt string, postUri string) (*Post, error) {
 	req.Header.Set("Authorization", "Bearer "+jwt)
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(client, req, "getPosts")
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch post: %v", err)
 	}
@@ -575,12 +781,7 @@ func GetPostByUri(jwt string, postUri string) (*Post, error) {
 	// Extract and set image URLs if present
 	if fetchedNotification.Record.Embed != nil && fetchedNotification.Record.Embed.Type == "app.bsky.embed.images" {
 		for _, img := range fetchedNotification.Record.Embed.Images {
-			imageRef := img.Image.Ref["$link"]
-			img.Url = fmt.Sprintf(
-				"https://cdn.bsky.app/img/feed_thumbnail/plain/%s/%s@jpeg",
-				fetchedNotification.Author.Did,
-				imageRef,
-			)
+			setImageURLs(&img, fetchedNotification.Author.Did)
 			post.Images = append(post.Images, img)
 		}
 	}
@@ -588,6 +789,44 @@ func GetPostByUri(jwt string, postUri string) (*Post, error) {
 	return post, nil
 }
 
+// GetProfile returns the profile of an account, given its handle or DID.
+func GetProfile(jwt string, actor string) (*Author, error) {
+	url := fmt.Sprintf("%s/app.bsky.actor.getProfile?actor=%s", blueskyAPIBase, actor)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	client := &http.Client{}
+	resp, err := doRequest(client, req, "getProfile")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch profile, status code: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	var profile Author
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &profile, nil
+}
+
+// setImageURLs points the image at Bluesky's CDN, which serves both a
+// thumbnail and the full-size image for each blob.
+func setImageURLs(img *Image, did string) {
+	imageRef := img.Image.Ref["$link"]
+	img.Url = fmt.Sprintf("https://cdn.bsky.app/img/feed_thumbnail/plain/%s/%s@jpeg", did, imageRef)
+	img.FullsizeUrl = fmt.Sprintf("https://cdn.bsky.app/img/feed_fullsize/plain/%s/%s@jpeg", did, imageRef)
+}
+
 func ProcessNotifications(notifications []Notification) []Notification {
 	for i, notif := range notifications {
 		notif.Post = Post{
@@ -610,15 +849,8 @@ func ProcessNotifications(notifications []Notification) []Notification {
 
 		if notif.Record.Embed != nil && notif.Record.Embed.Type == "app.bsky.embed.images" {
 			for _, img := range notif.Record.Embed.Images {
-				imageRef := img.Image.Ref["$link"]
-				imageURL := fmt.Sprintf(
-					"https://cdn.bsky.app/img/feed_thumbnail/plain/%s/%s@jpeg",
-					notif.Author.Did,
-					imageRef,
-				)
-				img.Url = imageURL
+				setImageURLs(&img, notif.Author.Did)
 				notif.Post.Images = append(notif.Post.Images, img)
-				// notif.ImageURL = imageURL
 			}
 		}
 
@@ -631,7 +863,7 @@ func ProcessNotifications(notifications []Notification) []Notification {
 func ShouldRespond(notif Notification) bool {
 	postTime, err := time.Parse(time.RFC3339, notif.Record.CreatedAt)
 	if err != nil {
-		fmt.Printf("Error parsing timestamp for notification URI %s: %v\n", notif.Uri, err)
+		slog.Warn("Could not parse notification timestamp", "notification", notif.Uri, "error", err)
 		return false
 	}
 
@@ -639,12 +871,12 @@ func ShouldRespond(notif Notification) bool {
 }
 
 func HasResponded(postUri string) bool {
-	file, err := os.Open(RespondedFile)
+	file, err := os.Open(config.RespondedFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false
 		}
-		fmt.Println("Error opening responded file:", err)
+		slog.Error("Could not open responded file", "error", err)
 		return false
 	}
 	defer file.Close()
@@ -661,15 +893,40 @@ func HasResponded(postUri string) bool {
 	return haveWeResponded
 }
 
+// CountResponses returns the number of posts recorded as responded to.
+func CountResponses() int {
+	file, err := os.Open(config.RespondedFile)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	count := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		count++
+	}
+
+	return count
+}
+
 func RecordResponse(postUri string) {
-	file, err := os.OpenFile(RespondedFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(config.RespondedFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Println("Error opening responded file for writing:", err)
+		slog.Error("Could not open responded file for writing", "error", err)
 		return
 	}
 	defer file.Close()
 
 	if _, err := file.WriteString(postUri + "\n"); err != nil {
-		fmt.Println("Error writing to responded file:", err)
+		slog.Error("Could not write to responded file", "error", err)
 	}
 }
+
+// doRequest sends a request to the Bluesky API and counts any failures.
+func doRequest(client *http.Client, req *http.Request, endpoint string) (*http.Response, error) {
+	resp, err := client.Do(req)
+	metrics.ObserveBlueskyRequest(endpoint, resp, err)
+	return resp, err
+}
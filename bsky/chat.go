@@ -0,0 +1,172 @@
+package bsky
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Direct messages are handled by Bluesky's chat service, which the PDS
+// proxies requests to when they ask for it. The account's app password must
+// be allowed to access direct messages.
+const chatProxy = "did:web:api.bsky.chat#bsky_chat"
+
+// MaxMessageLength is the most characters Bluesky allows in a direct message.
+const MaxMessageLength = 1000
+
+type Convo struct {
+	Id          string   `json:"id"`
+	Members     []Author `json:"members"`
+	UnreadCount int      `json:"unreadCount"`
+}
+
+type ChatMessage struct {
+	Type   string `json:"$type"`
+	Id     string `json:"id"`
+	Rev    string `json:"rev"`
+	Text   string `json:"text"`
+	SentAt string `json:"sentAt"`
+	Sender struct {
+		Did string `json:"did"`
+	} `json:"sender"`
+}
+
+// Member returns the member of the conversation with the given DID.
+func (c Convo) Member(did string) (Author, bool) {
+
+	for _, member := range c.Members {
+		if member.Did == did {
+			return member, true
+		}
+	}
+
+	return Author{}, false
+
+}
+
+// ListUnreadConvos returns the conversations with messages the account
+// hasn't read yet.
+func ListUnreadConvos(jwt string) ([]Convo, error) {
+
+	var response struct {
+		Convos []Convo `json:"convos"`
+	}
+
+	if err := chatRequest(jwt, "GET", "chat.bsky.convo.listConvos?readState=unread&limit=50", nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+
+	return response.Convos, nil
+
+}
+
+// GetMessages returns the latest messages in a conversation, newest first.
+// Deleted messages are left out.
+func GetMessages(jwt, convoId string, limit int) ([]ChatMessage, error) {
+
+	var response struct {
+		Messages []ChatMessage `json:"messages"`
+	}
+
+	path := fmt.Sprintf("chat.bsky.convo.getMessages?convoId=%s&limit=%d", url.QueryEscape(convoId), limit)
+
+	if err := chatRequest(jwt, "GET", path, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to get messages: %v", err)
+	}
+
+	messages := []ChatMessage{}
+	for _, message := range response.Messages {
+		if message.Type == "chat.bsky.convo.defs#messageView" {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+
+}
+
+// SendMessage sends a direct message to a conversation, and returns its ID.
+func SendMessage(jwt, convoId, text string) (string, error) {
+
+	payload := map[string]interface{}{
+		"convoId": convoId,
+		"message": map[string]string{
+			"text": text,
+		},
+	}
+
+	var response ChatMessage
+
+	if err := chatRequest(jwt, "POST", "chat.bsky.convo.sendMessage", payload, &response); err != nil {
+		return "", fmt.Errorf("failed to send message: %v", err)
+	}
+
+	return response.Id, nil
+
+}
+
+// MarkConvoRead marks every message in a conversation as read.
+func MarkConvoRead(jwt, convoId string) error {
+
+	payload := map[string]string{
+		"convoId": convoId,
+	}
+
+	if err := chatRequest(jwt, "POST", "chat.bsky.convo.updateRead", payload, nil); err != nil {
+		return fmt.Errorf("failed to mark conversation as read: %v", err)
+	}
+
+	return nil
+
+}
+
+func chatRequest(jwt, method, path string, payload interface{}, response interface{}) error {
+
+	body := &bytes.Buffer{}
+
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(encoded)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", blueskyAPIBase, path), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Atproto-Proxy", chatProxy)
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Requests are counted by the method's name, like every other endpoint
+	name, _, _ := strings.Cut(path, "?")
+	endpoint := name[strings.LastIndex(name, ".")+1:]
+
+	client := &http.Client{}
+	resp, err := doRequest(client, req, endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status code: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+
+}
@@ -0,0 +1,58 @@
+package bsky
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxPostLength is the most graphemes Bluesky accepts in a post. Runes are
+// counted instead, which is never fewer, so split posts always fit.
+const MaxPostLength = 300
+
+// SplitPost breaks text into posts that each fit in MaxPostLength, breaking
+// at whitespace where it can. If it takes more than one post, each is numbered
+// like "(1/3)" so the thread reads in order.
+func SplitPost(text string) []string {
+
+	text = strings.TrimSpace(text)
+
+	if len([]rune(text)) <= MaxPostLength {
+		return []string{text}
+	}
+
+	// Leave room for the numbering, up to " (999/999)"
+	limit := MaxPostLength - len(" (999/999)")
+
+	remaining := []rune(text)
+	posts := []string{}
+
+	for len(remaining) > 0 {
+
+		if len(remaining) <= limit {
+			posts = append(posts, string(remaining))
+			break
+		}
+
+		cut := limit
+
+		// Break at the last whitespace, unless that would make a very short post
+		for index := limit; index > limit/2; index-- {
+			if unicode.IsSpace(remaining[index]) {
+				cut = index
+				break
+			}
+		}
+
+		posts = append(posts, strings.TrimRightFunc(string(remaining[:cut]), unicode.IsSpace))
+		remaining = []rune(strings.TrimLeftFunc(string(remaining[cut:]), unicode.IsSpace))
+
+	}
+
+	for index := range posts {
+		posts[index] = fmt.Sprintf("%s (%d/%d)", posts[index], index+1, len(posts))
+	}
+
+	return posts
+
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"bbb/bacalhau"
+	"bbb/botsecrets"
+	"bbb/botspec"
+	"bbb/botstore"
+	"bbb/bsky"
+	"bbb/config"
+	"bbb/storage"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage:
+  bbb                              Run the bot
+  bbb community lint [dir...]      Check community bot definitions (defaults to ./community)
+  bbb community run <dir> [flags]  Run a community bot against a made-up post and print its reply
+`
+
+// runCommand runs a subcommand instead of the bot, and returns its exit code.
+func runCommand(args []string) int {
+
+	if len(args) >= 2 && args[0] == "community" {
+		switch args[1] {
+			case "lint":
+				return lintCommunityBots(args[2:])
+			case "run":
+				return simulateCommunityBot(args[2:])
+		}
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command: %v\n\n%s", args, usage)
+	return 2
+
+}
+
+// lintCommunityBots checks each directory, which is either a single bot or a
+// directory of bots, against the limits configured for each bot, and prints
+// any problems. It fails if there are any.
+func lintCommunityBots(dirs []string) int {
+
+	if len(dirs) == 0 {
+		dirs = []string{"./community"}
+	}
+
+	cfg, configErr := readConfig()
+	if configErr != nil {
+		fmt.Fprintln(os.Stderr, configErr)
+		return 1
+	}
+
+	policyFor := func(name string) botspec.ResourcePolicy {
+		return communityPolicy(cfg.Community.Limits(name))
+	}
+
+	checked := 0
+	problems := botspec.Problems{}
+
+	for _, dir := range dirs {
+		bots, dirProblems := botspec.Lint(dir, policyFor)
+		checked += len(bots)
+		problems = append(problems, dirProblems...)
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem.Error())
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("\nChecked %d bot(s): found %d problem(s)\n", checked, len(problems))
+		return 1
+	}
+
+	fmt.Printf("Checked %d bot(s): no problems found\n", checked)
+	return 0
+
+}
+
+// readConfig reads the configuration the bot would run with, from the same
+// .env file and config file, without checking that it's complete enough to
+// run the bot.
+func readConfig() (config.Config, error) {
+
+	godotenv.Load()
+
+	return config.Read()
+
+}
+
+// stringList collects a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// simulateCommunityBot builds the job for a made-up mention exactly as the bot
+// would, runs it with Docker or on an orchestrator, and prints the reply the
+// bot would post.
+func simulateCommunityBot(args []string) int {
+
+	flags := flag.NewFlagSet("community run", flag.ContinueOnError)
+
+	triggerType := flags.String("trigger", "", "what invokes the bot: mention, reply, quote, dm, follow, keyword or schedule (defaults to the bot's first trigger)")
+	text := flags.String("text", "", "the text of the post or direct message that invokes the bot (required, except for follow and schedule)")
+	author := flags.String("author", "someone.bsky.social", "the handle of the post's author")
+	handle := flags.String("handle", "", "the bot's handle (defaults to <name>.bots.bacalhau.org)")
+	executor := flags.String("executor", "docker", "where to run the job: docker, to run it locally, or bacalhau, to submit it to the configured orchestrator")
+	wait := flags.Int("wait", 0, "how many seconds the bot waits for the job's results (defaults to the bot's configured jobWaitTime)")
+	parentText := flags.String("parent", "", "the text of the post that the post replies to, if it's a reply")
+	quotedText := flags.String("quoted", "", "the text of the post that the post quotes, if it's a quote")
+	storageDir := flags.String("storage-dir", "", "a directory to keep the bot's storage in between runs, if it has storage enabled. It starts empty each run if this isn't set")
+	showJob := flags.Bool("show-job", false, "print the job that's submitted")
+
+	var images stringList
+	flags.Var(&images, "image", "the URL of an image in the post. Can be given more than once")
+
+	var secretFlags stringList
+	flags.Var(&secretFlags, "secret", "one of the bot's secrets, as NAME=value. Can be given more than once")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: bbb community run <dir> [--trigger <trigger>] --text <post> [flags]\n")
+		flags.PrintDefaults()
+	}
+
+	// Allow the directory before or after the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if dir == "" && flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	if dir == "" {
+		flags.Usage()
+		return 2
+	}
+
+	// Only warnings and errors, so the output is mostly the reply
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	cfg, configErr := readConfig()
+	if configErr != nil {
+		fmt.Fprintln(os.Stderr, configErr)
+		return 1
+	}
+
+	limits := cfg.Community.Limits(filepath.Base(filepath.Clean(dir)))
+
+	bot, problems := botspec.Load(dir, communityPolicy(limits))
+
+	if len(problems) > 0 {
+		fmt.Println(problems.Error())
+		fmt.Printf("\nThe bot won't be run until these %d problem(s) are fixed\n", len(problems))
+		return 1
+	}
+
+	if *wait == 0 {
+		*wait = limits.JobWaitTime
+	}
+
+	if *handle == "" {
+		*handle = fmt.Sprintf("%s.bots.bacalhau.org", bot.Name)
+	}
+
+	if *triggerType == "" {
+		*triggerType = bot.Triggers[0].Type
+	}
+
+	trigger, triggered := bot.Trigger(*triggerType)
+
+	if !triggered {
+		triggerTypes := []string{}
+		for _, botTrigger := range bot.Triggers {
+			triggerTypes = append(triggerTypes, botTrigger.Type)
+		}
+		fmt.Fprintf(os.Stderr, "The bot isn't invoked by %s. Its triggers are: %s\n", *triggerType, strings.Join(triggerTypes, ", "))
+		return 2
+	}
+
+	if *text == "" && (trigger.IsPost() || trigger.Type == botspec.TriggerDM) {
+		flags.Usage()
+		return 2
+	}
+
+	given := map[string]string{}
+
+	for _, secretFlag := range secretFlags {
+		name, value, found := strings.Cut(secretFlag, "=")
+		if !found || name == "" {
+			fmt.Fprintf(os.Stderr, "Invalid secret \"%s\". Expected NAME=value\n", name)
+			return 2
+		}
+		given[name] = value
+	}
+
+	secretStore := botsecrets.Store{bot.Name: given}
+
+	if undeclared := secretStore.Undeclared(bot.Name, bot.Secrets); len(undeclared) > 0 {
+		fmt.Fprintf(os.Stderr, "The bot's info.json doesn't list these secrets, so they won't be passed to it: %s\n", strings.Join(undeclared, ", "))
+	}
+
+	secrets, missingSecrets := secretStore.For(bot.Name, bot.Secrets)
+
+	if len(missingSecrets) > 0 {
+		fmt.Fprintf(os.Stderr, "The bot needs these secrets, which can be given with --secret NAME=value: %s\n", strings.Join(missingSecrets, ", "))
+		return 2
+	}
+
+	postText := *text
+	authorInfo := bsky.Author{Did: "did:web:" + *author, Handle: *author}
+	event := communityEvent{Trigger: trigger}
+
+	simulatedPost := func(name, text, authorHandle string) *bsky.Post {
+		if text == "" {
+			return nil
+		}
+		return &bsky.Post{
+			Uri: "at://did:web:" + authorHandle + "/app.bsky.feed.post/" + name,
+			Cid: "simulated-" + name,
+			Author: bsky.Author{Did: "did:web:" + authorHandle, Handle: authorHandle},
+			Record: bsky.Record{Text: text, Langs: []string{"en"}},
+		}
+	}
+
+	// Replies are to the bot's posts, and quotes are of them
+	parentAuthor, quotedAuthor := "someone-else.bsky.social", "someone-else.bsky.social"
+
+	switch trigger.Type {
+		case botspec.TriggerMention:
+			if !strings.Contains(postText, "@" + *handle) {
+				postText = fmt.Sprintf("@%s %s", *handle, postText)
+			}
+		case botspec.TriggerReply:
+			parentAuthor = *handle
+			if *parentText == "" {
+				*parentText = "(the bot's post)"
+			}
+		case botspec.TriggerQuote:
+			quotedAuthor = *handle
+			if *quotedText == "" {
+				*quotedText = "(the bot's post)"
+			}
+		case botspec.TriggerKeyword:
+			event.Keywords = trigger.Matches(postText)
+			if len(event.Keywords) == 0 {
+				fmt.Printf("The post doesn't have any of the bot's keywords (%s), so the bot wouldn't be invoked.\n", strings.Join(trigger.Keywords, ", "))
+				return 1
+			}
+		case botspec.TriggerDM:
+			event.ConvoID = "simulated"
+			event.Sender = authorInfo
+			event.Message = bsky.ChatMessage{Id: "simulated", Text: postText, SentAt: time.Now().Format(time.RFC3339)}
+			event.Message.Sender.Did = authorInfo.Did
+		case botspec.TriggerFollow:
+			event.Notif = bsky.Notification{Reason: "follow", Author: authorInfo}
+		case botspec.TriggerSchedule:
+			event.ScheduledAt = time.Now().Truncate(time.Minute)
+	}
+
+	parent := simulatedPost("parent", *parentText, parentAuthor)
+	quoted := simulatedPost("quoted", *quotedText, quotedAuthor)
+
+	if trigger.IsPost() {
+
+		event.Notif = bsky.Notification{
+			Uri: "at://did:web:" + *author + "/app.bsky.feed.post/" + trigger.Type,
+			Cid: "simulated",
+			Reason: trigger.Type,
+			Author: authorInfo,
+			Record: bsky.Record{
+				Text: postText,
+				CreatedAt: time.Now().Format(time.RFC3339),
+				Langs: []string{"en"},
+			},
+		}
+
+		if parent != nil {
+			parentRef := map[string]string{"uri": parent.Uri, "cid": parent.Cid}
+			event.Notif.Record.Reply = &bsky.Reply{Root: parentRef, Parent: parentRef}
+		}
+
+		if quoted != nil {
+			event.Notif.Record.Embed = &bsky.Embed{Type: "app.bsky.embed.record", Record: &bsky.EmbedRecord{Uri: quoted.Uri, Cid: quoted.Cid}}
+		}
+
+		for _, image := range images {
+			event.Notif.Post.Images = append(event.Notif.Post.Images, bsky.Image{Url: image, FullsizeUrl: image})
+		}
+
+	}
+
+	input := communityInput(*handle, event, parent, quoted)
+
+	// There's no storage to upload output to, so bots that ask for OUTPUT_URL get an empty one
+	if slices.Contains(bot.EnvironmentVariables, "OUTPUT_URL") {
+		fmt.Print("OUTPUT_URL isn't available when simulating, so the bot's output is read from stdout.\n\n")
+	}
+
+	// Storage is kept on disk, with the configured quotas
+	if *storageDir == "" {
+		tempDir, tempErr := os.MkdirTemp("", "bbb-storage-")
+		if tempErr != nil {
+			fmt.Fprintln(os.Stderr, tempErr)
+			return 1
+		}
+		defer os.RemoveAll(tempDir)
+		*storageDir = tempDir
+	}
+
+	localStorage, storageErr := storage.NewLocal(storage.LocalOptions{Directory: *storageDir})
+	if storageErr != nil {
+		fmt.Fprintln(os.Stderr, storageErr)
+		return 1
+	}
+
+	botStorage := botstore.NewStore(localStorage, botstore.Quota{
+		Bot: cfg.Community.BotStorageQuota,
+		User: cfg.Community.UserStorageQuota,
+	})
+
+	var botState, userState botstore.State
+
+	if bot.Storage {
+		var stateErr error
+		botState, userState, stateErr = botStorage.Load(bot.Name, storageUser(input))
+		if stateErr != nil {
+			fmt.Fprintln(os.Stderr, stateErr)
+			return 1
+		}
+	}
+
+	jobSpec, buildErr := buildCommunityJob(bot, input, "", botState, userState, limits.JobTimeout)
+
+	if buildErr != nil {
+		fmt.Fprintln(os.Stderr, buildErr)
+		return 1
+	}
+
+	if *showJob {
+		var indented map[string]interface{}
+		json.Unmarshal([]byte(jobSpec), &indented)
+		pretty, _ := json.MarshalIndent(indented, "", "  ")
+		fmt.Printf("Job:\n%s\n\n", pretty)
+	}
+
+	switch {
+		case trigger.IsPost():
+			fmt.Printf("@%s posted: %s\n\n", *author, postText)
+		case trigger.Type == botspec.TriggerDM:
+			fmt.Printf("@%s sent a direct message: %s\n\n", *author, postText)
+		case trigger.Type == botspec.TriggerFollow:
+			fmt.Printf("@%s followed @%s\n\n", *author, *handle)
+		default:
+			fmt.Printf("@%s's schedule (%s) was due at %s\n\n", *handle, trigger.Schedule, input.ScheduledAt)
+	}
+
+	var result bacalhau.JobExecutionResult
+
+	switch *executor {
+		case "docker":
+			// The job refers to its secrets by the names a compute node
+			// would have them under, and only those are given to it
+			nodeSecrets := map[string]string{}
+			for name, value := range secrets {
+				nodeSecrets[botsecrets.NodeName(bot.Name, name)] = value
+			}
+			var runErr error
+			result, runErr = bacalhau.RunLocally(context.Background(), jobSpec, nodeSecrets)
+			if runErr != nil {
+				fmt.Fprintln(os.Stderr, runErr)
+				return 1
+			}
+		case "bacalhau":
+			if cfg.Bacalhau.Host == "" {
+				fmt.Fprintln(os.Stderr, "Set the orchestrator with bacalhau.host (BACALHAU_HOST) to use the bacalhau executor")
+				return 1
+			}
+			configureBacalhau(cfg)
+			result = bacalhau.CreateJob(context.Background(), jobSpec, *wait)
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown executor \"%s\". Expected docker or bacalhau\n", *executor)
+			return 2
+	}
+
+	// The same as the bot does, so what's printed is what would be posted
+	result.Stdout = botsecrets.Redact(result.Stdout, secrets)
+	result.Stderr = botsecrets.Redact(result.Stderr, secrets)
+
+	fmt.Printf("Job %s finished as %s in %s\n", result.JobID, result.State, result.Duration.Round(time.Millisecond))
+
+	if result.Stderr != "" {
+		fmt.Printf("\nstderr:\n%s\n", strings.TrimRight(result.Stderr, "\n"))
+	}
+
+	// The bot collects results after a fixed wait, so slower jobs get a failure reply
+	if *executor == "docker" && result.Duration > time.Duration(*wait) * time.Second {
+		fmt.Printf("\nThe job took longer than the %ds the bot waits for results, so the bot would reply with a failure.\n", *wait)
+		result.State = "Running"
+	}
+
+	output, replies, replyErr := communityBotReplies(result, nil)
+
+	if replyErr == nil && output.Storage != nil {
+		if replyErr = saveCommunityBotStorage(botStorage, bot, input, output); replyErr == nil {
+			fmt.Printf("\nSaved %d change(s) to the bot's storage and %d to its storage for the user\n", len(output.Storage.Bot), len(output.Storage.User))
+		} else {
+			replies = []bsky.Draft{{Text: generateFailureResponse()}}
+		}
+	}
+
+	// Bots reply to posts and direct messages, and post on their own for anything else
+	replyWith := "reply with %d post(s)"
+	if trigger.Type == botspec.TriggerDM {
+		replyWith = "reply with %d direct message(s)"
+	} else if !trigger.IsPost() {
+		replyWith = "post %d post(s)"
+	}
+
+	switch {
+		case replyErr != nil && len(replies) == 0:
+			fmt.Printf("\n%v\n\nThe bot asked not to reply, so it would not.\n", replyErr)
+		case replyErr != nil && !trigger.IsPost() && trigger.Type != botspec.TriggerDM:
+			fmt.Printf("\nThe bot didn't produce any posts:\n%v\n\nThere's no one waiting for a reply, so it would not post anything.\n", replyErr)
+			return 0
+		case replyErr != nil:
+			fmt.Printf("\nThe bot didn't produce a reply:\n%v\n\nSo it would reply with one of its failure messages, like:\n", replyErr)
+		case len(replies) == 0:
+			fmt.Printf("\n@%s would not reply.\n", *handle)
+		case output.Version == 0:
+			fmt.Printf("\n@%s would " + replyWith + " of plain text:\n", *handle, len(replies))
+		default:
+			fmt.Printf("\n@%s would " + replyWith + ":\n", *handle, len(replies))
+	}
+
+	for index, reply := range replies {
+
+		fmt.Printf("\n[%d] %s\n", index + 1, reply.Text)
+
+		for _, image := range reply.Images {
+			fmt.Printf("    image (%s, %d bytes): %s\n", http.DetectContentType(image.Data), len(image.Data), image.Alt)
+		}
+
+		if reply.Link != nil {
+			fmt.Printf("    link: %s %s\n", reply.Link.Uri, reply.Link.Title)
+		}
+
+		if len(reply.Labels) > 0 {
+			fmt.Printf("    labels: %s\n", strings.Join(reply.Labels, ", "))
+		}
+
+	}
+
+	return 0
+
+}
@@ -0,0 +1,813 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read if CONFIG_FILE isn't set. It's fine for it not to exist,
+// as everything can be set with environment variables instead.
+const DefaultPath = "config.yaml"
+
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Bluesky   BlueskyConfig   `yaml:"bluesky"`
+	Bacalhau  BacalhauConfig  `yaml:"bacalhau"`
+	Jobs      JobsConfig      `yaml:"jobs"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Results   ResultsConfig   `yaml:"results"`
+	Community CommunityConfig `yaml:"community"`
+	Gancho    GanchoConfig    `yaml:"gancho"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port        int      `yaml:"port"`
+	Origin      string   `yaml:"origin"`
+	CORSOrigins []string `yaml:"corsOrigins"`
+
+	// AdminToken enables the admin API, and must be sent as a bearer token to use it
+	AdminToken string `yaml:"adminToken"`
+}
+
+// Behaviours an account can have. Every account has exactly one.
+const (
+	// BehaviourCommands responds to the built-in commands: job run, classify, hotdog and "<class>?"
+	BehaviourCommands = "commands"
+	// BehaviourAltText generates alt-text for the images in any post that mentions the account
+	BehaviourAltText = "alt-text"
+	// BehaviourCommunity runs the community bot named by the account's bot setting
+	BehaviourCommunity = "community"
+)
+
+type Account struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"pass"`
+
+	// DID is optional. If it's set, it's checked against the session when the account signs in.
+	DID string `yaml:"did" json:"did"`
+
+	Behaviour string `yaml:"behaviour" json:"behaviour"`
+	Bot       string `yaml:"bot" json:"bot"`
+
+	Settings AccountSettings `yaml:"settings" json:"settings"`
+}
+
+// AccountSettings override the global settings for a single account.
+// Anything left unset falls back to the global value.
+type AccountSettings struct {
+	DryRun        *bool  `yaml:"dryRun" json:"dryRun"`
+	JobWaitTime   int    `yaml:"jobWaitTime" json:"jobWaitTime"`
+	AltTextPrompt string `yaml:"altTextPrompt" json:"altTextPrompt"`
+}
+
+type BlueskyConfig struct {
+	Accounts      []Account `yaml:"accounts"`
+	ExpansoBots   []string  `yaml:"expansoBots"`
+	DryRun        bool      `yaml:"dryRun"`
+	RespondedFile string    `yaml:"respondedFile"`
+}
+
+type BacalhauConfig struct {
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	Secure      bool   `yaml:"secure"`
+	AccessToken string `yaml:"accessToken"`
+
+	// JobWaitTime is how many seconds to wait before collecting a job's results
+	JobWaitTime int `yaml:"jobWaitTime"`
+}
+
+type JobsConfig struct {
+	AltTextPrompt        string   `yaml:"altTextPrompt"`
+	OpenAIOrigin         string   `yaml:"openAIOrigin"`
+	OpenAIModel          string   `yaml:"openAIModel"`
+	ClassificationImage  string   `yaml:"classificationImage"`
+	ClassificationModels []string `yaml:"classificationModels"`
+}
+
+type StorageConfig struct {
+	Backend    string `yaml:"backend"`
+	Bucket     string `yaml:"bucket"`
+	Region     string `yaml:"region"`
+	Endpoint   string `yaml:"endpoint"`
+	PathStyle  bool   `yaml:"pathStyle"`
+	PublicURL  string `yaml:"publicURL"`
+	LocalDir   string `yaml:"localDir"`
+	SigningKey string `yaml:"signingKey"`
+}
+
+type ResultsConfig struct {
+	RetentionDays int    `yaml:"retentionDays"`
+	LinkMode      string `yaml:"linkMode"`
+}
+
+// CommunityConfig limits what community bots can keep in storage, in bytes,
+// and what they can run and how often.
+type CommunityConfig struct {
+	BotStorageQuota  int `yaml:"botStorageQuota"`
+	UserStorageQuota int `yaml:"userStorageQuota"`
+
+	// CommunityLimits apply to every bot that Bots doesn't override
+	CommunityLimits `yaml:",inline"`
+
+	// Bots override the limits for individual bots, by name. Anything left
+	// unset, or 0, falls back to the limits for every bot
+	Bots map[string]CommunityLimits `yaml:"bots"`
+
+	// FirehoseURL is the Jetstream instance that keyword triggers read posts from
+	FirehoseURL string `yaml:"firehoseURL"`
+
+	// SecretsFile holds the secrets attached to each bot. It's kept out of the
+	// repo, and there are no secrets if it isn't set
+	SecretsFile string `yaml:"secretsFile"`
+}
+
+// CommunityLimits are what a community bot is allowed: the resources its job
+// can ask for, in CPUs and bytes, how long the job can run and how long the
+// bot waits for it, in seconds, and how often the bot can be invoked.
+type CommunityLimits struct {
+	MaxCPU    float64 `yaml:"maxCPU" json:"maxCPU"`
+	MaxMemory int     `yaml:"maxMemory" json:"maxMemory"`
+	MaxDisk   int     `yaml:"maxDisk" json:"maxDisk"`
+	MaxGPU    int     `yaml:"maxGPU" json:"maxGPU"`
+
+	// JobTimeout is the longest a job can run for. It's set on jobs that
+	// don't ask for less
+	JobTimeout int `yaml:"jobTimeout" json:"jobTimeout"`
+
+	// JobWaitTime is how long to wait before collecting a job's results
+	JobWaitTime int `yaml:"jobWaitTime" json:"jobWaitTime"`
+
+	// MaxConcurrentJobs is how many of the bot's jobs can run at once. Any
+	// more wait in a queue of up to MaxQueuedJobs
+	MaxConcurrentJobs int `yaml:"maxConcurrentJobs" json:"maxConcurrentJobs"`
+	MaxQueuedJobs     int `yaml:"maxQueuedJobs" json:"maxQueuedJobs"`
+
+	// MaxTriggersPerHour is how many times the bot can be invoked an hour,
+	// by all of its triggers together
+	MaxTriggersPerHour int `yaml:"maxTriggersPerHour" json:"maxTriggersPerHour"`
+}
+
+// Limits returns the limits for the named bot, with any it doesn't override
+// taken from the limits for every bot.
+func (c CommunityConfig) Limits(bot string) CommunityLimits {
+
+	limits := c.CommunityLimits
+	overrides, found := c.Bots[bot]
+
+	if !found {
+		return limits
+	}
+
+	if overrides.MaxCPU > 0 {
+		limits.MaxCPU = overrides.MaxCPU
+	}
+
+	override := func(target *int, value int) {
+		if value > 0 {
+			*target = value
+		}
+	}
+
+	override(&limits.MaxMemory, overrides.MaxMemory)
+	override(&limits.MaxDisk, overrides.MaxDisk)
+	override(&limits.MaxGPU, overrides.MaxGPU)
+	override(&limits.JobTimeout, overrides.JobTimeout)
+	override(&limits.JobWaitTime, overrides.JobWaitTime)
+	override(&limits.MaxConcurrentJobs, overrides.MaxConcurrentJobs)
+	override(&limits.MaxQueuedJobs, overrides.MaxQueuedJobs)
+	override(&limits.MaxTriggersPerHour, overrides.MaxTriggersPerHour)
+
+	return limits
+
+}
+
+type GanchoConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	Key      string `yaml:"key"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter"`
+}
+
+// Default returns the configuration used for anything the file and
+// environment don't set.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port: 8080,
+		},
+		Bluesky: BlueskyConfig{
+			RespondedFile: "responded_to.txt",
+		},
+		Bacalhau: BacalhauConfig{
+			Port:        1234,
+			JobWaitTime: 30,
+		},
+		Jobs: JobsConfig{
+			AltTextPrompt: "Briefly, what is in this image?",
+		},
+		Storage: StorageConfig{
+			Backend:   "s3",
+			PathStyle: true,
+			LocalDir:  "./data",
+		},
+		Results: ResultsConfig{
+			RetentionDays: 30,
+			LinkMode:      "proxy",
+		},
+		Community: CommunityConfig{
+			BotStorageQuota:  64000,
+			UserStorageQuota: 8000,
+			CommunityLimits: CommunityLimits{
+				MaxCPU:             2,
+				MaxMemory:          2 * 1000 * 1000 * 1000,
+				MaxDisk:            10 * 1000 * 1000 * 1000,
+				MaxGPU:             0,
+				JobTimeout:         300,
+				JobWaitTime:        5,
+				MaxConcurrentJobs:  5,
+				MaxQueuedJobs:      20,
+				MaxTriggersPerHour: 60,
+			},
+			FirehoseURL: "wss://jetstream2.us-east.bsky.network/subscribe",
+		},
+		Gancho: GanchoConfig{
+			Endpoint: "https://go.cod.dev",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+	}
+}
+
+// Load reads the YAML file at CONFIG_FILE (or DefaultPath), applies any
+// environment variable overrides and validates the result.
+func Load() (Config, error) {
+
+	config, err := Read()
+	if err != nil {
+		return config, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+
+}
+
+// Read is Load without validation, for tools that only need some of the
+// configuration and check it themselves.
+func Read() (Config, error) {
+
+	config := Default()
+
+	path, required := Path()
+
+	if err := config.loadFile(path, required); err != nil {
+		return config, err
+	}
+
+	if err := config.applyEnvironment(); err != nil {
+		return config, err
+	}
+
+	config.inferBehaviours()
+
+	return config, nil
+
+}
+
+// Path returns the config file to read, and whether it has to exist, which it
+// does if it was chosen with CONFIG_FILE.
+func Path() (string, bool) {
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path, true
+	}
+
+	return DefaultPath, false
+
+}
+
+func (c *Config) loadFile(path string, required bool) error {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			return nil
+		}
+		return fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+
+	// Unknown keys are an error, so a typo doesn't silently fall back to a default
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	return nil
+
+}
+
+// environment reads overrides from environment variables, keeping track of
+// any that can't be parsed. Empty variables are treated as unset.
+type environment struct {
+	errs []error
+}
+
+func (e *environment) string(target *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+func (e *environment) int(target *int, name string) {
+
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf(`%s must be a whole number, not "%s"`, name, value))
+		return
+	}
+
+	*target = number
+
+}
+
+func (e *environment) float(target *float64, name string) {
+
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf(`%s must be a number, not "%s"`, name, value))
+		return
+	}
+
+	*target = number
+
+}
+
+func (e *environment) bool(target *bool, name string) {
+
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf(`%s must be true or false, not "%s"`, name, value))
+		return
+	}
+
+	*target = parsed
+
+}
+
+func (e *environment) list(target *[]string, name string) {
+
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	*target = items
+
+}
+
+func (c *Config) applyEnvironment() error {
+
+	env := &environment{}
+
+	env.int(&c.Server.Port, "PORT")
+	env.string(&c.Server.Origin, "SERVER_ORIGIN")
+	env.list(&c.Server.CORSOrigins, "API_CORS_ORIGINS")
+	env.string(&c.Server.AdminToken, "ADMIN_TOKEN")
+
+	if accounts := os.Getenv("BLUESKY_ACCOUNTS"); accounts != "" {
+		c.Bluesky.Accounts = nil
+		if err := json.Unmarshal([]byte(accounts), &c.Bluesky.Accounts); err != nil {
+			env.errs = append(env.errs, fmt.Errorf(`BLUESKY_ACCOUNTS must be a JSON list like [{"username":"...","pass":"..."}]: %w`, err))
+		}
+	}
+
+	env.list(&c.Bluesky.ExpansoBots, "EXPANSO_BOTS")
+	env.bool(&c.Bluesky.DryRun, "DRY_RUN")
+	env.string(&c.Bluesky.RespondedFile, "RESPONDED_FILE")
+
+	env.string(&c.Bacalhau.Host, "BACALHAU_HOST")
+	env.int(&c.Bacalhau.Port, "BACALHAU_PORT")
+	env.bool(&c.Bacalhau.Secure, "USING_SECURE_ORCHESTRATOR")
+	env.string(&c.Bacalhau.AccessToken, "BACALHAU_ACCESS_TOKEN")
+	env.int(&c.Bacalhau.JobWaitTime, "DEFAULT_JOB_WAIT_TIME")
+
+	env.string(&c.Jobs.AltTextPrompt, "ALT_TEXT_JOB_PROMPT")
+	env.string(&c.Jobs.OpenAIOrigin, "OPEN_AI_ORIGIN")
+	env.string(&c.Jobs.OpenAIModel, "OPEN_AI_MODEL")
+	env.string(&c.Jobs.ClassificationImage, "CLASSIFICATION_IMAGE")
+	env.list(&c.Jobs.ClassificationModels, "CLASSIFICATION_MODELS")
+
+	env.string(&c.Storage.Backend, "STORAGE_BACKEND")
+	env.string(&c.Storage.Bucket, "RESULTS_BUCKET")
+	env.string(&c.Storage.Region, "AWS_REGION")
+	env.string(&c.Storage.Endpoint, "STORAGE_ENDPOINT")
+	env.bool(&c.Storage.PathStyle, "STORAGE_PATH_STYLE")
+	env.string(&c.Storage.PublicURL, "STORAGE_PUBLIC_URL")
+	env.string(&c.Storage.LocalDir, "STORAGE_LOCAL_DIR")
+	env.string(&c.Storage.SigningKey, "STORAGE_SIGNING_KEY")
+
+	env.int(&c.Results.RetentionDays, "RESULTS_RETENTION_DAYS")
+	env.string(&c.Results.LinkMode, "RESULTS_LINK_MODE")
+
+	env.int(&c.Community.BotStorageQuota, "COMMUNITY_BOT_STORAGE_QUOTA")
+	env.int(&c.Community.UserStorageQuota, "COMMUNITY_USER_STORAGE_QUOTA")
+	env.float(&c.Community.MaxCPU, "COMMUNITY_MAX_CPU")
+	env.int(&c.Community.MaxMemory, "COMMUNITY_MAX_MEMORY")
+	env.int(&c.Community.MaxDisk, "COMMUNITY_MAX_DISK")
+	env.int(&c.Community.MaxGPU, "COMMUNITY_MAX_GPU")
+	env.int(&c.Community.JobTimeout, "COMMUNITY_JOB_TIMEOUT")
+	env.int(&c.Community.JobWaitTime, "COMMUNITY_JOB_WAIT_TIME")
+	env.int(&c.Community.MaxConcurrentJobs, "COMMUNITY_MAX_CONCURRENT_JOBS")
+	env.int(&c.Community.MaxQueuedJobs, "COMMUNITY_MAX_QUEUED_JOBS")
+	env.int(&c.Community.MaxTriggersPerHour, "COMMUNITY_MAX_TRIGGERS_PER_HOUR")
+
+	if bots := os.Getenv("COMMUNITY_BOT_LIMITS"); bots != "" {
+		c.Community.Bots = nil
+		if err := json.Unmarshal([]byte(bots), &c.Community.Bots); err != nil {
+			env.errs = append(env.errs, fmt.Errorf(`COMMUNITY_BOT_LIMITS must be a JSON object like {"calculator":{"maxConcurrentJobs":10}}: %w`, err))
+		}
+	}
+
+	env.string(&c.Community.FirehoseURL, "COMMUNITY_FIREHOSE_URL")
+	env.string(&c.Community.SecretsFile, "COMMUNITY_SECRETS_FILE")
+
+	env.string(&c.Gancho.Endpoint, "GANCHO_ENDPOINT")
+	env.string(&c.Gancho.Key, "GANCHO_KEY")
+
+	env.string(&c.Logging.Level, "LOG_LEVEL")
+	env.string(&c.Logging.Format, "LOG_FORMAT")
+
+	env.string(&c.Tracing.Exporter, "OTEL_TRACES_EXPORTER")
+
+	return errors.Join(env.errs...)
+
+}
+
+// inferBehaviours gives accounts without a behaviour the one they had before
+// behaviours could be configured: Expanso bots run the built-in commands, or
+// alt-text if the handle says so, and any other account runs the community bot
+// named by the first part of its handle, as in calculator.bots.bacalhau.org.
+func (c *Config) inferBehaviours() {
+
+	for index := range c.Bluesky.Accounts {
+
+		account := &c.Bluesky.Accounts[index]
+
+		if account.Behaviour != "" {
+			continue
+		}
+
+		switch {
+		case !oneOf(account.Username, c.Bluesky.ExpansoBots...):
+			account.Behaviour = BehaviourCommunity
+			if account.Bot == "" {
+				account.Bot, _, _ = strings.Cut(account.Username, ".")
+			}
+		case strings.Contains(account.Username, "alt-text.bots.bacalhau.org"):
+			account.Behaviour = BehaviourAltText
+		default:
+			account.Behaviour = BehaviourCommands
+		}
+
+	}
+
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the whole configuration and reports every problem it finds,
+// naming both the config file key and the environment variable for each.
+func (c Config) Validate() error {
+
+	errs := []error{}
+
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		invalid("server.port (PORT) must be between 1 and 65535, not %d", c.Server.Port)
+	}
+
+	if c.Server.Origin != "" {
+		if origin, err := url.Parse(c.Server.Origin); err != nil || origin.Scheme == "" || origin.Host == "" {
+			invalid(`server.origin (SERVER_ORIGIN) must be an absolute URL like https://bots.example.com, not "%s"`, c.Server.Origin)
+		}
+	}
+
+	if len(c.Bluesky.Accounts) == 0 {
+		invalid("bluesky.accounts (BLUESKY_ACCOUNTS) must list at least one account")
+	}
+
+	usernames := map[string]bool{}
+
+	for index, account := range c.Bluesky.Accounts {
+
+		if account.Username == "" || account.Password == "" {
+			invalid("bluesky.accounts[%d] needs both a username and a password", index)
+		}
+
+		if usernames[account.Username] {
+			invalid(`bluesky.accounts[%d] repeats the account "%s"`, index, account.Username)
+		}
+		usernames[account.Username] = true
+
+		if account.DID != "" && !strings.HasPrefix(account.DID, "did:") {
+			invalid(`bluesky.accounts[%d].did must be a DID like did:plc:..., not "%s"`, index, account.DID)
+		}
+
+		switch account.Behaviour {
+		case BehaviourCommands, BehaviourAltText:
+			if account.Bot != "" {
+				invalid(`bluesky.accounts[%d].bot can only be set when the behaviour is %s, not %s`, index, BehaviourCommunity, account.Behaviour)
+			}
+		case BehaviourCommunity:
+			if account.Bot == "" {
+				invalid("bluesky.accounts[%d].bot must name the community bot the account runs", index)
+			}
+		default:
+			invalid(`bluesky.accounts[%d].behaviour must be one of %s, %s or %s, not "%s"`, index, BehaviourCommands, BehaviourAltText, BehaviourCommunity, account.Behaviour)
+		}
+
+		if account.Settings.JobWaitTime < 0 {
+			invalid("bluesky.accounts[%d].settings.jobWaitTime must be at least 1 second, not %d", index, account.Settings.JobWaitTime)
+		}
+
+	}
+
+	if c.Bluesky.RespondedFile == "" {
+		invalid("bluesky.respondedFile (RESPONDED_FILE) cannot be empty")
+	}
+
+	if c.Bacalhau.Host == "" {
+		invalid("bacalhau.host (BACALHAU_HOST) is required")
+	} else if strings.Contains(c.Bacalhau.Host, "://") || strings.Contains(c.Bacalhau.Host, ":") {
+		invalid(`bacalhau.host (BACALHAU_HOST) should be a hostname without a scheme or port, not "%s". Set the port with bacalhau.port (BACALHAU_PORT)`, c.Bacalhau.Host)
+	}
+
+	if c.Bacalhau.Port < 1 || c.Bacalhau.Port > 65535 {
+		invalid("bacalhau.port (BACALHAU_PORT) must be between 1 and 65535, not %d", c.Bacalhau.Port)
+	}
+
+	if c.Bacalhau.Secure && c.Bacalhau.AccessToken == "" {
+		invalid("bacalhau.accessToken (BACALHAU_ACCESS_TOKEN) is required when bacalhau.secure (USING_SECURE_ORCHESTRATOR) is true")
+	}
+
+	if c.Bacalhau.JobWaitTime < 1 {
+		invalid("bacalhau.jobWaitTime (DEFAULT_JOB_WAIT_TIME) must be at least 1 second, not %d", c.Bacalhau.JobWaitTime)
+	}
+
+	switch c.Storage.Backend {
+	case "s3", "s3-compatible":
+		if c.Storage.Bucket == "" {
+			invalid("storage.bucket (RESULTS_BUCKET) is required for the %s storage backend", c.Storage.Backend)
+		}
+		if c.Storage.Backend == "s3-compatible" && c.Storage.Endpoint == "" {
+			invalid("storage.endpoint (STORAGE_ENDPOINT) is required for the s3-compatible storage backend")
+		}
+	case "local":
+		if c.Storage.LocalDir == "" {
+			invalid("storage.localDir (STORAGE_LOCAL_DIR) cannot be empty")
+		}
+		if c.Server.Origin == "" {
+			invalid("server.origin (SERVER_ORIGIN) is required for the local storage backend, which serves objects from the bot")
+		}
+	default:
+		invalid(`storage.backend (STORAGE_BACKEND) must be one of s3, s3-compatible or local, not "%s"`, c.Storage.Backend)
+	}
+
+	if c.Results.RetentionDays < 1 {
+		invalid("results.retentionDays (RESULTS_RETENTION_DAYS) must be at least 1, not %d", c.Results.RetentionDays)
+	}
+
+	if !oneOf(c.Results.LinkMode, "proxy", "presigned") {
+		invalid(`results.linkMode (RESULTS_LINK_MODE) must be proxy or presigned, not "%s"`, c.Results.LinkMode)
+	}
+
+	if c.Results.LinkMode == "proxy" && c.Server.Origin == "" {
+		invalid("server.origin (SERVER_ORIGIN) is required when results.linkMode (RESULTS_LINK_MODE) is proxy")
+	}
+
+	// Without a key, one is generated at startup, and every link the bot has
+	// already posted stops working when it restarts
+	if c.Results.LinkMode == "proxy" && c.Storage.SigningKey == "" {
+		invalid("storage.signingKey (STORAGE_SIGNING_KEY) is required when results.linkMode (RESULTS_LINK_MODE) is proxy, so links keep working after a restart")
+	}
+
+	if c.Storage.Backend == "local" && c.Storage.SigningKey == "" {
+		invalid("storage.signingKey (STORAGE_SIGNING_KEY) is required for the local storage backend, so links keep working after a restart")
+	}
+
+	if c.Community.BotStorageQuota < 1 {
+		invalid("community.botStorageQuota (COMMUNITY_BOT_STORAGE_QUOTA) must be at least 1 byte, not %d", c.Community.BotStorageQuota)
+	}
+
+	if c.Community.UserStorageQuota < 1 {
+		invalid("community.userStorageQuota (COMMUNITY_USER_STORAGE_QUOTA) must be at least 1 byte, not %d", c.Community.UserStorageQuota)
+	}
+
+	if c.Community.MaxCPU <= 0 {
+		invalid("community.maxCPU (COMMUNITY_MAX_CPU) must be more than 0, not %g", c.Community.MaxCPU)
+	}
+
+	if c.Community.MaxMemory < 1 {
+		invalid("community.maxMemory (COMMUNITY_MAX_MEMORY) must be at least 1 byte, not %d", c.Community.MaxMemory)
+	}
+
+	if c.Community.MaxDisk < 1 {
+		invalid("community.maxDisk (COMMUNITY_MAX_DISK) must be at least 1 byte, not %d", c.Community.MaxDisk)
+	}
+
+	if c.Community.MaxGPU < 0 {
+		invalid("community.maxGPU (COMMUNITY_MAX_GPU) can't be negative, not %d", c.Community.MaxGPU)
+	}
+
+	if c.Community.JobTimeout < 1 {
+		invalid("community.jobTimeout (COMMUNITY_JOB_TIMEOUT) must be at least 1 second, not %d", c.Community.JobTimeout)
+	}
+
+	if c.Community.JobWaitTime < 1 {
+		invalid("community.jobWaitTime (COMMUNITY_JOB_WAIT_TIME) must be at least 1 second, not %d", c.Community.JobWaitTime)
+	}
+
+	if c.Community.MaxConcurrentJobs < 1 {
+		invalid("community.maxConcurrentJobs (COMMUNITY_MAX_CONCURRENT_JOBS) must be at least 1, not %d", c.Community.MaxConcurrentJobs)
+	}
+
+	if c.Community.MaxQueuedJobs < 0 {
+		invalid("community.maxQueuedJobs (COMMUNITY_MAX_QUEUED_JOBS) can't be negative, not %d", c.Community.MaxQueuedJobs)
+	}
+
+	if c.Community.MaxTriggersPerHour < 1 {
+		invalid("community.maxTriggersPerHour (COMMUNITY_MAX_TRIGGERS_PER_HOUR) must be at least 1, not %d", c.Community.MaxTriggersPerHour)
+	}
+
+	limitedBots := []string{}
+	for bot := range c.Community.Bots {
+		limitedBots = append(limitedBots, bot)
+	}
+	sort.Strings(limitedBots)
+
+	for _, bot := range limitedBots {
+
+		limits := c.Community.Bots[bot]
+
+		settings := []struct {
+			key   string
+			value float64
+		}{
+			{"maxCPU", limits.MaxCPU},
+			{"maxMemory", float64(limits.MaxMemory)},
+			{"maxDisk", float64(limits.MaxDisk)},
+			{"maxGPU", float64(limits.MaxGPU)},
+			{"jobTimeout", float64(limits.JobTimeout)},
+			{"jobWaitTime", float64(limits.JobWaitTime)},
+			{"maxConcurrentJobs", float64(limits.MaxConcurrentJobs)},
+			{"maxQueuedJobs", float64(limits.MaxQueuedJobs)},
+			{"maxTriggersPerHour", float64(limits.MaxTriggersPerHour)},
+		}
+
+		for _, setting := range settings {
+			if setting.value < 0 {
+				invalid("community.bots.%s.%s can't be negative, not %g", bot, setting.key, setting.value)
+			}
+		}
+
+	}
+
+	if !strings.HasPrefix(c.Community.FirehoseURL, "ws://") && !strings.HasPrefix(c.Community.FirehoseURL, "wss://") {
+		invalid(`community.firehoseURL (COMMUNITY_FIREHOSE_URL) must be a ws:// or wss:// URL, not "%s"`, c.Community.FirehoseURL)
+	}
+
+	if !oneOf(strings.ToLower(c.Logging.Level), "debug", "info", "warn", "error") {
+		invalid(`logging.level (LOG_LEVEL) must be one of debug, info, warn or error, not "%s"`, c.Logging.Level)
+	}
+
+	if !oneOf(strings.ToLower(c.Logging.Format), "text", "json") {
+		invalid(`logging.format (LOG_FORMAT) must be text or json, not "%s"`, c.Logging.Format)
+	}
+
+	if !oneOf(strings.ToLower(c.Tracing.Exporter), "none", "stdout", "otlp") {
+		invalid(`tracing.exporter (OTEL_TRACES_EXPORTER) must be one of none, stdout or otlp, not "%s"`, c.Tracing.Exporter)
+	}
+
+	return errors.Join(errs...)
+
+}
+
+// Secrets returns every credential in the configuration, so they can be kept out of the logs.
+func (c Config) Secrets() []string {
+
+	secrets := []string{c.Server.AdminToken, c.Bacalhau.AccessToken, c.Storage.SigningKey, c.Gancho.Key}
+
+	for _, account := range c.Bluesky.Accounts {
+		secrets = append(secrets, account.Password)
+	}
+
+	return secrets
+
+}
+
+// RestartRequired lists the settings that differ between c and next but are
+// only read at startup. Accounts, dry run, the job wait time and the alt-text
+// prompt can all be reloaded, as they're resolved per account, and so can the
+// community bots' limits and secrets file, which are resolved per bot.
+func (c Config) RestartRequired(next Config) []string {
+
+	changed := []string{}
+
+	compare := func(key string, current, updated interface{}) {
+		if !reflect.DeepEqual(current, updated) {
+			changed = append(changed, key)
+		}
+	}
+
+	reloadable := func(config Config) Config {
+		config.Bluesky.Accounts = nil
+		config.Bluesky.ExpansoBots = nil
+		config.Bluesky.DryRun = false
+		config.Bacalhau.JobWaitTime = 0
+		config.Jobs.AltTextPrompt = ""
+		config.Community.CommunityLimits = CommunityLimits{}
+		config.Community.Bots = nil
+		config.Community.SecretsFile = ""
+		return config
+	}
+
+	current, updated := reloadable(c), reloadable(next)
+
+	compare("server", current.Server, updated.Server)
+	compare("bluesky", current.Bluesky, updated.Bluesky)
+	compare("bacalhau", current.Bacalhau, updated.Bacalhau)
+	compare("jobs", current.Jobs, updated.Jobs)
+	compare("storage", current.Storage, updated.Storage)
+	compare("results", current.Results, updated.Results)
+	compare("community", current.Community, updated.Community)
+	compare("gancho", current.Gancho, updated.Gancho)
+	compare("logging", current.Logging, updated.Logging)
+	compare("tracing", current.Tracing, updated.Tracing)
+
+	return changed
+
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validConfig is the default configuration with what it needs to pass Validate.
+func validConfig() Config {
+
+	config := Default()
+	config.Bluesky.Accounts = []Account{{Username: "bot.example.com", Password: "secret", Behaviour: BehaviourCommands}}
+	config.Bacalhau.Host = "orchestrator.example.com"
+	config.Storage.Bucket = "results"
+	config.Server.Origin = "https://bots.example.com"
+	config.Storage.SigningKey = "signing key"
+
+	return config
+
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []string
+	}{
+		{"valid", func(config *Config) {}, nil},
+		{"port out of range", func(config *Config) { config.Server.Port = 70000 }, []string{"server.port (PORT)"}},
+		{"relative origin", func(config *Config) { config.Server.Origin = "bots.example.com" }, []string{"server.origin (SERVER_ORIGIN) must be an absolute URL"}},
+		{"no accounts", func(config *Config) { config.Bluesky.Accounts = nil }, []string{"must list at least one account"}},
+		{"repeated account", func(config *Config) {
+			config.Bluesky.Accounts = append(config.Bluesky.Accounts, config.Bluesky.Accounts[0])
+		}, []string{`bluesky.accounts[1] repeats the account "bot.example.com"`}},
+		{"community account without a bot", func(config *Config) {
+			config.Bluesky.Accounts[0].Behaviour = BehaviourCommunity
+		}, []string{"bluesky.accounts[0].bot must name the community bot"}},
+		{"host with a scheme", func(config *Config) { config.Bacalhau.Host = "https://orchestrator.example.com" }, []string{"without a scheme or port"}},
+		{"secure without a token", func(config *Config) { config.Bacalhau.Secure = true }, []string{"bacalhau.accessToken (BACALHAU_ACCESS_TOKEN) is required"}},
+		{"s3 without a bucket", func(config *Config) { config.Storage.Bucket = "" }, []string{"storage.bucket (RESULTS_BUCKET) is required"}},
+		{"local storage without an origin", func(config *Config) {
+			config.Storage.Backend = "local"
+			config.Server.Origin = ""
+		}, []string{"required for the local storage backend", "required when results.linkMode"}},
+		{"proxy links without a signing key", func(config *Config) { config.Storage.SigningKey = "" }, []string{"storage.signingKey (STORAGE_SIGNING_KEY) is required when results.linkMode"}},
+		{"presigned links without a signing key", func(config *Config) {
+			config.Results.LinkMode = "presigned"
+			config.Storage.SigningKey = ""
+		}, nil},
+		{"local storage without a signing key", func(config *Config) {
+			config.Storage.Backend = "local"
+			config.Results.LinkMode = "presigned"
+			config.Storage.SigningKey = ""
+		}, []string{"storage.signingKey (STORAGE_SIGNING_KEY) is required for the local storage backend"}},
+		{"unknown link mode", func(config *Config) { config.Results.LinkMode = "direct" }, []string{"results.linkMode (RESULTS_LINK_MODE) must be proxy or presigned"}},
+		{"no CPU for community bots", func(config *Config) { config.Community.MaxCPU = 0 }, []string{"community.maxCPU (COMMUNITY_MAX_CPU) must be more than 0"}},
+		{"negative queue", func(config *Config) { config.Community.MaxQueuedJobs = -1 }, []string{"community.maxQueuedJobs (COMMUNITY_MAX_QUEUED_JOBS) can't be negative"}},
+		{"negative bot limit", func(config *Config) {
+			config.Community.Bots = map[string]CommunityLimits{"calculator": {JobTimeout: -1}}
+		}, []string{"community.bots.calculator.jobTimeout can't be negative"}},
+		{"http firehose", func(config *Config) { config.Community.FirehoseURL = "https://jetstream.example.com" }, []string{"must be a ws:// or wss:// URL"}},
+		{"every problem is reported", func(config *Config) {
+			config.Logging.Level = "loud"
+			config.Tracing.Exporter = "zipkin"
+		}, []string{"logging.level (LOG_LEVEL)", "tracing.exporter (OTEL_TRACES_EXPORTER)"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := validConfig()
+			test.change(&config)
+
+			err := config.Validate()
+
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't mention %q: %v", want, err)
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestApplyEnvironment(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(t *testing.T, config Config)
+		wantErr string
+	}{
+		{
+			"overrides",
+			map[string]string{"PORT": "9090", "DRY_RUN": "true", "COMMUNITY_MAX_CPU": "0.5", "BACALHAU_HOST": "orchestrator.example.com"},
+			func(t *testing.T, config Config) {
+				if config.Server.Port != 9090 || !config.Bluesky.DryRun || config.Community.MaxCPU != 0.5 || config.Bacalhau.Host != "orchestrator.example.com" {
+					t.Errorf("overrides weren't applied: %+v", config)
+				}
+			},
+			"",
+		},
+		{
+			"empty variables are unset",
+			map[string]string{"PORT": "", "STORAGE_BACKEND": ""},
+			func(t *testing.T, config Config) {
+				if config.Server.Port != 8080 || config.Storage.Backend != "s3" {
+					t.Errorf("defaults were replaced: port %d, backend %s", config.Server.Port, config.Storage.Backend)
+				}
+			},
+			"",
+		},
+		{
+			"lists are trimmed",
+			map[string]string{"API_CORS_ORIGINS": " https://a.example.com, ,https://b.example.com "},
+			func(t *testing.T, config Config) {
+				want := []string{"https://a.example.com", "https://b.example.com"}
+				if !reflect.DeepEqual(config.Server.CORSOrigins, want) {
+					t.Errorf("got %v, want %v", config.Server.CORSOrigins, want)
+				}
+			},
+			"",
+		},
+		{
+			"accounts and bot limits are JSON",
+			map[string]string{
+				"BLUESKY_ACCOUNTS":     `[{"username":"calculator.bots.example.com","pass":"secret"}]`,
+				"COMMUNITY_BOT_LIMITS": `{"calculator":{"maxConcurrentJobs":10}}`,
+			},
+			func(t *testing.T, config Config) {
+				if len(config.Bluesky.Accounts) != 1 || config.Bluesky.Accounts[0].Password != "secret" {
+					t.Errorf("accounts weren't read: %+v", config.Bluesky.Accounts)
+				}
+				if config.Community.Bots["calculator"].MaxConcurrentJobs != 10 {
+					t.Errorf("bot limits weren't read: %+v", config.Community.Bots)
+				}
+			},
+			"",
+		},
+		{"invalid number", map[string]string{"PORT": "eighty"}, nil, `PORT must be a whole number, not "eighty"`},
+		{"invalid bool", map[string]string{"DRY_RUN": "sometimes"}, nil, `DRY_RUN must be true or false, not "sometimes"`},
+		{"invalid accounts", map[string]string{"BLUESKY_ACCOUNTS": "bot:secret"}, nil, "BLUESKY_ACCOUNTS must be a JSON list"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			config := Default()
+			err := config.applyEnvironment()
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			test.check(t, config)
+
+		})
+	}
+
+}
+
+func TestReadRejectsUnknownKeys(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  prot: 9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+
+	if _, err := Read(); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected an error naming the unknown key, got %v", err)
+	}
+
+}
+
+func TestLimits(t *testing.T) {
+
+	community := Default().Community
+	community.Bots = map[string]CommunityLimits{
+		"calculator": {MaxCPU: 4, MaxConcurrentJobs: 10},
+		"weather":    {JobWaitTime: 20},
+	}
+
+	tests := []struct {
+		name   string
+		bot    string
+		change func(limits *CommunityLimits)
+	}{
+		{"bot without overrides", "poet", func(limits *CommunityLimits) {}},
+		{"overrides", "calculator", func(limits *CommunityLimits) {
+			limits.MaxCPU = 4
+			limits.MaxConcurrentJobs = 10
+		}},
+		{"unset overrides are inherited", "weather", func(limits *CommunityLimits) { limits.JobWaitTime = 20 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			want := community.CommunityLimits
+			test.change(&want)
+
+			if got := community.Limits(test.bot); got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+
+		})
+	}
+
+}
+
+func TestRestartRequired(t *testing.T) {
+
+	current := validConfig()
+
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []string
+	}{
+		{"nothing changed", func(config *Config) {}, []string{}},
+		{"reloadable settings", func(config *Config) {
+			config.Bluesky.Accounts = nil
+			config.Bluesky.DryRun = true
+			config.Community.MaxCPU = 1
+			config.Community.Bots = map[string]CommunityLimits{"calculator": {MaxGPU: 1}}
+			config.Community.SecretsFile = "secrets.yaml"
+		}, []string{}},
+		{"startup settings", func(config *Config) {
+			config.Server.Port = 9090
+			config.Storage.Bucket = "other"
+			config.Community.FirehoseURL = "wss://jetstream.example.com"
+		}, []string{"server", "storage", "community"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			next := validConfig()
+			test.change(&next)
+
+			if got := current.RestartRequired(next); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,110 @@
+package firehose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"bbb/bsky"
+
+	"github.com/gorilla/websocket"
+)
+
+// The firehose is read through Jetstream, which turns the raw repository
+// events into JSON, and can filter them to a single collection.
+
+// DefaultURL is Bluesky's public Jetstream instance.
+const DefaultURL = "wss://jetstream2.us-east.bsky.network/subscribe"
+
+// Event is a single change to a repository.
+type Event struct {
+	Did    string  `json:"did"`
+	TimeUS int64   `json:"time_us"`
+	Kind   string  `json:"kind"`
+	Commit *Commit `json:"commit,omitempty"`
+}
+
+type Commit struct {
+	Rev        string          `json:"rev"`
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	RKey       string          `json:"rkey"`
+	Cid        string          `json:"cid"`
+	Record     json.RawMessage `json:"record,omitempty"`
+}
+
+// Post is a new post, as seen on the firehose.
+type Post struct {
+	Uri    string
+	Cid    string
+	Did    string
+	Record bsky.Record
+}
+
+// Stream reads new posts from the firehose until ctx is cancelled or the
+// connection fails, calling handle with each one. It starts from cursor, the
+// time_us of the last event seen, or from now if it's 0, and returns the
+// cursor to resume from.
+func Stream(ctx context.Context, endpoint string, cursor int64, handle func(Post)) (int64, error) {
+
+	streamURL, err := url.Parse(endpoint)
+	if err != nil {
+		return cursor, fmt.Errorf("invalid firehose URL: %w", err)
+	}
+
+	query := streamURL.Query()
+	query.Set("wantedCollections", "app.bsky.feed.post")
+	if cursor > 0 {
+		query.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	streamURL.RawQuery = query.Encode()
+
+	dialer := websocket.Dialer{HandshakeTimeout: 15 * time.Second}
+
+	conn, _, err := dialer.DialContext(ctx, streamURL.String(), nil)
+	if err != nil {
+		return cursor, fmt.Errorf("could not connect to the firehose: %w", err)
+	}
+	defer conn.Close()
+
+	// Closing the connection is the only way to interrupt a read
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+
+		// Jetstream sends events constantly, so a quiet connection is a dead one
+		conn.SetReadDeadline(time.Now().Add(time.Minute))
+
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			if ctx.Err() != nil {
+				return cursor, ctx.Err()
+			}
+			return cursor, fmt.Errorf("lost the firehose: %w", err)
+		}
+
+		cursor = event.TimeUS
+
+		if event.Kind != "commit" || event.Commit == nil || event.Commit.Operation != "create" || event.Commit.Collection != "app.bsky.feed.post" {
+			continue
+		}
+
+		var record bsky.Record
+		if err := json.Unmarshal(event.Commit.Record, &record); err != nil {
+			continue
+		}
+
+		handle(Post{
+			Uri: fmt.Sprintf("at://%s/%s/%s", event.Did, event.Commit.Collection, event.Commit.RKey),
+			Cid: event.Commit.Cid,
+			Did: event.Did,
+			Record: record,
+		})
+
+	}
+
+}
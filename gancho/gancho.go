@@ -1,7 +1,6 @@
 package gancho
 
 import (
-	"os"
 	"fmt"
 	"bytes"
 	"errors"
@@ -10,21 +9,31 @@ import (
 	"encoding/json"
 )
 
-func GenerateShortURL(targetURL string) (string, error) {
-	// Gancho API endpoint
-	apiEndpoint := os.Getenv("GANCHO_ENDPOINT")
+type Client struct {
+	Endpoint string
+	Key      string
+}
 
-	if apiEndpoint == ""{
-		apiEndpoint = "https://go.cod.dev"
-	}
+// New creates a Gancho client. The endpoint defaults to https://go.cod.dev,
+// but a key is required.
+func New(endpoint, key string) (*Client, error) {
 
-	// Authorization token
-	apiKey := os.Getenv("GANCHO_KEY")
+	if endpoint == ""{
+		endpoint = "https://go.cod.dev"
+	}
 
-	if apiKey == ""{
-		return "", errors.New("No GANCHO_KEY environment variable is set. Can't authenticate with Gancho to create the shortlink.")
+	if key == ""{
+		return nil, errors.New("No Gancho key is configured. Can't authenticate with Gancho to create the shortlink.")
 	}
 
+	return &Client{
+		Endpoint: endpoint,
+		Key: key,
+	}, nil
+}
+
+func (g *Client) Shorten(targetURL string) (string, error) {
+
 	// Prepare the request payload
 	payload := map[string]string{
 		"url": targetURL,
@@ -36,13 +45,13 @@ func GenerateShortURL(targetURL string) (string, error) {
 	}
 
 	// Create the HTTP request
-	req, err := http.NewRequest("POST", apiEndpoint, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", g.Endpoint, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return "", fmt.Errorf("Failed to create Gancho request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", apiKey)
+	req.Header.Set("Authorization", g.Key)
 
 	// Execute the request
 	client := &http.Client{}
@@ -71,7 +80,7 @@ func GenerateShortURL(targetURL string) (string, error) {
 	}
 
 	// Construct the short URL
-	shortURL := fmt.Sprintf("%s/%s", apiEndpoint, identifier)
+	shortURL := fmt.Sprintf("%s/%s", g.Endpoint, identifier)
 
 	return shortURL, nil
 }
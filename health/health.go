@@ -0,0 +1,135 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+	StatusUnknown = "unknown"
+)
+
+// Check is the last known state of one of the bot's dependencies.
+type Check struct {
+	Name        string     `json:"name"`
+	Group       string     `json:"group,omitempty"`
+	Required    bool       `json:"required"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	LastChecked *time.Time `json:"lastChecked,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+var (
+	mu     sync.RWMutex
+	checks = map[string]*Check{}
+)
+
+// Register adds a dependency to the readiness report. Required dependencies
+// must be healthy for the bot to be ready. Required dependencies that share
+// a group (e.g. one per Bluesky account) only need one healthy member.
+func Register(name, group string, required bool) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if check, exists := checks[name]; exists {
+		check.Group = group
+		check.Required = required
+		return
+	}
+
+	checks[name] = &Check{
+		Name:     name,
+		Group:    group,
+		Required: required,
+		Status:   StatusUnknown,
+	}
+
+}
+
+// Unregister removes a dependency from the readiness report, e.g. when an
+// account is removed from the configuration.
+func Unregister(name string) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(checks, name)
+
+}
+
+// Report records the outcome of talking to a dependency. A nil error marks
+// it healthy. Unregistered dependencies are added as optional.
+func Report(name string, err error) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	check, exists := checks[name]
+	if !exists {
+		check = &Check{Name: name}
+		checks[name] = check
+	}
+
+	now := time.Now()
+	check.LastChecked = &now
+
+	if err != nil {
+		check.Status = StatusFailing
+		check.Error = err.Error()
+		return
+	}
+
+	check.Status = StatusOK
+	check.Error = ""
+	check.LastSuccess = &now
+
+}
+
+// Readiness returns whether every required dependency is healthy, along with
+// the state of each dependency.
+func Readiness() (bool, []Check) {
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ready := true
+	groupHealthy := map[string]bool{}
+	snapshot := []Check{}
+
+	for _, check := range checks {
+
+		snapshot = append(snapshot, *check)
+
+		if !check.Required {
+			continue
+		}
+
+		if check.Group != "" {
+			groupHealthy[check.Group] = groupHealthy[check.Group] || check.Status == StatusOK
+			continue
+		}
+
+		if check.Status != StatusOK {
+			ready = false
+		}
+
+	}
+
+	for _, healthy := range groupHealthy {
+		if !healthy {
+			ready = false
+		}
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Name < snapshot[j].Name
+	})
+
+	return ready, snapshot
+
+}
@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// Environment variables with names like these hold credentials. The word has
+// to be a whole part of the name, so OPEN_AI_KEY matches but KEYBOARD doesn't
+var secretNamePattern = regexp.MustCompile(`(^|_)(KEY|SECRET|TOKEN|PASSWORD|PASS|JWT)(_|$)`)
+
+var secretPatterns = []*regexp.Regexp{
+	// NAME=value, NAME: value and "NAME":"value" for credential-like env var names
+	regexp.MustCompile(`(\b(?:[A-Z0-9]+_)*(?:KEY|SECRET|TOKEN|PASSWORD|PASS|JWT)(?:_[A-Z0-9]+)*\b"?\s*[=:]\s*"?)([^\s",}\[\]]+)`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)(Bearer\s+)([A-Za-z0-9._~+/=-]+)`),
+	// Bluesky session tokens and other JWTs
+	regexp.MustCompile(`()(eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`),
+	// OpenAI API keys
+	regexp.MustCompile(`()(sk-[A-Za-z0-9_-]{16,})`),
+}
+
+var (
+	secretsMutex sync.RWMutex
+	secrets      []string
+)
+
+// Setup installs the default logger. level sets the minimum level (debug,
+// info, warn or error) and format chooses between "text" and "json".
+// Every line is passed through Redact before it's written.
+func Setup(level, format string) {
+
+	for _, entry := range os.Environ() {
+		name, value, found := strings.Cut(entry, "=")
+		if found && secretNamePattern.MatchString(name) {
+			AddSecret(value)
+		}
+	}
+
+	slog.SetDefault(slog.New(NewRedactingHandler(newHandler(os.Stdout, format, ParseLevel(level)))))
+
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+
+	options := &slog.HandlerOptions{Level: level}
+
+	if strings.ToLower(format) == "json" {
+		return slog.NewJSONHandler(w, options)
+	}
+
+	return slog.NewTextHandler(w, options)
+
+}
+
+// ParseLevel turns a level name into a slog.Level, defaulting to info.
+func ParseLevel(name string) slog.Level {
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return slog.LevelInfo
+	}
+
+	return level
+
+}
+
+// AddSecret registers a value that must never appear in the logs.
+// Very short values are ignored, as scrubbing them would mangle ordinary text.
+func AddSecret(value string) {
+
+	value = strings.TrimSpace(value)
+	if len(value) < 4 {
+		return
+	}
+
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	for _, existing := range secrets {
+		if existing == value {
+			return
+		}
+	}
+
+	secrets = append(secrets, value)
+
+	// Replace the longest values first so a secret that contains another is scrubbed whole
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+}
+
+// Redact scrubs known secret values and credential-like patterns from text.
+func Redact(text string) string {
+
+	secretsMutex.RLock()
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	secretsMutex.RUnlock()
+
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllString(text, "${1}"+redacted)
+	}
+
+	return text
+
+}
+
+// RedactingHandler scrubs secrets from the message and attributes of every
+// record before handing it to the wrapped handler.
+type RedactingHandler struct {
+	handler slog.Handler
+}
+
+func NewRedactingHandler(handler slog.Handler) *RedactingHandler {
+	return &RedactingHandler{handler: handler}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+
+	scrubbed := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		scrubbed.AddAttrs(redactAttr(attr))
+		return true
+	})
+
+	return h.handler.Handle(ctx, scrubbed)
+
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	scrubbed := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		scrubbed = append(scrubbed, redactAttr(attr))
+	}
+
+	return &RedactingHandler{handler: h.handler.WithAttrs(scrubbed)}
+
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{handler: h.handler.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+		case slog.KindString:
+			return slog.String(attr.Key, Redact(value.String()))
+		case slog.KindGroup:
+			group := value.Group()
+			scrubbed := make([]any, 0, len(group))
+			for _, member := range group {
+				scrubbed = append(scrubbed, redactAttr(member))
+			}
+			return slog.Group(attr.Key, scrubbed...)
+		case slog.KindAny:
+			// Structs and maps are flattened to text so nothing inside them escapes redaction
+			if err, isError := value.Any().(error); isError {
+				return slog.String(attr.Key, Redact(err.Error()))
+			}
+			return slog.String(attr.Key, Redact(fmt.Sprintf("%+v", value.Any())))
+	}
+
+	return slog.Attr{Key: attr.Key, Value: value}
+
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecretNamePattern(t *testing.T) {
+
+	tests := []struct {
+		name string
+		secret bool
+	}{
+		{"OPEN_AI_KEY", true},
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"BACALHAU_ACCESS_TOKEN", true},
+		{"ADMIN_TOKEN", true},
+		{"DB_PASSWORD", true},
+		{"KEY", true},
+		{"TOKEN_FILE", true},
+		{"MONKEY", false},
+		{"KEYBOARD_LAYOUT", false},
+		{"PASSPORT_NUMBER", false},
+		{"TOKENIZER", false},
+		{"HOME", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := secretNamePattern.MatchString(test.name); got != test.secret {
+				t.Errorf("got %v, want %v", got, test.secret)
+			}
+		})
+	}
+
+}
+
+func TestRedact(t *testing.T) {
+
+	AddSecret("hunter2hunter2")
+	AddSecret("abc")
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"registered secret", "password is hunter2hunter2", "password is [REDACTED]"},
+		{"short values are left alone", "abc def", "abc def"},
+		{"credential env var", "GANCHO_KEY=12345 next", "GANCHO_KEY=[REDACTED] next"},
+		{"quoted credential", `{"ADMIN_TOKEN":"s3cret"}`, `{"ADMIN_TOKEN":"[REDACTED]"}`},
+		{"ordinary env var", "MONKEY=banana KEYBOARD=qwerty", "MONKEY=banana KEYBOARD=qwerty"},
+		{"bearer token", "Authorization: Bearer abc.def-ghi", "Authorization: Bearer [REDACTED]"},
+		{"JWT", "jwt eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl", "jwt [REDACTED]"},
+		{"OpenAI key", "using sk-abcdefghijklmnop1234", "using [REDACTED]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Redact(test.text); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+}
+
+func TestRedactingHandler(t *testing.T) {
+
+	AddSecret("correct-horse-battery")
+
+	var output bytes.Buffer
+	logger := slog.New(NewRedactingHandler(slog.NewTextHandler(&output, nil)))
+
+	logger.With("password", "correct-horse-battery").Info("signing in with correct-horse-battery", "nested", slog.GroupValue(slog.String("pass", "correct-horse-battery")))
+
+	if strings.Contains(output.String(), "correct-horse-battery") {
+		t.Errorf("secret was logged: %s", output.String())
+	}
+
+}
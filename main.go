@@ -1,132 +1,481 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"sync/atomic"
+	"errors"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
-	"strconv"
 	"encoding/json"
 	"math/rand"
 
+	"bbb/accounts"
+	"bbb/annotate"
 	"bbb/bacalhau"
+	"bbb/botsecrets"
+	"bbb/botspec"
+	"bbb/botstore"
 	"bbb/bsky"
 	"bbb/gancho"
+	"bbb/health"
 	"bbb/helpers"
-	"bbb/s3uploader"
+	"bbb/config"
+	"bbb/logging"
+	"bbb/metrics"
+	"bbb/results"
+	"bbb/shortener"
+	"bbb/storage"
+	"bbb/tracing"
 
 	"github.com/joho/godotenv"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/handlebars/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/google/uuid"
+	"github.com/mailgun/raymond/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var CONFIG config.Config
 var DEFAULT_JOB_WAIT_TIME int
 var UUIDRouteRegex string = "<regex(^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$)}>"
-var EXPANSO_BOTS []string
-var COMMUNITY_BOTS []CommunityBot
+var BOTS atomic.Pointer[BotRegistry]
+var RESULTS_STORE storage.Storage
+var RESULTS *results.Store
+var COMMUNITY_STORAGE *botstore.Store
+var RESULTS_SIGNER *storage.Signer
+var RESULTS_RETENTION_DAYS int
+var RESULTS_LINK_MODE string
+var SHORTENER shortener.Shortener
+var BUILTIN_SHORTENER *shortener.Builtin
+
+// S3 won't accept presigned URLs that live for longer than a week
+const MAX_PRESIGNED_URL_EXPIRY = 7 * 24 * time.Hour
+
+// Long enough for a job to be scheduled and run, but no longer
+const JOB_UPLOAD_URL_EXPIRY = 15 * time.Minute
+
+// The most a job can upload as its output, which is as much as the server
+// accepts in a request
+const MAX_JOB_OUTPUT_SIZE = 4 * 1024 * 1024
+
+// BotRegistry is everything that can be reloaded without a restart. It's
+// replaced as a whole, so jobs that are already running keep the bot they
+// started with.
+type BotRegistry struct {
+	Accounts *accounts.Registry
+	CommunityBots []botspec.Bot
+	Secrets botsecrets.Store
+
+	// Config is the configuration the registry was built from. Settings that
+	// are resolved per account or per bot are read from here, rather than
+	// CONFIG, so they follow reloads
+	Config config.Config
+}
+
+// accountSettings returns the settings for the account that a session
+// belongs to, or the global settings if it can't be found.
+func accountSettings(session *bsky.Session) accounts.Settings {
+
+	if account, found := BOTS.Load().Accounts.ForDID(session.Did); found {
+		return account.Settings
+	}
+
+	return accounts.Settings{
+		DryRun: CONFIG.Bluesky.DryRun,
+		JobWaitTime: DEFAULT_JOB_WAIT_TIME,
+		AltTextPrompt: CONFIG.Jobs.AltTextPrompt,
+	}
 
-type CommunityBot struct {
-	Name string `json:"name"`
-	Storage bool `json:"storage"`
-	EnvironmentVariables []string `json:"environmentVariables"`
-	JobFile string `json:jobFile`
 }
 
-func isExpansoBotAccount(botHandle string) (bool) {
+// communityBotNames returns the name of every community bot in bots.
+func communityBotNames(bots []botspec.Bot) []string {
 
-	var isExpansoBot bool = false
+	names := []string{}
+	for _, bot := range bots {
+		names = append(names, bot.Name)
+	}
 
-	for _, expansoBotHandle := range EXPANSO_BOTS {
+	return names
 
-		if botHandle == expansoBotHandle {
-			isExpansoBot = true
-			break
+}
+
+// communityLimits returns what the named community bot is allowed.
+func (r *BotRegistry) communityLimits(name string) config.CommunityLimits {
+	return r.Config.Community.Limits(name)
+}
+
+func (r *BotRegistry) findCommunityBot(name string) (botspec.Bot, bool) {
+
+	for _, bot := range r.CommunityBots {
+		if bot.Name == name {
+			return bot, true
 		}
+	}
 
+	return botspec.Bot{}, false
+
+}
+
+// loadCommunityBotDetails reads every bot in ./community, checking each
+// against its limits in cfg. It returns the bots that are valid, along with
+// the names of any that aren't and their problems.
+func loadCommunityBotDetails(cfg config.Config) ([]botspec.Bot, []string, error) {
+
+	bots, broken, err := botspec.LoadAll("./community", func(name string) botspec.ResourcePolicy {
+		return communityPolicy(cfg.Community.Limits(name))
+	})
+
+	if err != nil {
+		slog.Error("Could not load community bots. Run `bbb community lint ./community` for details.", "error", err)
 	}
 
-	fmt.Printf("Is %s an Expanso bot? %t\n", botHandle, isExpansoBot)
+	health.Report("community-bots", err)
 
-	return isExpansoBot
+	loaded := communityBotNames(bots)
+
+	for name := range cfg.Community.Bots {
+		if !slices.Contains(loaded, name) {
+			slog.Warn("There are limits for a community bot that isn't loaded", "bot", name)
+		}
+	}
+
+	return bots, broken, err
 
 }
 
-func loadCommunityBotDetails(ref *[]CommunityBot) error {
+// communityPolicy is what a community bot's job can ask for, given its limits.
+func communityPolicy(limits config.CommunityLimits) botspec.ResourcePolicy {
 
-	candidateBots, err := os.ReadDir("./community")
+	return botspec.ResourcePolicy{
+		MaxCPU: limits.MaxCPU,
+		MaxMemory: int64(limits.MaxMemory),
+		MaxDisk: int64(limits.MaxDisk),
+		MaxGPU: limits.MaxGPU,
+		MaxExecutionTimeout: int64(limits.JobTimeout),
+	}
 
+}
+
+// loadCommunitySecrets reads the secrets attached to community bots, and
+// makes sure they never appear in the logs. Bots that are missing secrets
+// they need are reported, but don't stop the others from being loaded.
+func loadCommunitySecrets(cfg config.Config, bots []botspec.Bot) (botsecrets.Store, error) {
+
+	secrets, err := botsecrets.Load(cfg.Community.SecretsFile)
 	if err != nil {
-        return err
-    }
- 
-    for _, cB := range candidateBots {
-		// fmt.Println(e.Name())
-		// *ref = append(*ref, e.Name()) // Correct: Assign back to *ref
+		return nil, err
+	}
 
-		workingDir := fmt.Sprintf("./community/%s/", cB.Name())
+	for _, value := range secrets.Values() {
+		logging.AddSecret(value)
+	}
 
-		infoFile, iErr := os.ReadFile(fmt.Sprintf("%s/info.json", workingDir))
-		jobFile, jErr := os.ReadFile(fmt.Sprintf("%s/job.yaml", workingDir))
+	errs := []error{}
 
-		if iErr != nil {
-			fmt.Println("Error loading %s info file:", iErr.Error())
-			continue
+	for _, bot := range bots {
+
+		if _, missing := secrets.For(bot.Name, bot.Secrets); len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("%s needs secrets that aren't set: %s", bot.Name, strings.Join(missing, ", ")))
 		}
 
-		if jErr != nil {
-			fmt.Println("Error loading %s job file:", jErr.Error())
-			continue
+		if undeclared := secrets.Undeclared(bot.Name, bot.Secrets); len(undeclared) > 0 {
+			slog.Warn("Community bot has secrets that aren't in its info.json, so they won't be passed to it", "bot", bot.Name, "secrets", undeclared)
 		}
 
-		bot := CommunityBot{}
+	}
+
+	missingErr := errors.Join(errs...)
+	if missingErr != nil {
+		slog.Error("Community bots won't run until their secrets are set", "error", missingErr)
+	}
+
+	health.Report("community-secrets", missingErr)
+
+	return secrets, nil
+
+}
 
-		unmarshalErr := json.Unmarshal(infoFile, &bot)
-		if unmarshalErr != nil {
-			fmt.Printf("Error parsing Community Bot JSON: %s\n", unmarshalErr.Error())
-			continue
+// startCommunityJob runs the bot's job for event, and replies with what it
+// outputs.
+func startCommunityJob(ctx context.Context, logger *slog.Logger, session *bsky.Session, event communityEvent, bot botspec.Bot, limits config.CommunityLimits, accountName string) {
+
+	failure := []bsky.Draft{{Text: generateFailureResponse()}}
+
+	resultsUUID := uuid.New().String()
+	outputKey := fmt.Sprintf("%suploads/%s.json", results.KeyPrefix, resultsUUID)
+
+	// Bots that ask for it get a link they can upload their output to, rather
+	// than printing it
+	var outputURL string
+
+	if slices.Contains(bot.EnvironmentVariables, "OUTPUT_URL") {
+
+		var uploadErr error
+		outputURL, uploadErr = RESULTS_STORE.PresignedUploadURL(outputKey, "application/json", JOB_UPLOAD_URL_EXPIRY)
+
+		if uploadErr != nil {
+			logger.Error("Could not create output URL for community bot job", "error", uploadErr)
+			replyToCommunityEvent(ctx, logger, session, event, failure, true)
+			return
 		}
 
-		bot.JobFile = string(jobFile)
+	}
 
-		*ref = append(*ref, bot)
+	var parent, quoted *bsky.Post
 
-    }
+	if event.Trigger.IsPost() {
 
-	return nil
+		// Posts from the firehose only come with their author's DID
+		if event.Notif.Author.Handle == "" {
+			if author, authorErr := bsky.GetProfile(session.AccessJwt, event.Notif.Author.Did); authorErr == nil {
+				event.Notif.Author = *author
+			} else {
+				logger.Warn("Could not get the author of the post for community bot", "error", authorErr)
+			}
+		}
+
+		if slices.Contains(bot.EnvironmentVariables, "INPUT") || slices.Contains(bot.EnvironmentVariables, "INPUT_FILE") {
+			parent, quoted = fetchContextPosts(ctx, logger, session, event.Notif)
+		}
+
+	}
+
+	input := communityInput(accountName, event, parent, quoted)
+
+	secrets, missingSecrets := BOTS.Load().Secrets.For(bot.Name, bot.Secrets)
+
+	if len(missingSecrets) > 0 {
+		logger.Error("Community bot needs secrets that aren't set", "secrets", missingSecrets)
+		replyToCommunityEvent(ctx, logger, session, event, failure, true)
+		return
+	}
+
+	var botState, userState botstore.State
+
+	if bot.Storage {
+
+		var stateErr error
+		botState, userState, stateErr = COMMUNITY_STORAGE.Load(bot.Name, storageUser(input))
+
+		if stateErr != nil {
+			logger.Error("Could not load community bot storage", "error", stateErr)
+			replyToCommunityEvent(ctx, logger, session, event, failure, true)
+			return
+		}
+
+	}
+
+	jobSpec, buildErr := buildCommunityJob(bot, input, outputURL, botState, userState, limits.JobTimeout)
+
+	if buildErr != nil {
+		logger.Error("Could not build community bot job", "error", buildErr)
+		replyToCommunityEvent(ctx, logger, session, event, failure, true)
+		return
+	}
+
+	logger.Debug("Generated community bot job", "job", jobSpec)
+
+	communityBotResult := runJob(ctx, logger, "community", bot.Name, jobSpec, limits.JobWaitTime)
+
+	// Whatever the bot prints is kept with its result, and may be posted
+	communityBotResult.Stdout = botsecrets.Redact(communityBotResult.Stdout, secrets)
+	communityBotResult.Stderr = botsecrets.Redact(communityBotResult.Stderr, secrets)
+
+	var outputFile []byte
+
+	if outputURL != "" {
+		uploaded, uploadErr := readJobOutput(outputKey)
+		switch {
+			case errors.Is(uploadErr, storage.ErrNotFound):
+				logger.Debug("Community bot didn't upload its output, so stdout is used")
+			case uploadErr != nil:
+				logger.Warn("Could not read the output the community bot uploaded, so stdout is used", "error", uploadErr)
+			default:
+				outputFile = []byte(botsecrets.Redact(string(uploaded), secrets))
+		}
+	}
+
+	output, replies, replyErr := communityBotReplies(communityBotResult, outputFile)
+
+	if replyErr == nil {
+		if stateErr := saveCommunityBotStorage(COMMUNITY_STORAGE, bot, input, output); stateErr != nil {
+			replyErr = stateErr
+			replies = failure
+		}
+	}
+
+	if replyErr != nil {
+		logger.Warn("Community bot didn't produce a reply", "job_id", communityBotResult.JobID, "error", replyErr)
+	}
+
+	record := results.Record{
+		ID: resultsUUID,
+		Type: results.TypeCommunity,
+		BotName: bot.Name,
+		BotTrigger: event.Trigger.Type,
+		BotError: output.Error,
+	}
+
+	if event.Trigger.IsPost() {
+		record.PostURI = event.Notif.Uri
+	}
+
+	if user := input.User(); user != nil {
+		record.PostAuthor = user.Handle
+	}
+
+	record.ApplyJobResult(communityBotResult)
+
+	if resultURL, saveErr := saveResultRecord(ctx, record); saveErr == nil {
+		logger.Info("Saved community bot result", "job_id", communityBotResult.JobID, "url", resultURL)
+	}
+
+	if len(replies) == 0 {
+		logger.Info("Community bot chose not to reply", "job_id", communityBotResult.JobID)
+		return
+	}
+
+	replyToCommunityEvent(ctx, logger, session, event, replies, replyErr != nil)
 
 }
 
-func startCommunityJob(session *bsky.Session, notif bsky.Notification, bot CommunityBot, accountName string) {
+// buildCommunityJob turns a bot's job file into the job that's submitted when
+// it's invoked, passing the details that the bot asked for, references to its
+// secrets, and its storage if it has any. Details that don't apply to the
+// trigger are left out. Jobs that don't set their own timeout are stopped
+// after timeout seconds.
+func buildCommunityJob(bot botspec.Bot, input botspec.Input, outputURL string, botState, userState botstore.State, timeout int) (string, error) {
 
-	processedPost := strings.Replace(notif.Record.Text, fmt.Sprintf("@%s", accountName), "", -1)
+	inputJSON, inputErr := json.Marshal(input)
+	if inputErr != nil {
+		return "", fmt.Errorf("could not marshal community bot input: %w", inputErr)
+	}
 
 	envVarValues := map[string]interface{}{
-		"POST" : notif.Record.Text,
-		"FROM" : notif.Author.Handle,
-		"IMAGES" : notif.Post.Images,
-		"PROCESSED_POST" : processedPost,
-		"WHOAMI" : accountName,
+		"WHOAMI" : input.Bot,
+		"TRIGGER" : input.Trigger,
+		"OUTPUT_URL" : outputURL,
+		"INPUT" : string(inputJSON),
+		"INPUT_FILE" : botspec.InputFilePath,
 	}
-	
+
+	if user := input.User(); user != nil {
+		envVarValues["FROM"] = user.Handle
+	}
+
+	if input.Post != nil {
+
+		imagesJSON, imagesErr := json.Marshal(input.Post.Images)
+		if imagesErr != nil {
+			return "", fmt.Errorf("could not marshal community bot images: %w", imagesErr)
+		}
+
+		envVarValues["POST"] = input.Post.Text
+		envVarValues["IMAGES"] = string(imagesJSON)
+		envVarValues["PROCESSED_POST"] = input.ProcessedText
+
+	}
+
+	if len(input.Keywords) > 0 {
+		envVarValues["KEYWORDS"] = strings.Join(input.Keywords, ",")
+	}
+
+	if input.Message != nil {
+		envVarValues["MESSAGE"] = input.Message.Text
+	}
+
+	if input.ScheduledAt != "" {
+		envVarValues["SCHEDULED_AT"] = input.ScheduledAt
+	}
+
 	envLoadErr := bacalhau.LoadEnvVarsToJob(&bot.JobFile, bot.EnvironmentVariables, envVarValues)
 	if envLoadErr != nil {
-		fmt.Println("Could not load env vars to Community Bot Job file.", envLoadErr.Error())
-		return
+		return "", fmt.Errorf("could not load env vars to community bot job file: %w", envLoadErr)
 	}
-	
+
 	jobJSON, convErr := bacalhau.ConvertYamlToJSON(bot.JobFile)
 
 	if convErr != nil {
-		fmt.Println("Failed to convert Job file to JSON:", convErr.Error())
-		return
+		return "", fmt.Errorf("could not convert community bot job file to JSON: %w", convErr)
 	}
 
-	fmt.Println("jobJSON:", jobJSON)
-
 	jobJSON["Name"] = fmt.Sprintf("%s (community)", jobJSON["Name"])
 
+	tasks, _ := jobJSON["Tasks"].([]interface{})
+	if len(tasks) == 0 {
+		return "", fmt.Errorf("community bot job file has no tasks")
+	}
+
+	task, isTask := tasks[0].(map[string]interface{})
+	if !isTask {
+		return "", fmt.Errorf("community bot job file's task isn't an object")
+	}
+
+	// The compute node fills in secrets from its own environment, so their
+	// values are never in the job. It keeps them under names that are
+	// specific to the bot, so a bot can't ask for another bot's secrets.
+	nodeNames := map[string]string{}
+	for _, name := range bot.Secrets {
+		nodeNames[name] = botsecrets.NodeName(bot.Name, name)
+	}
+
+	bacalhau.SetRenamedSecretReferences(task, nodeNames)
+
+	if slices.Contains(bot.EnvironmentVariables, "INPUT_FILE") {
+		bacalhau.AddInlineInput(task, botspec.InputFilePath, "application/json", inputJSON)
+	}
+
+	// Longer timeouts are rejected when the bot is loaded
+	timeouts, _ := task["Timeouts"].(map[string]interface{})
+	if timeouts == nil {
+		timeouts = map[string]interface{}{}
+		task["Timeouts"] = timeouts
+	}
+
+	if executionTimeout, _ := timeouts["ExecutionTimeout"].(float64); executionTimeout <= 0 {
+		timeouts["ExecutionTimeout"] = timeout
+	}
+
+	if bot.Storage {
+
+		files := []struct {
+			path  string
+			state botstore.State
+		}{
+			{botspec.StorageDir + "/bot.json", botState},
+			{botspec.StorageDir + "/user.json", userState},
+		}
+
+		for _, file := range files {
+
+			if file.state == nil {
+				file.state = botstore.State{}
+			}
+
+			stateJSON, stateErr := json.Marshal(file.state)
+			if stateErr != nil {
+				return "", fmt.Errorf("could not marshal community bot storage: %w", stateErr)
+			}
+
+			bacalhau.AddInlineInput(task, file.path, "application/json", stateJSON)
+
+		}
+
+	}
+
 	wrappedJob := map[string]interface{}{
 		"Job": jobJSON,
 	}
@@ -134,20 +483,218 @@ func startCommunityJob(session *bsky.Session, notif bsky.Notification, bot Commu
 	marshalledJSON, mErr := json.Marshal(wrappedJob)
 
 	if mErr != nil {
-		fmt.Println("Could not marshall JSON for Community Bot Job.", mErr.Error())
+		return "", fmt.Errorf("could not marshal community bot job: %w", mErr)
+	}
+
+	return string(marshalledJSON), nil
+
+}
+
+// storageUser is who a bot's per-user storage belongs to: whoever invoked it.
+// Bots invoked by their schedule have no user.
+func storageUser(input botspec.Input) string {
+
+	user := input.User()
+
+	if user == nil {
+		return ""
+	}
+
+	if user.DID != "" {
+		return user.DID
+	}
+
+	return user.Handle
+
+}
+
+// saveCommunityBotStorage makes the changes to its storage that the bot asked
+// for in its output.
+func saveCommunityBotStorage(store *botstore.Store, bot botspec.Bot, input botspec.Input, output botspec.Output) error {
+
+	if output.Storage == nil {
+		return nil
+	}
+
+	if !bot.Storage {
+		return fmt.Errorf(`the bot changed its storage, but storage isn't enabled in its info.json`)
+	}
+
+	if err := store.Apply(bot.Name, storageUser(input), output.Storage.Bot, output.Storage.User); err != nil {
+		return fmt.Errorf("could not save the bot's storage: %w", err)
+	}
+
+	return nil
+
+}
+
+// fetchContextPosts gets the posts that the mention replied to and quoted, if
+// it did either. A post that can't be fetched is left out.
+func fetchContextPosts(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification) (*bsky.Post, *bsky.Post) {
+
+	var parent, quoted *bsky.Post
+
+	if notif.Record.Reply != nil {
+
+		_, parentSpan := tracing.Start(ctx, "bluesky.get_parent_post")
+		parentPost, parentErr := bsky.GetRepliedToPost(session.AccessJwt, notif)
+		tracing.End(parentSpan, parentErr)
+
+		if parentErr != nil {
+			logger.Warn("Could not get parent post for community bot", "error", parentErr)
+		} else {
+			parent = parentPost
+		}
+
+	}
+
+	if notif.Record.Embed != nil && notif.Record.Embed.Type == "app.bsky.embed.record" && notif.Record.Embed.Record != nil {
+
+		_, quotedSpan := tracing.Start(ctx, "bluesky.get_quoted_post")
+		quotedPost, quotedErr := bsky.GetPostByUri(session.AccessJwt, notif.Record.Embed.Record.Uri)
+		tracing.End(quotedSpan, quotedErr)
+
+		if quotedErr != nil {
+			logger.Warn("Could not get quoted post for community bot", "quoted_post", notif.Record.Embed.Record.Uri, "error", quotedErr)
+		} else {
+			quoted = quotedPost
+		}
+
+	}
+
+	return parent, quoted
+
+}
+
+// readJobOutput reads what a job uploaded to its OUTPUT_URL, and removes it.
+// Anything can be uploaded to the URL until it expires, so the size is
+// checked before it's read.
+func readJobOutput(key string) ([]byte, error) {
+
+	object, statErr := RESULTS_STORE.Stat(key)
+	if statErr != nil {
+		return nil, statErr
+	}
+
+	defer func() {
+		if deleteErr := RESULTS_STORE.Delete(key); deleteErr != nil {
+			slog.Error("Could not delete the output a job uploaded", "key", key, "error", deleteErr)
+		}
+	}()
+
+	if object.Size > MAX_JOB_OUTPUT_SIZE {
+		return nil, fmt.Errorf("the output is %d bytes, which is more than the limit of %d", object.Size, MAX_JOB_OUTPUT_SIZE)
+	}
+
+	return RESULTS_STORE.Get(key)
+
+}
+
+// communityBotReplies turns a community bot's output into the posts to reply
+// with. The output is read from outputFile if the bot uploaded one, and from
+// stdout if not. Plain text too long for one post is split into a thread. If
+// the bot didn't produce a usable reply, the error says why and the posts are
+// a failure reply, unless the bot asked not to reply at all.
+func communityBotReplies(result bacalhau.JobExecutionResult, outputFile []byte) (botspec.Output, []bsky.Draft, error) {
+
+	failure := []bsky.Draft{{Text: generateFailureResponse()}}
+
+	if result.State != "Completed" {
+		return botspec.Output{}, failure, fmt.Errorf("the job didn't complete (state \"%s\")", result.State)
+	}
+
+	printed := result.Stdout
+	if len(outputFile) > 0 {
+		printed = string(outputFile)
+	}
+
+	output, parseErr := botspec.ParseOutput(printed)
+
+	if parseErr != nil {
+		return output, failure, parseErr
+	}
+
+	if output.Version == 0 {
+
+		if output.Text == "" {
+			return output, failure, fmt.Errorf("the bot printed nothing")
+		}
+
+		drafts := []bsky.Draft{}
+		for _, post := range bsky.SplitPost(output.Text) {
+			drafts = append(drafts, bsky.Draft{Text: post})
+		}
+
+		return output, drafts, nil
+
 	}
 
-	communityBotResult := bacalhau.CreateJob(string(marshalledJSON), 5)
-	fmt.Printf(`Community bot "%s" result: %s`, bot.Name, communityBotResult)
-	fmt.Println("JobID:", communityBotResult.JobID)
-	fmt.Println("ExecutionID:", communityBotResult.ExecutionID)
-	fmt.Println("Stdout:", communityBotResult.Stdout)
+	if output.Error != "" {
+
+		botErr := fmt.Errorf("the bot reported an error: %s", output.Error)
+
+		if output.NoReply {
+			return output, nil, botErr
+		}
 
-	sendReply(session, notif, communityBotResult.Stdout)
+		return output, failure, botErr
+
+	}
+
+	if output.NoReply {
+		return output, nil, nil
+	}
+
+	drafts, draftsErr := output.Drafts()
+
+	if draftsErr != nil {
+		return output, failure, draftsErr
+	}
+
+	return output, drafts, nil
 
 }
 
-func generateFailureResponse() string { 
+// runJob submits a job to Bacalhau and waits for its results, keeping count
+// of how many jobs are in flight and how each one finished.
+func runJob(ctx context.Context, logger *slog.Logger, command, bot, jobSpec string, timeToWaitForResults int) bacalhau.JobExecutionResult {
+
+	metrics.JobsSubmitted.WithLabelValues(command, bot).Inc()
+	metrics.JobsInFlight.Inc()
+	defer metrics.JobsInFlight.Dec()
+
+	result := bacalhau.CreateJob(ctx, jobSpec, timeToWaitForResults)
+
+	outcome := "timed_out"
+
+	switch {
+		case result.JobID == "":
+			outcome = "failed"
+		case result.State == "Completed":
+			outcome = "succeeded"
+		case result.State == "Failed" || result.State == "Cancelled" || result.State == "BidRejected":
+			outcome = "failed"
+	}
+
+	metrics.JobsCompleted.WithLabelValues(command, bot, outcome).Inc()
+
+	logger.Info("Job finished", "job_id", result.JobID, "execution_id", result.ExecutionID, "state", result.State, "outcome", outcome)
+	logger.Debug("Job output", "job_id", result.JobID, "stdout", result.Stdout, "stderr", result.Stderr)
+
+	// Nothing is waiting for a job that's still running, so it's stopped
+	// rather than left to use the network until its timeout. Callers count
+	// it towards their limits until then.
+	if outcome == "timed_out" && result.JobID != "" {
+		if _, stopErr := bacalhau.StopJob(result.JobID, "The bot stopped waiting for its results.", false); stopErr != nil {
+			logger.Warn("Could not stop job", "job_id", result.JobID, "error", stopErr)
+		}
+	}
+
+	return result
+
+}
+
+func generateFailureResponse() string {
 
 	var possibleErrorResponses = []string{
 		"Sorry! Something went wrong with the bot! Please try again later",
@@ -158,84 +705,433 @@ func generateFailureResponse() string {
 
 	selectedErrorResponse := possibleErrorResponses[rand.Intn(len(possibleErrorResponses))]
 
-	fmt.Println("selectedErrorResponse:", selectedErrorResponse)
-
 	return selectedErrorResponse
 
-}
+}
+
+func resultsRetention() time.Duration {
+	return time.Duration(RESULTS_RETENTION_DAYS) * 24 * time.Hour
+}
+
+func isResultExpired(createdAt time.Time) bool {
+	return time.Since(createdAt) > resultsRetention()
+}
+
+func uploadResult(ctx context.Context, key, result string) error {
+	objectKey := results.KeyPrefix + key + ".txt"
+	content := []byte(result)
+	contentType := "text/plain"
+
+	_, span := tracing.Start(ctx, "storage.upload_result", attribute.String("storage.key", objectKey))
+	err := RESULTS_STORE.Put(objectKey, content, contentType)
+	tracing.End(span, err)
+
+	if err != nil {
+		slog.Error("Could not upload result", "key", objectKey, "error", err)
+		return err
+	}
+
+	slog.Info("Uploaded result", "key", objectKey)
+	return nil
+}
+
+// generateResultLink returns an expiring link to a stored result. Depending on
+// RESULTS_LINK_MODE this is either a presigned URL straight to the storage
+// backend, or a signed link to the bot's own /job-result route.
+func generateResultLink(key string) (string, error) {
+
+	if RESULTS_LINK_MODE == "presigned" {
+
+		expiry := resultsRetention()
+		if expiry > MAX_PRESIGNED_URL_EXPIRY {
+			expiry = MAX_PRESIGNED_URL_EXPIRY
+		}
+
+		return RESULTS_STORE.PresignedURL(results.KeyPrefix + key + ".txt", expiry)
+
+	}
+
+	path := fmt.Sprintf("job-result/%s", key)
+	query := RESULTS_SIGNER.Sign(path, time.Now().Add(resultsRetention()))
+
+	return fmt.Sprintf("%s/%s?%s", CONFIG.Server.Origin, path, query.Encode()), nil
+
+}
+
+// removeExpiredResults deletes results once they're older than
+// RESULTS_RETENTION_DAYS. It runs once an hour for the life of the process.
+func removeExpiredResults() {
+
+	for {
+
+		objects, listErr := RESULTS_STORE.List("")
+
+		if listErr != nil {
+			slog.Error("Could not list results for retention", "error", listErr)
+		}
+
+		for _, object := range objects {
+
+			isResultObject := strings.HasPrefix(object.Key, results.KeyPrefix) || (!strings.Contains(object.Key, "/") && strings.HasSuffix(object.Key, ".txt"))
+
+			if !isResultObject || !isResultExpired(object.LastModified) {
+				continue
+			}
+
+			if deleteErr := RESULTS_STORE.Delete(object.Key); deleteErr != nil {
+				slog.Error("Could not delete expired result", "key", object.Key, "error", deleteErr)
+			} else {
+				slog.Info("Deleted expired result", "key", object.Key)
+			}
+
+		}
+
+		// Short links expire along with the results they point to
+		if BUILTIN_SHORTENER != nil {
+			if removed, removeErr := BUILTIN_SHORTENER.RemoveExpired(); removeErr != nil {
+				slog.Error("Could not delete expired short links", "error", removeErr)
+			} else if removed > 0 {
+				slog.Info("Deleted expired short links", "count", removed)
+			}
+		}
+
+		time.Sleep(1 * time.Hour)
+
+	}
+
+}
+
+// saveResultRecord stores the record and returns the URL of its results page.
+func saveResultRecord(ctx context.Context, record results.Record) (string, error) {
+
+	_, span := tracing.Start(ctx, "storage.save_result", attribute.String("bbb.result_id", record.ID))
+	err := RESULTS.Save(record)
+	tracing.End(span, err)
+
+	if err != nil {
+		slog.Error("Could not save result record", "result", record.ID, "error", err)
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/results/%s", CONFIG.Server.Origin, record.ID), nil
+
+}
+
+// resultPageContent picks the view for a record and the values it renders.
+func resultPageContent(record results.Record) (string, fiber.Map) {
+
+	content := fiber.Map{
+		"ID" : record.ID,
+		"CREATED_AT" : record.CreatedAt.UTC().Format("2 January 2006, 15:04 MST"),
+		"JOB_ID" : record.JobID,
+		"EXECUTION_ID" : record.ExecutionID,
+		"STATE" : record.State,
+		"STDOUT" : record.Stdout,
+		"STDERR" : record.Stderr,
+	}
+
+	if record.Duration > 0 {
+		content["DURATION"] = record.Duration.Round(time.Millisecond).String()
+	}
+
+	switch record.Type {
+		case results.TypeAltText:
+
+			content["LVM_TEXT"] = record.AltText
+			content["IMAGE_URL"] = record.ImageURL
+			content["IMAGE_ALT"] = record.AltText
+
+			if record.OCRText != "" {
+				content["OCR_TEXT"] = record.OCRText
+			}
+
+			setPageMetadata(content, "Alt-text for an image on Bluesky", record.AltText, record.ImageURL, "/results/" + record.ID)
+
+			return "alt-text", content
+
+		case results.TypeClassification:
+
+			labels := []fiber.Map{}
+
+			for _, label := range record.Labels {
+				labels = append(labels, fiber.Map{
+					"NAME" : label.Name,
+					"CONFIDENCE" : fmt.Sprintf("%.0f%%", label.Confidence * 100),
+				})
+			}
+
+			labelNames := []string{}
+
+			for _, label := range record.Labels {
+				labelNames = append(labelNames, label.Name)
+			}
+
+			description := "Nothing was detected in this image."
+			if len(labelNames) > 0 {
+				description = fmt.Sprintf("Objects detected with %s: %s.", record.Model, strings.Join(labelNames, ", "))
+			}
+
+			content["MODEL"] = record.Model
+			content["LABELS"] = labels
+
+			if record.HasImage {
+				content["IMAGE_URL"] = fmt.Sprintf("/results/%s/image", record.ID)
+				content["IMAGE_ALT"] = "The image with a labelled box drawn around each detected object. " + description
+			} else {
+				content["IMAGE_URL"] = record.ImageURL
+				content["IMAGE_ALT"] = "The classified image. " + description
+			}
+
+			setPageMetadata(content, "Image classification", description, content["IMAGE_URL"].(string), "/results/" + record.ID)
+
+			return "results/classification", content
+
+		case results.TypeCommunity:
+
+			content["BOT_NAME"] = record.BotName
+
+			setPageMetadata(content, fmt.Sprintf("Reply from the %s bot", record.BotName), record.Stdout, "", "/results/" + record.ID)
+
+			return "results/community", content
+
+		default:
+
+			if rawOutputURL, linkErr := generateResultLink(record.ID); linkErr == nil {
+				content["RAW_OUTPUT_URL"] = rawOutputURL
+			}
+
+			setPageMetadata(content, "Bacalhau job results", fmt.Sprintf("Output of Bacalhau job %s", record.JobID), "", "/results/" + record.ID)
+
+			return "results/job-run", content
+
+	}
+
+}
+
+// setResultPageHeaders locks down the pages that display job output, so
+// anything that slips through escaping still can't run scripts or load
+// resources from elsewhere.
+func setResultPageHeaders(c *fiber.Ctx) error {
+
+	c.Set("Content-Security-Policy", strings.Join([]string{
+		"default-src 'none'",
+		"style-src 'self' https://fonts.googleapis.com",
+		"font-src https://fonts.gstatic.com",
+		"img-src 'self' https://cdn.bsky.app",
+		"base-uri 'none'",
+		"form-action 'none'",
+		"frame-ancestors 'none'",
+	}, "; "))
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("Referrer-Policy", "no-referrer")
+
+	return c.Next()
+
+}
+
+func sendAPIError(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error" : message,
+	})
+}
+
+func sendRecordJSON(c *fiber.Ctx, record results.Record, loadErr error) error {
+
+	if errors.Is(loadErr, storage.ErrNotFound) {
+		return sendAPIError(c, fiber.StatusNotFound, "result not found")
+	}
+
+	if loadErr != nil {
+		slog.Error("Could not load result record", "error", loadErr)
+		return sendAPIError(c, fiber.StatusInternalServerError, "could not load result")
+	}
+
+	if isResultExpired(record.CreatedAt) {
+		return sendAPIError(c, fiber.StatusGone, "result expired")
+	}
+
+	return c.JSON(fiber.Map{
+		"result" : record,
+		"expiresAt" : record.CreatedAt.Add(resultsRetention()),
+		"url" : fmt.Sprintf("%s/results/%s", CONFIG.Server.Origin, record.ID),
+	})
+
+}
+
+// setPageMetadata fills in the title, description and Open Graph tags that
+// the layout renders, so that links to results unfurl into a useful card.
+func setPageMetadata(content fiber.Map, title, description, image, path string) {
+
+	// Cards only show a couple of lines, so keep descriptions short
+	description = strings.Join(strings.Fields(description), " ")
+	if descriptionRunes := []rune(description); len(descriptionRunes) > 200 {
+		description = strings.TrimSpace(string(descriptionRunes[:199])) + "…"
+	}
+
+	if strings.HasPrefix(image, "/") {
+		image = CONFIG.Server.Origin + image
+	}
+
+	content["PAGE_TITLE"] = title
+	content["PAGE_DESCRIPTION"] = description
+	content["PAGE_IMAGE"] = image
+	content["PAGE_URL"] = CONFIG.Server.Origin + path
+
+}
+
+// monitorDependencies checks the dependencies that aren't otherwise exercised
+// while the bot is idle, so /readyz reflects their current state.
+func monitorDependencies() {
+
+	health.Register("bacalhau", "", true)
+	health.Register("storage", "", true)
+
+	for {
+
+		health.Report("bacalhau", bacalhau.CheckOrchestrator())
+
+		storageErr := RESULTS_STORE.Put("health/last-check", []byte(time.Now().Format(time.RFC3339)), "text/plain")
+		if storageErr == nil {
+			_, storageErr = RESULTS_STORE.Get("health/last-check")
+		}
+
+		health.Report("storage", storageErr)
+
+		time.Sleep(30 * time.Second)
+
+	}
+
+}
+
+// shortenURL shortens a link and records whether the shortener is working.
+func shortenURL(ctx context.Context, targetURL string) (string, error) {
+
+	_, span := tracing.Start(ctx, "shortener.shorten")
+	shortURL, err := SHORTENER.Shorten(targetURL)
+	tracing.End(span, err)
+
+	health.Report("shortener", err)
+
+	return shortURL, err
+
+}
+
+func renderExpiredResult(c *fiber.Ctx) error {
+
+	content := fiber.Map{
+		"RETENTION_DAYS" : RESULTS_RETENTION_DAYS,
+	}
+
+	setPageMetadata(content, "This result has expired", fmt.Sprintf("Results from the Bacalhau bots are kept for %d days.", RESULTS_RETENTION_DAYS), "", c.Path())
+
+	c.Status(fiber.StatusGone)
+	return c.Render("expired", content, "layouts/main")
+
+}
+
+func dispatchClassificationJobAndPostReply(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification, imageURL string, isHotDogJob bool, isArbitraryClassJob bool, className string, options bacalhau.ClassificationOptions) {
+	// Generate and create the Bacalhau job
+	bTest, bErr := bacalhau.GenerateClassificationJob(imageURL, isHotDogJob, className, options)
+	if bErr != nil {
+		logger.Error("Could not generate classification job", "error", bErr)
+		sendReply(ctx, logger, session, notif, generateFailureResponse())
+		return
+	}
+
+	logger.Debug("Generated classification job", "job", bTest)
+
+	result := runJob(ctx, logger, "classify", "", bTest, accountSettings(session).JobWaitTime)
+
+	// Parse the labels and bounding boxes the classifier printed
+	classification, parseErr := bacalhau.ParseClassificationOutput(result.Stdout)
+	if parseErr != nil {
+		logger.Error("Could not parse classification output", "job_id", result.JobID, "error", parseErr)
+		sendReply(ctx, logger, session, notif, generateFailureResponse())
+		return
+	}
+
+	// Extract classes from analysis text
+	analysisText := strings.Split(classification.ResultsText, "\n")[0]
+	classes := []string{}
+	if analysisWords := strings.Split(analysisText, " "); len(analysisWords) > 3 {
+		classes = strings.Split(strings.Join(analysisWords[3:], " "), ",")
+	}
+
+	// Prefer the per-label confidences if the classifier reported them
+	detections := bacalhau.FilterDetections(classification.Detections, options)
 
-func uploadResultAndGetPublicURL(key, result string) (string, error) {
-	bucketName := os.Getenv("RESULTS_BUCKET")
-	uploader, err := s3uploader.NewS3Uploader(bucketName)
-	if err != nil {
-		fmt.Printf("Failed to initialize S3Uploader: %v\n", err)
-		return "", err
-	}
+	logger.Debug("Parsed classification output", "job_id", result.JobID, "analysis", analysisText, "classes", classes, "detections", detections)
 
-	objectKey := key + ".txt"
-	content := []byte(result)
-	contentType := "text/plain"
+	// Draw the boxes onto the original Bluesky image
+	_, downloadSpan := tracing.Start(ctx, "bluesky.download_image")
+	imageFile, imageErr := helpers.DownloadFile(imageURL)
+	tracing.End(downloadSpan, imageErr)
 
-	publicURL, err := uploader.UploadFile(objectKey, content, contentType)
-	if err != nil {
-		fmt.Printf("Failed to upload file: %v\n", err)
-		return "", err
+	if imageErr != nil {
+		logger.Error("Could not retrieve original image", "url", imageURL, "error", imageErr)
+		sendReply(ctx, logger, session, notif, generateFailureResponse())
+		return
 	}
 
-	fmt.Printf("File uploaded successfully! Public URL: %s\n", publicURL)
-	return publicURL, nil
-}
+	annotatedImage, annotateErr := annotate.DrawDetections(imageFile, detections)
+	if annotateErr != nil {
+		logger.Warn("Could not draw detections onto image", "error", annotateErr)
+	} else {
+		imageFile = annotatedImage
+	}
 
-func dispatchClassificationJobAndPostReply(session *bsky.Session, notif bsky.Notification, imageURL string, isHotDogJob bool, isArbitraryClassJob bool, className string) {
-	// Generate and create the Bacalhau job
-	bTest, bErr := bacalhau.GenerateClassificationJob(imageURL, isHotDogJob, className)
-	fmt.Println("Classification job specification, error:", bTest, bErr)
+	record := results.Record{
+		ID: uuid.New().String(),
+		Type: results.TypeClassification,
+		PostURI: notif.Uri,
+		PostAuthor: notif.Author.Handle,
+		ImageURL: imageURL,
+		Model: options.Model,
+		HasImage: true,
+	}
 
-	result := bacalhau.CreateJob(bTest, DEFAULT_JOB_WAIT_TIME)
-	fmt.Println("Classification Job result:", result)
-	fmt.Println("JobID:", result.JobID)
-	fmt.Println("ExecutionID:", result.ExecutionID)
-	fmt.Println("Stdout:", result.Stdout)
+	record.ApplyJobResult(result)
 
-	// Parse out classificationID
-	splitStdoutStr := ">>> Results ID <<<"
-	classificationID := strings.TrimSpace(strings.Split(result.Stdout, splitStdoutStr)[1])
-	fmt.Println("classificationID:", classificationID)
+	for _, detection := range detections {
+		record.Labels = append(record.Labels, results.Label{
+			Name: strings.TrimSpace(detection.Label),
+			Confidence: detection.Confidence,
+		})
+	}
 
-	// Download results (metadata + image) from S3
-	objectStorageBaseURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", os.Getenv("S3_IMAGE_BUCKET"), os.Getenv("AWS_REGION"))
+	var resultsLink string
 
-	metadataFile, metadataErr := helpers.DownloadFile(objectStorageBaseURL + classificationID + ".json")
-	imageFile, imageErr := helpers.DownloadFile(objectStorageBaseURL + classificationID)
+	_, imageSaveSpan := tracing.Start(ctx, "storage.save_image", attribute.String("bbb.result_id", record.ID))
+	imageSaveErr := RESULTS.SaveImage(record.ID, imageFile)
+	tracing.End(imageSaveSpan, imageSaveErr)
 
-	if metadataErr != nil {
-		fmt.Println("Could not retrieve result metadata:", metadataErr)
-	}
-	if imageErr != nil {
-		fmt.Println("Could not retrieve result image:", imageErr)
+	if imageSaveErr != nil {
+		logger.Error("Could not save annotated image", "result", record.ID, "error", imageSaveErr)
+		record.HasImage = false
 	}
 
-	// Parse JSON metadata
-	var jsonObj map[string]interface{}
-	err := json.Unmarshal(metadataFile, &jsonObj)
-	if err != nil {
-		fmt.Printf("Error parsing JSON: %v\n", err)
-		return
+	if resultURL, saveErr := saveResultRecord(ctx, record); saveErr == nil {
+		if shortURL, sURLErr := shortenURL(ctx, resultURL); sURLErr == nil {
+			resultsLink = shortURL
+		} else {
+			logger.Warn("Could not shorten results URL", "error", sURLErr)
+		}
 	}
 
-	// Extract classes from analysis text
-	analysisText := strings.Split(jsonObj["resultsText"].(string), "\n")[0]
-	classes := strings.Split(strings.Join(strings.Split(analysisText, " ")[3:], " "), ",")
-
-	fmt.Println("metadataStr:", string(metadataFile))
-	fmt.Println("Analysis:", analysisText)
-	fmt.Println("Classes:", classes)
-
 	// Prepare reply text
 	replyText := ""
 	if !isHotDogJob {
-		replyText = fmt.Sprintf("Using the model '%s', ", os.Getenv("CLASSIFICATION_IMAGE"))
-		if len(classes) > 0 {
+		replyText = fmt.Sprintf("Using the model '%s', ", options.Model)
+		if len(classification.Detections) > 0 && len(detections) > 0 {
+			replyText += "I can see...\n\n"
+			for _, detection := range detections {
+				replyText += fmt.Sprintf("%s (%.0f%%)\n", strings.TrimSpace(detection.Label), detection.Confidence * 100)
+			}
+			if resultsLink != "" {
+				replyText += "\nDetails: " + resultsLink + "\n"
+			}
+			replyText += "\n\n🐟🐟🐟🐟🐟🐟🐟🐟🐟🐟\n\n"
+		} else if len(classification.Detections) == 0 && len(classes) > 0 {
 			replyText += "I can see...\n\n"
 			for _, class := range classes {
 				replyText += fmt.Sprintf("%s\n", strings.TrimSpace(class))
@@ -251,7 +1147,7 @@ func dispatchClassificationJobAndPostReply(session *bsky.Session, notif bsky.Not
 	}
 
 	// Send reply (with image)
-	sendReplyWithImage(session, notif, replyText, imageFile)
+	sendReplyWithImage(ctx, logger, session, notif, replyText, imageFile)
 }
 
 //>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
@@ -260,7 +1156,7 @@ func dispatchClassificationJobAndPostReply(session *bsky.Session, notif bsky.Not
 //<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<
 //<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<
 
-func respondWhileAltTextIsDown(session *bsky.Session, notif bsky.Notification) {
+func respondWhileAltTextIsDown(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification) {
 
 	var possibleResponses = []string{
 		"The alt-text bot is offline for maintenance at the moment, so we're not able to get you alt-text at this time. Sorry!",
@@ -270,11 +1166,11 @@ func respondWhileAltTextIsDown(session *bsky.Session, notif bsky.Notification) {
 
 	selectedResponse := possibleResponses[ rand.Intn( len( possibleResponses ) ) ]
 
-	sendReply(session, notif, selectedResponse)
+	sendReply(ctx, logger, session, notif, selectedResponse)
 
 }
 
-func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notification) {
+func dispatchAltTextJobAndPostReply(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification) {
 
 	// 1. Image in post
 	// 2. Image in quoted post
@@ -295,19 +1191,23 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 
 	if notif.Post.PostType == "reply" {
 
+		_, parentSpan := tracing.Start(ctx, "bluesky.get_parent_post")
 		parentPost, pPostErr := bsky.GetRepliedToPost(session.AccessJwt, notif)
+		tracing.End(parentSpan, pPostErr)
 
 		if pPostErr != nil {
-			fmt.Printf("Parent Post err: %s\n", pPostErr.Error())
+			logger.Error("Could not get parent post", "error", pPostErr)
+			sendReply(ctx, logger, session, notif, generateFailureResponse())
+			return
 		}
 
-		fmt.Printf("Parent Post: %+v\n", parentPost)
+		logger.Debug("Got parent post", "post", parentPost)
 
 		if len(parentPost.Images) > 0 {
 			imageToGenerateAltTextFor = parentPost.Images[0].Url
 		} else {
 			// Handle no images being present
-			sendReply(session, notif, selectedEmptyResponse)
+			sendReply(ctx, logger, session, notif, selectedEmptyResponse)
 			return
 		}
 
@@ -315,14 +1215,16 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 
 	if notif.Post.PostType == "quote" {
 
-		fmt.Println("Nested Post:", notif.Record.Embed.Record.Uri)
+		logger.Debug("Getting quoted post", "quoted_post", notif.Record.Embed.Record.Uri)
 
+		_, quotedSpan := tracing.Start(ctx, "bluesky.get_quoted_post")
 		nestedPost, nPErr := bsky.GetPostByUri(session.AccessJwt, notif.Record.Embed.Record.Uri)
+		tracing.End(quotedSpan, nPErr)
 
 		if nPErr != nil {
-			fmt.Println("nPErr:", nPErr.Error())
+			logger.Error("Could not get quoted post", "quoted_post", notif.Record.Embed.Record.Uri, "error", nPErr)
 			failureResponse := generateFailureResponse()
-			sendReply(session, notif, failureResponse)
+			sendReply(ctx, logger, session, notif, failureResponse)
 			return
 		} else {
 
@@ -330,7 +1232,7 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 				imageToGenerateAltTextFor = nestedPost.Images[0].Url
 			} else {
 				// Handle no images being present
-				sendReply(session, notif, selectedEmptyResponse)
+				sendReply(ctx, logger, session, notif, selectedEmptyResponse)
 				return
 			}
 
@@ -341,60 +1243,50 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 	if notif.Post.PostType == "post" {
 
 		if len(notif.Post.Images) > 0 {
-			fmt.Println("Image in post:", notif.Post.Images[0].Url)
 			imageToGenerateAltTextFor = notif.Post.Images[0].Url
 		} else {
 			// Handle no images being present
-			sendReply(session, notif, selectedEmptyResponse)
+			sendReply(ctx, logger, session, notif, selectedEmptyResponse)
 			return
 		}
 
 	}
 
-	fmt.Println("Determined type:", notif.Post.PostType)
-	fmt.Println("Selected Image:", imageToGenerateAltTextFor)
+	logger.Info("Selected image for alt-text", "post_type", notif.Post.PostType, "image", imageToGenerateAltTextFor)
 
-	prompt := os.Getenv("ALT_TEXT_JOB_PROMPT")
+	prompt := accountSettings(session).AltTextPrompt
 
 	if prompt == "" {
 		prompt = "Briefly, what is in this image?"
 	}
 
-	fmt.Println("Prompt Text:", prompt)
+	logger.Debug("Using alt-text prompt", "prompt", prompt)
 
 	altTextJob, jErr := bacalhau.GenerateAltTextJob(imageToGenerateAltTextFor, prompt)
 
 	if jErr != nil {
-		fmt.Printf("Could not generate alt-text Job file: %s", jErr.Error())
+		logger.Error("Could not generate alt-text job", "error", jErr)
 		failureResponse := generateFailureResponse()
-		sendReply(session, notif, failureResponse)
+		sendReply(ctx, logger, session, notif, failureResponse)
 		return
 	}
 
-	altTextResult := bacalhau.CreateJob(altTextJob, 20)
-	fmt.Println("Alt-text result:", altTextResult)
-	fmt.Println("JobID:", altTextResult.JobID)
-	fmt.Println("ExecutionID:", altTextResult.ExecutionID)
-	fmt.Println("Stdout:", altTextResult.Stdout)
+	altTextResult := runJob(ctx, logger, "alt-text", "", altTextJob, 20)
 
 	ocrJob, ocrJErr := bacalhau.GenerateOCRJob(imageToGenerateAltTextFor)
 
 	if ocrJErr != nil {
-		fmt.Printf("Could not generate alt-text Job file: %s", ocrJErr.Error())
+		logger.Error("Could not generate OCR job", "error", ocrJErr)
 	}
 
-	ocrTextResult := bacalhau.CreateJob(ocrJob, 10)
-	fmt.Println("OCR result:", ocrTextResult)
-	fmt.Println("JobID:", ocrTextResult.JobID)
-	fmt.Println("ExecutionID:", ocrTextResult.ExecutionID)
-	fmt.Println("Stdout:", ocrTextResult.Stdout)
+	ocrTextResult := runJob(ctx, logger, "ocr", "", ocrJob, 10)
 
 	if altTextResult.Stdout == ""{
 
-		fmt.Printf(`Job "%s" failed to produce alt-text in the permitted timeframe.`, altTextResult.JobID)
+		logger.Warn("Job failed to produce alt-text in the permitted timeframe", "job_id", altTextResult.JobID)
 
 		errorResponseTxt := generateFailureResponse()
-		sendReply(session, notif, errorResponseTxt)
+		sendReply(ctx, logger, session, notif, errorResponseTxt)
 
 	} else {
 
@@ -412,52 +1304,37 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 
 		}
 
-		fmt.Println("truncatedAltText:", truncatedAltText)
-
-		payload := map[string]string{
-			"ALT_TEXT" : altTextResult.Stdout,
-			"OCR_TEXT" : ocrTextResult.Stdout,
-			"IMAGE_URL" : imageToGenerateAltTextFor,
+		record := results.Record{
+			ID: resultsUUID,
+			Type: results.TypeAltText,
+			PostURI: notif.Uri,
+			PostAuthor: notif.Author.Handle,
+			ImageURL: imageToGenerateAltTextFor,
+			AltText: altTextResult.Stdout,
+			OCRText: ocrTextResult.Stdout,
 		}
 
-		payloadBytes, payloadMarshallErr := json.Marshal(payload)
-		if payloadMarshallErr != nil {
-
-			fmt.Printf("Unable to marshall result for upload: %s", payloadMarshallErr.Error())
-			sendReply(session, notif, truncatedAltText)
+		record.ApplyJobResult(altTextResult)
 
-			return
+		targetURL, saveErr := saveResultRecord(ctx, record)
 
+		if saveErr != nil {
+			sendReply(ctx, logger, session, notif, truncatedAltText)
 		} else {
 
-			_, uploadErr := uploadResultAndGetPublicURL(resultsUUID, string(payloadBytes))
-	
-			if uploadErr != nil {
-				fmt.Println("Failed to upload result to Object Storage:", uploadErr.Error())
-				sendReply(session, notif, truncatedAltText)
-			} else {
-	
-				// Generate shortURL and payload in 
-				// preparation for results display
-
-				targetURL := fmt.Sprintf("%s/alt-text-result/%s", os.Getenv("SERVER_ORIGIN"), resultsUUID)
-
-				shortURL, sURLErr := gancho.GenerateShortURL(targetURL)
+			// Generate shortURL in preparation for results display
+			shortURL, sURLErr := shortenURL(ctx, targetURL)
 
-				if sURLErr != nil {
-					fmt.Println("Could not generate shortURL for results with Gancho:", sURLErr)
-					sendReply(session, notif, truncatedAltText)
-				} else {
-
-					fmt.Println("shortURL:", shortURL)
+			if sURLErr != nil {
+				logger.Warn("Could not shorten results URL", "error", sURLErr)
+				sendReply(ctx, logger, session, notif, truncatedAltText)
+			} else {
 
-					shortLinkStr := "\n\nLonger description + OCR:\n" + shortURL
+				shortLinkStr := "\n\nLonger description + OCR:\n" + shortURL
 
-					truncatedAltText += shortLinkStr
-					sendReply(session, notif, truncatedAltText)
+				truncatedAltText += shortLinkStr
+				sendReply(ctx, logger, session, notif, truncatedAltText)
 
-				}
-	
 			}
 
 		}
@@ -466,28 +1343,25 @@ func dispatchAltTextJobAndPostReply(session *bsky.Session, notif bsky.Notificati
 
 }
 
-func dispatchBacalhauJobAndPostReply(session *bsky.Session, notif bsky.Notification, jobFileLink string) {
-	fmt.Println("Starting dispatchBacalhauJobAndPostReply...")
-	fmt.Println("Job file link:", jobFileLink)
+func dispatchBacalhauJobAndPostReply(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification, jobFileLink string) {
+	logger.Info("Getting job file", "url", jobFileLink)
 
 	// Step 1: Retrieve the job file
 	jobFile, jobFileErr := bacalhau.GetJobFileFromURL(jobFileLink)
 	if jobFileErr != nil {
-		fmt.Println("Could not get job file to dispatch job:", jobFileErr)
+		logger.Error("Could not get job file", "url", jobFileLink, "error", jobFileErr)
 		jobRetrievalErrTxt := fmt.Sprintf(
 			"Sorry! Something went wrong when trying to get your Job file 😔\n\nThe error that came back was %s\n\nPlease check your Job file and try again!",
 			jobFileErr,
 		)
-		sendReply(session, notif, jobRetrievalErrTxt)
+		sendReply(ctx, logger, session, notif, jobRetrievalErrTxt)
 		return
 	}
 
-	fmt.Println("Job file retrieved successfully:", jobFile)
+	logger.Debug("Got job file", "job", jobFile)
 
 	// Step 2: Dispatch the job
-	fmt.Println("Dispatching job to Bacalhau...")
-	result := bacalhau.CreateJob(jobFile, DEFAULT_JOB_WAIT_TIME)
-	fmt.Println("CreateJob result:", result)
+	result := runJob(ctx, logger, "job-file", "", jobFile, accountSettings(session).JobWaitTime)
 
 	// Check if the JobID is empty (failure case)
 	if result.JobID == "" {
@@ -497,23 +1371,37 @@ func dispatchBacalhauJobAndPostReply(session *bsky.Session, notif bsky.Notificat
 			"2. Missing a node with matching requirements.\n" +
 			"3. Disallowed job configuration.\n" +
 			"4. Unexpected error on our end. We're keeping track of potential issues!"
-		sendReply(session, notif, replyText)
+		sendReply(ctx, logger, session, notif, replyText)
 		return
 	}
 
 	// Step 5: Determine the reply text based on ExecutionID and Stdout
 	var replyText string
 	if result.ExecutionID != "" && result.Stdout != "" {
-		jobResultContent := result.Stdout
-		publicURL, uploadErr := uploadResultAndGetPublicURL(result.ExecutionID, jobResultContent)
-		if uploadErr != nil {
-			fmt.Println("Could not upload file to S3:", uploadErr)
+		record := results.Record{
+			ID: uuid.New().String(),
+			Type: results.TypeJobRun,
+			PostURI: notif.Uri,
+			PostAuthor: notif.Author.Handle,
+		}
+
+		record.ApplyJobResult(result)
+
+		// Keep the raw output alongside the record so it can be downloaded
+		if uploadErr := uploadResult(ctx, record.ID, result.Stdout); uploadErr != nil {
+			logger.Error("Could not upload job output", "job_id", result.JobID, "error", uploadErr)
+			return
+		}
+
+		resultURL, saveErr := saveResultRecord(ctx, record)
+		if saveErr != nil {
+			logger.Error("Could not save job result", "job_id", result.JobID, "error", saveErr)
 			return
 		}
 
-		shortlink, slErr := gancho.GenerateShortURL(publicURL)
+		shortlink, slErr := shortenURL(ctx, resultURL)
 		if slErr != nil {
-			shortlink = publicURL
+			shortlink = resultURL
 		}
 
 		replyText = fmt.Sprintf(
@@ -523,7 +1411,6 @@ func dispatchBacalhauJobAndPostReply(session *bsky.Session, notif bsky.Notificat
 				"Explore more with Bacalhau! Check out our docs at https://docs.bacalhau.org",
 			result.JobID, result.ExecutionID, shortlink,
 		)
-		fmt.Println("Execution successful. Reply prepared:", replyText)
 
 	} else {
 		replyText = fmt.Sprintf(
@@ -533,74 +1420,121 @@ func dispatchBacalhauJobAndPostReply(session *bsky.Session, notif bsky.Notificat
 				"2. Run `bacalhau job describe %s` to get results.",
 			result.JobID,
 		)
-		fmt.Println("Execution incomplete or failed. Reply prepared:", replyText)
 
 		// Optionally stop the job after a timeout
 		if result.JobID != "" {
-			fmt.Println("Stopping job with JobID:", result.JobID)
+			logger.Info("Stopping job", "job_id", result.JobID)
 			go bacalhau.StopJob(result.JobID, "The job ran too long for the Bacalhau Bot to tolerate.", true)
 		}
 	}
 
 	// Step 6: Send the reply
-	sendReply(session, notif, replyText)
+	sendReply(ctx, logger, session, notif, replyText)
 }
 
-func sendReplyWithImage(session *bsky.Session, notif bsky.Notification, replyText string, image []byte) {
-	fmt.Println("Preparing to send reply...")
+func sendReplyWithImage(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification, replyText string, image []byte) {
+	logger.Debug("Sending reply", "text", replyText)
 
 	var (
 		responseUri string
 		err         error
 	)
 
-	if os.Getenv("DRY_RUN") != "true" {
+	_, span := tracing.Start(ctx, "bluesky.reply", attribute.Bool("bluesky.with_image", true))
+	defer span.End()
+
+	if !accountSettings(session).DryRun {
 		responseUri, err = bsky.ReplyToMentionWithImage(session.AccessJwt, notif, replyText, image, session.Did)
 		if err != nil {
-			fmt.Println("Error responding to mention:", err)
+			tracing.RecordError(span, err)
+			logger.Error("Could not reply to mention", "error", err)
 			return
 		}
 	} else {
 		responseUri = "DRY_RUN_URI"
 	}
 
-	fmt.Println("Reply sent successfully. Response URI:", responseUri)
+	logger.Info("Sent reply", "reply", responseUri)
 	bsky.RecordResponse(responseUri)
+	observeMentionToReply(notif)
+}
+
+// observeMentionToReply records how long the author waited for a reply
+func observeMentionToReply(notif bsky.Notification) {
+	if mentionedAt, err := time.Parse(time.RFC3339, notif.Record.CreatedAt); err == nil {
+		metrics.MentionToReply.Observe(time.Since(mentionedAt).Seconds())
+	}
+}
+
+// mentionLogger ties every log line about a mention back to the notification
+// and the command it asked for
+func mentionLogger(ctx context.Context, accountLogger *slog.Logger, notif bsky.Notification, command string) *slog.Logger {
+
+	logger := accountLogger.With("notification", notif.Uri, "command", command)
+
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		logger = logger.With("trace_id", traceID)
+	}
+
+	return logger
+
 }
 
 // Helper to send replies
-func sendReply(session *bsky.Session, notif bsky.Notification, replyText string) {
-	fmt.Println("Preparing to send reply...")
+func sendReply(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification, replyText string) {
+	sendReplies(ctx, logger, session, notif, []bsky.Draft{{Text: replyText}})
+}
+
+// sendReplies replies to the mention with each post in turn, as a thread.
+func sendReplies(ctx context.Context, logger *slog.Logger, session *bsky.Session, notif bsky.Notification, posts []bsky.Draft) {
+
+	withImage := false
+	for _, post := range posts {
+		logger.Debug("Sending reply", "text", post.Text, "images", len(post.Images), "link", post.Link != nil, "labels", post.Labels)
+		withImage = withImage || len(post.Images) > 0
+	}
 
 	var (
-		responseUri string
-		err         error
+		responseUris []string
+		err          error
 	)
 
-	if os.Getenv("DRY_RUN") != "true" {
-		responseUri, err = bsky.ReplyToMention(session.AccessJwt, notif, replyText, session.Did)
+	_, span := tracing.Start(ctx, "bluesky.reply", attribute.Bool("bluesky.with_image", withImage), attribute.Int("bluesky.posts", len(posts)))
+	defer span.End()
+
+	if !accountSettings(session).DryRun {
+		responseUris, err = bsky.ReplyToMentionInThread(session.AccessJwt, notif, posts, session.Did)
+		for _, responseUri := range responseUris {
+			bsky.RecordResponse(responseUri)
+		}
 		if err != nil {
-			fmt.Println("Error responding to mention:", err)
+			tracing.RecordError(span, err)
+			logger.Error("Could not reply to mention", "error", err, "posted", len(responseUris))
 			return
 		}
 	} else {
-		responseUri = "DRY_RUN_URI"
+		responseUris = []string{"DRY_RUN_URI"}
+		bsky.RecordResponse("DRY_RUN_URI")
 	}
 
-	fmt.Println("Reply sent successfully. Response URI:", responseUri)
-	bsky.RecordResponse(responseUri)
+	logger.Info("Sent reply", "reply", responseUris[0], "posts", len(responseUris))
+	observeMentionToReply(notif)
 }
 
 func startHTTPServer() {
 	// http.HandleFunc("/__gtg", healthCheckHandler)
 
-	port := ":8080" // Default port
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = ":" + envPort
-	}
+	port := fmt.Sprintf(":%d", CONFIG.Server.Port)
 
 	engine := handlebars.New("./views", ".hbs")
 
+	// Model and OCR output can contain anything, so it's escaped before any
+	// line breaks are added. Templates should use {{nl2br VALUE}} rather than
+	// triple braces for multi-line text.
+	engine.AddFunc("nl2br", func(text string) raymond.SafeString {
+		return raymond.SafeString(strings.ReplaceAll(raymond.Escape(text), "\n", "<br/>"))
+	})
+
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage : true,
 		Views: engine,
@@ -614,42 +1548,262 @@ func startHTTPServer() {
 
 	app.Static("/", "./static")
 
-    // Define a route for the GET method on the root path '/'
-    app.Get("/__gtg", func(c *fiber.Ctx) error {
-        // Send a string response to the client
-		c.Status(200)
-        return nil
-    })
+	// The local storage backend has no server of its own, so we serve its objects
+	if localStore, isLocal := RESULTS_STORE.(*storage.Local); isLocal {
+
+		app.Get(storage.LocalRoutePrefix + "/*", func(c *fiber.Ctx) error {
+
+			key := c.Params("*")
+
+			if !localStore.VerifySignature(key, c.Query("expires"), c.Query("signature")) {
+				return fiber.ErrForbidden
+			}
+
+			content, err := localStore.Get(key)
+
+			if err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					return fiber.ErrNotFound
+				}
+				slog.Error("Could not read from local storage", "key", key, "error", err)
+				return err
+			}
+
+			c.Type(strings.TrimPrefix(filepath.Ext(key), "."))
+			return c.Send(content)
+
+		})
+
+		// Jobs upload their output to presigned URLs rather than holding storage credentials
+		app.Put(storage.LocalRoutePrefix + "/*", func(c *fiber.Ctx) error {
+
+			key := c.Params("*")
+
+			if !localStore.VerifyUploadSignature(key, c.Query("expires"), c.Query("signature")) {
+				return fiber.ErrForbidden
+			}
+
+			if err := localStore.Put(key, c.Body(), c.Get(fiber.HeaderContentType)); err != nil {
+				slog.Error("Could not write to local storage", "key", key, "error", err)
+				return err
+			}
+
+			return c.SendStatus(fiber.StatusOK)
+
+		})
+
+	}
+
+	// Liveness: the process is up and serving requests
+	liveness := func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"status" : "ok",
+		})
+	}
+
+	app.Get("/__gtg", liveness)
+	app.Get("/healthz", liveness)
+
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+
+	// The admin API is only served when there's a token to protect it
+	if CONFIG.Server.AdminToken != "" {
+		app.Post("/admin/reload", requireAdminToken, handleReloadRequest)
+	}
+
+	// Readiness: every dependency the bot needs to respond to mentions is healthy
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+
+		ready, checks := health.Readiness()
+
+		if !ready {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+
+		return c.JSON(fiber.Map{
+			"ready" : ready,
+			"checks" : checks,
+		})
+
+	})
+
+	app.Get(shortener.RoutePrefix + "/:code", setResultPageHeaders, func(c *fiber.Ctx) error {
+
+		link, lErr := BUILTIN_SHORTENER.Resolve(c.Params("code"))
+
+		if lErr != nil {
+			if errors.Is(lErr, shortener.ErrExpired) {
+				return renderExpiredResult(c)
+			}
+			if errors.Is(lErr, storage.ErrNotFound) {
+				return fiber.ErrNotFound
+			}
+			slog.Error("Could not resolve short link", "code", c.Params("code"), "error", lErr)
+			return lErr
+		}
+
+		return c.Redirect(link.URL, fiber.StatusFound)
+
+	})
+
+	app.Get("/job-result/:id", setResultPageHeaders, func(c *fiber.Ctx) error {
+
+		path := fmt.Sprintf("job-result/%s", c.Params("id"))
+
+		valid, expired := RESULTS_SIGNER.Verify(path, c.Query("expires"), c.Query("signature"))
+
+		if !valid {
+			return fiber.ErrForbidden
+		}
+
+		if expired {
+			return renderExpiredResult(c)
+		}
+
+		resultsKey := fmt.Sprintf("%s%s.txt", results.KeyPrefix, c.Params("id"))
+
+		object, statErr := RESULTS_STORE.Stat(resultsKey)
+
+		if errors.Is(statErr, storage.ErrNotFound) || (statErr == nil && isResultExpired(object.LastModified)) {
+			return renderExpiredResult(c)
+		}
+
+		output, rErr := RESULTS_STORE.Get(resultsKey)
+
+		if rErr != nil {
+			slog.Error("Could not read job result", "key", resultsKey, "error", rErr)
+			return rErr
+		}
+
+		c.Type("txt", "utf-8")
+		return c.Send(output)
+
+	})
+
+	app.Get("/results/:id" + UUIDRouteRegex, setResultPageHeaders, func(c *fiber.Ctx) error {
+
+		record, rErr := RESULTS.Load(c.Params("id"))
+
+		if errors.Is(rErr, storage.ErrNotFound) || (rErr == nil && isResultExpired(record.CreatedAt)) {
+			return renderExpiredResult(c)
+		}
+
+		if rErr != nil {
+			slog.Error("Could not load result record", "result", c.Params("id"), "error", rErr)
+			return rErr
+		}
+
+		view, content := resultPageContent(record)
+
+		return c.Render(view, content, "layouts/main")
+
+	})
+
+	app.Get("/results/:id" + UUIDRouteRegex + "/image", setResultPageHeaders, func(c *fiber.Ctx) error {
+
+		record, rErr := RESULTS.Load(c.Params("id"))
+
+		if errors.Is(rErr, storage.ErrNotFound) || (rErr == nil && (isResultExpired(record.CreatedAt) || !record.HasImage)) {
+			return fiber.ErrNotFound
+		}
+
+		if rErr != nil {
+			return rErr
+		}
+
+		image, iErr := RESULTS.LoadImage(record.ID)
+
+		if iErr != nil {
+			slog.Error("Could not load result image", "result", record.ID, "error", iErr)
+			return fiber.ErrNotFound
+		}
+
+		c.Type("jpg")
+		return c.Send(image)
+
+	})
+
+	// JSON API, for dashboards and other clients. It serves the same records
+	// that the results pages render. Browsers can only use it from other
+	// origins if they're configured.
+	apiHandlers := []fiber.Handler{etag.New()}
+
+	if len(CONFIG.Server.CORSOrigins) > 0 {
+		apiHandlers = append([]fiber.Handler{cors.New(cors.Config{
+			AllowOrigins: strings.Join(CONFIG.Server.CORSOrigins, ","),
+			AllowMethods: "GET,HEAD,OPTIONS",
+		})}, apiHandlers...)
+	}
+
+	api := app.Group("/api/v1", apiHandlers...)
+
+	api.Get("/results/:id" + UUIDRouteRegex, func(c *fiber.Ctx) error {
+
+		record, rErr := RESULTS.Load(c.Params("id"))
+
+		return sendRecordJSON(c, record, rErr)
+
+	})
+
+	api.Get("/jobs/:jobId", func(c *fiber.Ctx) error {
+
+		record, rErr := RESULTS.LoadByJobID(c.Params("jobId"))
+
+		return sendRecordJSON(c, record, rErr)
+
+	})
+
+	api.Get("/bots", func(c *fiber.Ctx) error {
+
+		bots := []fiber.Map{}
+
+		registry := BOTS.Load()
+
+		for _, bot := range registry.CommunityBots {
 
-	app.Get("/alt-text-result/:uuid" + UUIDRouteRegex, func(c *fiber.Ctx) error {
-		
-		fmt.Println("Alt-Text Results ID:", c.Params("uuid"))
+			account, _ := registry.Accounts.ForBot(bot.Name)
+
+			bots = append(bots, fiber.Map{
+				"name" : bot.Name,
+				"handle" : account.Handle,
+				"triggers" : bot.Triggers,
+				"storage" : bot.Storage,
+				"environmentVariables" : bot.EnvironmentVariables,
+				"repo" : bot.Repo,
+				"author" : bot.Author,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"bots" : bots,
+		})
+
+	})
+
+	app.Get("/alt-text-result/:uuid" + UUIDRouteRegex, setResultPageHeaders, func(c *fiber.Ctx) error {
 
 		resultsKey := fmt.Sprintf("%s.txt", c.Params("uuid"))
-		
-		bucketName := os.Getenv("RESULTS_BUCKET")
-		s3Client, clientErr := s3uploader.NewS3Uploader(bucketName)
 
-		if clientErr != nil {
-			fmt.Println("Client err:", clientErr.Error())
+		object, statErr := RESULTS_STORE.Stat(resultsKey)
+
+		if errors.Is(statErr, storage.ErrNotFound) || (statErr == nil && isResultExpired(object.LastModified)) {
+			return renderExpiredResult(c)
 		}
 
-		results, rErr := s3Client.GetObject(resultsKey)
+		results, rErr := RESULTS_STORE.Get(resultsKey)
 
 		if rErr != nil {
-			fmt.Println("S3 Err:", rErr.Error())
+			slog.Error("Could not read alt-text result", "key", resultsKey, "error", rErr)
 			return rErr
 		} else {
 
 			var jsonObj map[string]interface{}
 			unmarshalErr := json.Unmarshal(results, &jsonObj)
 			if unmarshalErr != nil {
-				fmt.Printf("Error parsing JSON: %v\n", unmarshalErr)
+				slog.Error("Could not parse alt-text result", "key", resultsKey, "error", unmarshalErr)
 				return unmarshalErr
 			}
 
-			fmt.Println(jsonObj["ALT_TEXT"].(string))
-
 			altText, altTextOk := jsonObj["ALT_TEXT"].(string)
 			ocrText, ocrTextOk := jsonObj["OCR_TEXT"].(string)
 			imageURL, imageURLOk := jsonObj["IMAGE_URL"].(string)
@@ -665,25 +1819,17 @@ func startHTTPServer() {
 				imageURL = ""
 			}
 
-			fmt.Println("OCR_TEXT:", ocrText)
-
 			content := fiber.Map{
 				"LVM_TEXT" : altText,
 				"IMAGE_URL" : imageURL,
+				"IMAGE_ALT" : altText,
 			}
 			
 			if ocrText != "" {
-
-				formattedOCR := strings.ReplaceAll(ocrText, "\n", "<br/>")
-
-				content = fiber.Map{
-					"LVM_TEXT" : altText,
-					"OCR_TEXT" : formattedOCR,
-					"IMAGE_URL" : imageURL,
-				}
+				content["OCR_TEXT"] = ocrText
 			}
-			
-			fmt.Printf("%+v", content)
+
+			setPageMetadata(content, "Alt-text for an image on Bluesky", altText, imageURL, c.Path())
 
 			return c.Render("alt-text", content, "layouts/main")
 
@@ -695,57 +1841,159 @@ func startHTTPServer() {
     err := app.Listen(port)
 
 	if err != nil {
-		fmt.Printf("HTTP server failed: %s\n", err.Error())
-		fmt.Println("Unexpected condition in application. Exiting.")
+		slog.Error("HTTP server failed. Exiting.", "error", err)
 		os.Exit(1)
 	}
 
 }
 
+func configureBacalhau(cfg config.Config) {
+	bacalhau.Configure(bacalhau.Config{
+		Host: cfg.Bacalhau.Host,
+		Port: cfg.Bacalhau.Port,
+		Secure: cfg.Bacalhau.Secure,
+		AccessToken: cfg.Bacalhau.AccessToken,
+		ClassificationImage: cfg.Jobs.ClassificationImage,
+		ClassificationModels: cfg.Jobs.ClassificationModels,
+		OpenAIOrigin: cfg.Jobs.OpenAIOrigin,
+		OpenAIModel: cfg.Jobs.OpenAIModel,
+		AWSRegion: cfg.Storage.Region,
+	})
+}
+
 func main() {
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Load environment variables
 	err := godotenv.Load()
+
 	if err != nil {
-		fmt.Println("Could not find .env file. Continuing with existing environment variables.")
+		slog.Info("Could not find .env file. Continuing with existing environment variables.")
 	}
 
-	var BLUESKY_ACCOUNTS []map[string]interface{}
-
-	accountLoadErr := json.Unmarshal([]byte(os.Getenv("BLUESKY_ACCOUNTS")), &BLUESKY_ACCOUNTS)
+	cfg, configErr := config.Load()
 
-	if accountLoadErr != nil {
-		fmt.Println("Could not load bot account credentials from environment variables. Exiting.")
-		fmt.Printf("Error: %s\n", accountLoadErr.Error())
+	if configErr != nil {
+		slog.Error("Invalid configuration. Exiting.", "error", configErr)
 		os.Exit(1)
 	}
 
-	if len(BLUESKY_ACCOUNTS) == 0 {
-		fmt.Println("No account credentials have been set with the BLUESKY_ACCOUNTS environment variable. A stringified JSON object with at least 1 username:password combo should be set for program to execute. Exiting.")
+	CONFIG = cfg
+
+	logging.Setup(CONFIG.Logging.Level, CONFIG.Logging.Format)
+
+	for _, secret := range CONFIG.Secrets() {
+		logging.AddSecret(secret)
+	}
+
+	shutdownTracing, tracingErr := tracing.Setup(CONFIG.Tracing.Exporter)
+
+	if tracingErr != nil {
+		slog.Error("Could not set up tracing. Exiting.", "error", tracingErr)
 		os.Exit(1)
 	}
 
-	bacalhau.BACALHAU_HOST = os.Getenv("BACALHAU_HOST")
-	fmt.Printf("Bacalhau Orchestrator Hostname: %s\n", bacalhau.BACALHAU_HOST)
+	// Flush any buffered spans before the process is stopped
+	go func() {
 
-	if os.Getenv("DEFAULT_JOB_WAIT_TIME") == "" {
-		DEFAULT_JOB_WAIT_TIME = 30
-	} else {
-		
-		waitTime, convErr := strconv.Atoi( os.Getenv("DEFAULT_JOB_WAIT_TIME") )
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
 
-		if convErr != nil {
-			fmt.Println(fmt.Sprintf(`An error occured converting DEFAULT_JOB_WAIT_TIME environment variable to an integer. Defaulting to 30 seconds: %s`, convErr.Error()))
-			DEFAULT_JOB_WAIT_TIME = 30
-		} else {
-			DEFAULT_JOB_WAIT_TIME = waitTime
+		if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+			slog.Error("Could not flush traces", "error", shutdownErr)
 		}
 
+		os.Exit(0)
+
+	}()
+
+	configureBacalhau(CONFIG)
+	slog.Info("Using Bacalhau orchestrator", "host", CONFIG.Bacalhau.Host, "port", CONFIG.Bacalhau.Port)
+
+	bsky.Configure(bsky.Config{
+		RespondedFile: CONFIG.Bluesky.RespondedFile,
+	})
+
+	DEFAULT_JOB_WAIT_TIME = CONFIG.Bacalhau.JobWaitTime
+
+	// Bots that can't be loaded are reported, but don't stop the others from running
+	botRegistry, registryErr := buildBotRegistry(CONFIG, nil)
+
+	if registryErr != nil {
+		slog.Error("Invalid bot configuration. Exiting.", "error", registryErr)
+		os.Exit(1)
+	}
+
+	swapBotRegistry(botRegistry)
+
+	store, storeErr := storage.New(storage.Config{
+		Backend: CONFIG.Storage.Backend,
+		Bucket: CONFIG.Storage.Bucket,
+		Region: CONFIG.Storage.Region,
+		Endpoint: CONFIG.Storage.Endpoint,
+		UsePathStyle: CONFIG.Storage.PathStyle,
+		PublicURL: CONFIG.Storage.PublicURL,
+		LocalDirectory: CONFIG.Storage.LocalDir,
+		BaseURL: CONFIG.Server.Origin,
+		SigningKey: CONFIG.Storage.SigningKey,
+	})
+
+	if storeErr != nil {
+		slog.Error("Could not initialise results storage. Exiting.", "error", storeErr)
+		os.Exit(1)
+	}
+
+	RESULTS_STORE = store
+	RESULTS = results.NewStore(store)
+	COMMUNITY_STORAGE = botstore.NewStore(store, botstore.Quota{
+		Bot: CONFIG.Community.BotStorageQuota,
+		User: CONFIG.Community.UserStorageQuota,
+	})
+
+	signer, signerErr := storage.NewSigner(CONFIG.Storage.SigningKey)
+
+	if signerErr != nil {
+		slog.Error("Could not initialise result link signing. Exiting.", "error", signerErr)
+		os.Exit(1)
+	}
+
+	RESULTS_SIGNER = signer
+
+	RESULTS_RETENTION_DAYS = CONFIG.Results.RetentionDays
+	RESULTS_LINK_MODE = CONFIG.Results.LinkMode
+
+	// Our own shortener is always available, and is used on its own if Gancho
+	// isn't configured, or as a fallback if a request to Gancho fails.
+	BUILTIN_SHORTENER = shortener.NewBuiltin(RESULTS_STORE, CONFIG.Server.Origin, resultsRetention())
+
+	go removeExpiredResults()
+
+	health.Register("shortener", "", false)
+
+	ganchoClient, ganchoErr := gancho.New(CONFIG.Gancho.Endpoint, CONFIG.Gancho.Key)
+
+	if ganchoErr != nil {
+		slog.Info("Gancho isn't configured. Using the built-in URL shortener.")
+		SHORTENER = BUILTIN_SHORTENER
+	} else {
+		SHORTENER = shortener.Fallback{ganchoClient, BUILTIN_SHORTENER}
 	}
 
-	EXPANSO_BOTS = strings.Split( os.Getenv("EXPANSO_BOTS"), ",")
-	fmt.Println("EXPANSO_BOTS:", EXPANSO_BOTS)
+	go monitorDependencies()
+
+	metrics.RegisterDedupStoreSize(func() float64 {
+		return float64(bsky.CountResponses())
+	})
+
+	go watchForChanges()
+	go reloadOnSignal()
 
-	loadCommunityBotDetails(&COMMUNITY_BOTS)
+	go watchFirehose()
+	go runCommunitySchedules()
 
 	// Start HTTP server for healthchecks
 	go startHTTPServer()
@@ -754,112 +2002,142 @@ func main() {
 
 	for {
 
-		for idx, bskyAccount := range BLUESKY_ACCOUNTS {
+		bots := BOTS.Load()
 
-			bskyHandle, handleOk := bskyAccount["username"].(string)
-			bskyPass, passOk := bskyAccount["pass"].(string)
+		for _, bskyAccount := range bots.Accounts.All() {
 
-			if !handleOk || !passOk {
-				fmt.Printf("Invalid account data at index %d\n", idx)
-				continue
-			}
+			go func(account accounts.Account) {
 
-			fmt.Printf("Attempting to authenticate for user: %s\n", bskyHandle)
+				username := account.Handle
 
-			go func(username, password string) {
+				accountLogger := slog.With("account", username, "behaviour", account.Behaviour)
 
-				// Authenticate with Bluesky API
-				session, err := bsky.Authenticate(username, password)
+				accountLogger.Debug("Authenticating")
+
+				// Authenticate with Bluesky API, reusing the account's session
+				session, err := BLUESKY_SESSIONS.get(account, time.Now())
 				if err != nil {
-					fmt.Println( fmt.Sprintf(`Could not authenticate "%s": %s`, username, err.Error()) )
+					accountLogger.Error("Could not authenticate", "error", err)
+					health.Report("bluesky:" + username, fmt.Errorf("authentication failed: %w", err))
+					return
+				}
+
+				if didErr := bots.Accounts.SignedIn(username, session.Did); didErr != nil {
+					accountLogger.Error("Signed in as the wrong account", "error", didErr)
+					health.Report("bluesky:" + username, didErr)
 					return
 				}
 
-				fmt.Println( fmt.Sprintf(`Fetching notifications for handle "%s"...`, bskyHandle) )
+				accountLogger.Debug("Fetching notifications")
 
+				pollCtx, pollSpan := tracing.Start(context.Background(), "bluesky.poll", attribute.String("bluesky.account", username))
+				defer pollSpan.End()
+
+				_, fetchSpan := tracing.Start(pollCtx, "bluesky.fetch_notifications")
 				notifications, err := bsky.FetchNotifications(session.AccessJwt)
+				fetchSpan.SetAttributes(attribute.Int("bluesky.notifications", len(notifications)))
+				tracing.End(fetchSpan, err)
+
+				health.Report("bluesky:" + username, err)
+
+				if err == nil {
+					metrics.NotificationsFetched.WithLabelValues(username).Add(float64(len(notifications)))
+				}
+
 				if err != nil {
-					fmt.Printf("Error fetching notifications for handle %s: %s\n", bskyHandle, err.Error())
+					accountLogger.Error("Could not fetch notifications", "error", err)
+					// The session may have expired, so the next poll signs in again
+					BLUESKY_SESSIONS.forget(username)
 					time.Sleep(10 * time.Second)
 					return
 				}
 
-				if isExpansoBotAccount(bskyHandle) {
-	
-					for _, notif := range notifications {
-					// Process only "mention" notifications if they match a command
-
-						isPostACommand, postComponents, commandType, className := bacalhau.CheckPostIsCommand(notif.Record.Text, username)
-						
-						if notif.Reason == "mention" && bsky.ShouldRespond(notif) && !bsky.HasResponded(notif.Uri) && isPostACommand {
-							
-
-								fmt.Printf("Command detected: %s\n", notif.Record.Text)
-		
-								// Dispatch the appropriate job
-								switch commandType {
-									case "job_file":
-										go dispatchBacalhauJobAndPostReply(session, notif, postComponents.Url)
-									case "classify_image":
-										go dispatchClassificationJobAndPostReply(session, notif, notif.ImageURL, false, false, className)
-									case "hotdog":
-										go dispatchClassificationJobAndPostReply(session, notif, notif.ImageURL, true, false, className)
-									case "arbitraryClass":
-										go dispatchClassificationJobAndPostReply(session, notif, notif.ImageURL, true, true, className)
-									case "altText":
-										go dispatchAltTextJobAndPostReply(session, notif)
-								}
-		
-								fmt.Printf("Dispatched jobs and responses to mention: %s\n", notif.Record.Text)
-								bsky.RecordResponse(notif.Uri)
-
-								
-						}
-
-						
+				if account.Behaviour == config.BehaviourCommunity {
+
+					communityBot, found := bots.findCommunityBot(account.Bot)
+
+					if !found {
+						accountLogger.Error("Community bot isn't loaded", "bot", account.Bot)
+						return
 					}
 
-				} else {
+					handleCommunityNotifications(pollCtx, accountLogger, session, notifications, communityBot, account)
+					handleCommunityMessages(pollCtx, accountLogger, session, communityBot, account)
 
-					// Start working through the community bots...
+					return
 
-					for _, communityBot := range COMMUNITY_BOTS{
+				}
 
-						fmt.Println("Community Bot name:", communityBot.Name)
+				for _, notif := range notifications {
+				// Process only "mention" notifications if they match a command
 
-						for _, notif := range notifications {
+					if notif.Reason != "mention" || !bsky.ShouldRespond(notif) || bsky.HasResponded(notif.Uri) {
+						continue
+					}
 
-							// targetHandle := notif.Author.Handle
-							// fmt.Println(targetHandle)
+					ctx, mentionSpan := tracing.StartMention(pollCtx, notif.Uri)
 
-							// targetAccount := strings.Split(username, ".bots.bacalhau.org")[0]
+					// Alt-text accounts respond to every mention, so there's no command to parse
+					isPostACommand, postComponents, commandType, className := true, bsky.PostComponents{Text: notif.Record.Text}, "altText", ""
 
-							// fmt.Println("targetAccount:", targetAccount)
-							// fmt.Println("targetAccount == Bot?", targetAccount == communityBot.Name)
+					if account.Behaviour == config.BehaviourCommands {
+						_, commandSpan := tracing.Start(ctx, "bacalhau.check_post_is_command")
+						isPostACommand, postComponents, commandType, className = bacalhau.CheckPostIsCommand(notif.Record.Text, username)
+						commandSpan.SetAttributes(attribute.Bool("bbb.is_command", isPostACommand), attribute.String("bbb.command", commandType))
+						commandSpan.End()
+					}
 
-							if notif.Reason == "mention" && bsky.ShouldRespond(notif) && !bsky.HasResponded(notif.Uri){
+					if !isPostACommand {
+						mentionSpan.End()
+						continue
+					}
 
-								// fmt.Println("Would respond to this notification:", notif.Uri)
+					mentionSpan.SetAttributes(attribute.String("bbb.command", commandType))
 
-								go startCommunityJob(session, notif, communityBot, username)
+					logger := mentionLogger(ctx, accountLogger, notif, commandType)
+					logger.Info("Command detected", "text", notif.Record.Text)
+					metrics.CommandsParsed.WithLabelValues(commandType).Inc()
 
-								bsky.RecordResponse(notif.Uri)
+					var dispatch func()
 
+					// Dispatch the appropriate job
+					switch commandType {
+						case "job_file":
+							dispatch = func() { dispatchBacalhauJobAndPostReply(ctx, logger, session, notif, postComponents.Url) }
+						case "classify_image":
+							classifyOptions, optionsErr := bacalhau.ParseClassificationOptions(notif.Record.Text)
+							if optionsErr != nil {
+								dispatch = func() { sendReply(ctx, logger, session, notif, fmt.Sprintf("Sorry! I couldn't understand that classify request: %s", optionsErr.Error())) }
+							} else {
+								dispatch = func() { dispatchClassificationJobAndPostReply(ctx, logger, session, notif, notif.ImageURL, false, false, className, classifyOptions) }
 							}
+						case "hotdog":
+							dispatch = func() { dispatchClassificationJobAndPostReply(ctx, logger, session, notif, notif.ImageURL, true, false, className, bacalhau.DefaultClassificationOptions()) }
+						case "arbitraryClass":
+							dispatch = func() { dispatchClassificationJobAndPostReply(ctx, logger, session, notif, notif.ImageURL, true, true, className, bacalhau.DefaultClassificationOptions()) }
+						case "altText":
+							dispatch = func() { dispatchAltTextJobAndPostReply(ctx, logger, session, notif) }
+						default:
+							dispatch = func() {}
+					}
 
-						}
+					// The mention's trace ends once its reply has been sent
+					go func() {
+						defer mentionSpan.End()
+						dispatch()
+					}()
 
-					}
+					logger.Info("Dispatched jobs and responses to mention")
+					bsky.RecordResponse(notif.Uri)
 
 				}
-				
 
-			}(bskyHandle, bskyPass)
+			}(bskyAccount)
 
 		}
 
 		time.Sleep(10 * time.Second)
-		fmt.Println("Waiting 10 seconds...")
+		slog.Debug("Waiting 10 seconds...")
 
 	}
 
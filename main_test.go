@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bbb/botspec"
+	"bbb/bsky"
+)
+
+const testJobFile = `Name: calculator
+Type: batch
+Count: 1
+Tasks:
+  - Name: main
+    Engine:
+      Type: docker
+      Params:
+        Image: example/calculator:latest
+    Resources:
+      CPU: "1"
+      Memory: 128MB
+`
+
+func testCommunityInput() botspec.Input {
+
+	notif := bsky.Notification{
+		Uri: "at://did:plc:alice/app.bsky.feed.post/1",
+		Cid: "cid",
+		Reason: botspec.TriggerMention,
+		Author: bsky.Author{Did: "did:plc:alice", Handle: "alice.example.com"},
+		Record: bsky.Record{Text: "@calculator.bots.example.com 2+2"},
+	}
+
+	return botspec.NewInput("calculator.bots.example.com", botspec.TriggerMention, notif, nil, nil)
+
+}
+
+func TestBuildCommunityJob(t *testing.T) {
+
+	bot := botspec.Bot{
+		Name: "calculator",
+		EnvironmentVariables: []string{"POST", "FROM", "KEYWORDS"},
+		Secrets: []string{"CALCULATOR_API_KEY"},
+		JobFile: testJobFile,
+	}
+
+	jobSpec, err := buildCommunityJob(bot, testCommunityInput(), "", nil, nil, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job struct {
+		Job struct {
+			Name string
+			Tasks []struct {
+				Engine struct {
+					Params struct {
+						EnvironmentVariables []string
+					}
+				}
+				Env map[string]string
+				Timeouts struct {
+					ExecutionTimeout int
+				}
+			}
+		}
+	}
+
+	if err := json.Unmarshal([]byte(jobSpec), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	task := job.Job.Tasks[0]
+
+	// Keywords don't apply to mentions, so they're left out
+	wantVariables := []string{"POST=@calculator.bots.example.com 2+2", "FROM=alice.example.com"}
+	if !reflect.DeepEqual(task.Engine.Params.EnvironmentVariables, wantVariables) {
+		t.Errorf("got environment variables %q, want %q", task.Engine.Params.EnvironmentVariables, wantVariables)
+	}
+
+	// Secrets are only referred to, by the bot's own name for them, for the
+	// compute node to fill in
+	if want := map[string]string{"CALCULATOR_API_KEY": "env:BBB_CALCULATOR__CALCULATOR_API_KEY"}; !reflect.DeepEqual(task.Env, want) {
+		t.Errorf("got Env %v, want %v", task.Env, want)
+	}
+
+	if job.Job.Name != "calculator (community)" || task.Timeouts.ExecutionTimeout != 120 {
+		t.Errorf("got name %q and timeout %d", job.Job.Name, task.Timeouts.ExecutionTimeout)
+	}
+
+}
+
+func TestBuildCommunityJobWithoutTasks(t *testing.T) {
+
+	tests := []struct {
+		name string
+		jobFile string
+	}{
+		{"no tasks", "Name: calculator\nType: batch\nTasks: []\n"},
+		{"task isn't an object", "Name: calculator\nType: batch\nTasks:\n  - main\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					t.Fatalf("panicked: %v", recovered)
+				}
+			}()
+
+			bot := botspec.Bot{Name: "calculator", JobFile: test.jobFile}
+
+			if _, err := buildCommunityJob(bot, testCommunityInput(), "", nil, nil, 120); err == nil || !strings.Contains(err.Error(), "task") {
+				t.Errorf("expected an error about the job's tasks, got %v", err)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	NotificationsFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_notifications_fetched_total",
+		Help: "Notifications fetched from Bluesky, by account.",
+	}, []string{"account"})
+
+	CommandsParsed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_commands_total",
+		Help: "Mentions recognised as commands, by command type.",
+	}, []string{"command"})
+
+	JobsSubmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_jobs_submitted_total",
+		Help: "Bacalhau jobs submitted, by command and community bot.",
+	}, []string{"command", "bot"})
+
+	JobsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_jobs_completed_total",
+		Help: "Bacalhau jobs that finished, by command, community bot and outcome (succeeded, failed or timed_out).",
+	}, []string{"command", "bot", "outcome"})
+
+	JobsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bbb_jobs_in_flight",
+		Help: "Bacalhau jobs that have been dispatched and are waiting for results.",
+	})
+
+	MentionToReply = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bbb_mention_to_reply_seconds",
+		Help:    "Time from a mention being posted to the bot's reply being sent.",
+		Buckets: []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600},
+	})
+
+	BacalhauRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bbb_bacalhau_request_duration_seconds",
+		Help:    "Latency of requests to the Bacalhau orchestrator, by endpoint and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+
+	BlueskyRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_bluesky_request_errors_total",
+		Help: "Failed requests to the Bluesky API, by endpoint and status code.",
+	}, []string{"endpoint", "status"})
+
+	BlueskyRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_bluesky_rate_limited_total",
+		Help: "Requests to the Bluesky API rejected by rate limiting, by endpoint.",
+	}, []string{"endpoint"})
+
+	CommunityTriggers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_community_triggers_total",
+		Help: "Times community bots were triggered, by bot, trigger and outcome (dispatched, rate_limited or queue_full).",
+	}, []string{"bot", "trigger", "outcome"})
+
+	CommunityJobsQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bbb_community_jobs_queued",
+		Help: "Community bot jobs waiting for one of the bot's other jobs to finish, by bot.",
+	}, []string{"bot"})
+
+	Reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbb_reloads_total",
+		Help: "Reloads of the configuration and community bots, by trigger (watch, signal or admin) and outcome (applied or rejected).",
+	}, []string{"trigger", "outcome"})
+)
+
+// RegisterDedupStoreSize reports the number of notifications recorded as
+// responded to, calling size whenever metrics are scraped.
+func RegisterDedupStoreSize(size func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bbb_dedup_store_size",
+		Help: "Notifications recorded as already responded to.",
+	}, size)
+}
+
+// statusLabel turns the outcome of an HTTP request into a label value.
+func statusLabel(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// ObserveBacalhauRequest records the latency and status of a request to the orchestrator.
+func ObserveBacalhauRequest(endpoint string, start time.Time, resp *http.Response, err error) {
+	BacalhauRequestDuration.WithLabelValues(endpoint, statusLabel(resp, err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveBlueskyRequest counts failed and rate limited requests to Bluesky.
+func ObserveBlueskyRequest(endpoint string, resp *http.Response, err error) {
+
+	if err == nil && resp != nil && resp.StatusCode < 400 {
+		return
+	}
+
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		BlueskyRateLimited.WithLabelValues(endpoint).Inc()
+	}
+
+	BlueskyRequestErrors.WithLabelValues(endpoint, statusLabel(resp, err)).Inc()
+
+}
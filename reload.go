@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bbb/accounts"
+	"bbb/botspec"
+	"bbb/config"
+	"bbb/health"
+	"bbb/logging"
+	"bbb/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How often the config file and ./community are checked for changes
+const RELOAD_WATCH_INTERVAL = 5 * time.Second
+
+// Only one reload runs at a time, however it was triggered
+var reloadMutex sync.Mutex
+
+// swapBotRegistry makes next the live registry. Mentions that are already
+// being handled keep the bot and session they started with.
+func swapBotRegistry(next *BotRegistry) {
+
+	current := map[string]bool{}
+
+	for _, account := range next.Accounts.All() {
+		current[account.Handle] = true
+		health.Register("bluesky:" + account.Handle, "bluesky", true)
+		slog.Info("Loaded account", "account", account.Handle, "behaviour", account.Behaviour, "bot", account.Bot, "dry_run", account.Settings.DryRun)
+	}
+
+	disabled := []error{}
+
+	for _, account := range next.Accounts.Disabled() {
+		disabled = append(disabled, fmt.Errorf("%s is disabled until the community bot %s can be loaded", account.Handle, account.Bot))
+		slog.Warn("Disabled account, as its community bot couldn't be loaded", "account", account.Handle, "bot", account.Bot)
+	}
+
+	health.Report("community-accounts", errors.Join(disabled...))
+
+	if previous := BOTS.Load(); previous != nil {
+
+		next.Accounts.Inherit(previous.Accounts)
+
+		// Removed accounts no longer count towards readiness
+		for _, account := range previous.Accounts.All() {
+			if !current[account.Handle] {
+				health.Unregister("bluesky:" + account.Handle)
+			}
+		}
+
+	}
+
+	BOTS.Store(next)
+
+}
+
+// reloadBots reads the configuration and community bots again, and swaps them
+// in if they're valid. If they aren't, the running registry is left alone and
+// the error is returned. It also returns any changed settings that won't take
+// effect until the bot is restarted.
+func reloadBots(trigger string) ([]string, error) {
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	logger := slog.With("trigger", trigger)
+	logger.Info("Reloading configuration and community bots")
+
+	restartRequired, err := loadBotRegistry()
+
+	health.Report("reload", err)
+
+	if err != nil {
+		metrics.Reloads.WithLabelValues(trigger, "rejected").Inc()
+		logger.Error("Rejected reload. The previous configuration and community bots are still active.", "error", err)
+		return nil, err
+	}
+
+	metrics.Reloads.WithLabelValues(trigger, "applied").Inc()
+
+	if len(restartRequired) > 0 {
+		logger.Warn("Some changed settings only take effect after a restart", "settings", restartRequired)
+	}
+
+	logger.Info("Reloaded configuration and community bots", "community_bots", communityBotNames(BOTS.Load().CommunityBots))
+
+	return restartRequired, nil
+
+}
+
+func loadBotRegistry() ([]string, error) {
+
+	cfg, configErr := config.Load()
+	if configErr != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", configErr)
+	}
+
+	next, buildErr := buildBotRegistry(cfg, BOTS.Load())
+	if buildErr != nil {
+		return nil, buildErr
+	}
+
+	for _, secret := range cfg.Secrets() {
+		logging.AddSecret(secret)
+	}
+
+	previous := BOTS.Load().Config
+
+	swapBotRegistry(next)
+
+	return previous.RestartRequired(cfg), nil
+
+}
+
+// buildBotRegistry loads the community bots, accounts and secrets that cfg
+// describes, the same way at startup and on every reload. Community bots
+// that are invalid are reported, and their accounts disabled, unless previous
+// has a valid definition of them. That's kept instead, so a bad edit doesn't
+// take a working bot offline. previous is nil at startup.
+func buildBotRegistry(cfg config.Config, previous *BotRegistry) (*BotRegistry, error) {
+
+	communityBots, brokenBots, loadErr := loadCommunityBotDetails(cfg)
+
+	if previous != nil && loadErr != nil {
+
+		stillBroken := []string{}
+		kept := []string{}
+
+		for _, name := range brokenBots {
+
+			index := slices.IndexFunc(previous.CommunityBots, func(bot botspec.Bot) bool { return bot.Name == name })
+
+			if index < 0 {
+				stillBroken = append(stillBroken, name)
+				continue
+			}
+
+			communityBots = append(communityBots, previous.CommunityBots[index])
+			kept = append(kept, name)
+
+		}
+
+		if len(kept) > 0 {
+			slog.Warn("Kept the previous definition of community bots that couldn't be reloaded", "bots", kept, "error", loadErr)
+		}
+
+		sort.Slice(communityBots, func(i, j int) bool {
+			return communityBots[i].Name < communityBots[j].Name
+		})
+
+		brokenBots = stillBroken
+
+	}
+
+	registry, registryErr := accounts.New(cfg, communityBotNames(communityBots), brokenBots)
+	if registryErr != nil {
+		return nil, fmt.Errorf("invalid account configuration: %w", registryErr)
+	}
+
+	communitySecrets, secretsErr := loadCommunitySecrets(cfg, communityBots)
+	if secretsErr != nil {
+		return nil, fmt.Errorf("invalid community bot secrets: %w", secretsErr)
+	}
+
+	return &BotRegistry{
+		Accounts: registry,
+		CommunityBots: communityBots,
+		Secrets: communitySecrets,
+		Config: cfg,
+	}, nil
+
+}
+
+// watchedFiles describes the config file and everything in ./community, so
+// that any change to them changes the description.
+func watchedFiles() string {
+
+	var description strings.Builder
+
+	describe := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&description, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	configPath, _ := config.Path()
+	describe(configPath)
+
+	// Secrets can be rotated without touching anything else. The file is the
+	// one the running bots were loaded with, so moving it is noticed too
+	if secretsFile := BOTS.Load().Config.Community.SecretsFile; secretsFile != "" {
+		describe(secretsFile)
+	}
+
+	filepath.WalkDir("./community", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil {
+			describe(path)
+		}
+		return nil
+	})
+
+	return description.String()
+
+}
+
+// watchForChanges reloads whenever the config file or ./community changes.
+func watchForChanges() {
+
+	lastSeen := watchedFiles()
+
+	for {
+
+		time.Sleep(RELOAD_WATCH_INTERVAL)
+
+		current := watchedFiles()
+
+		if current == lastSeen {
+			continue
+		}
+
+		lastSeen = current
+		reloadBots("watch")
+
+	}
+
+}
+
+// reloadOnSignal reloads whenever the process receives a SIGHUP.
+func reloadOnSignal() {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		reloadBots("signal")
+	}
+
+}
+
+// requireAdminToken only lets requests through if they carry the admin token.
+func requireAdminToken(c *fiber.Ctx) error {
+
+	token, hasToken := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+
+	if !hasToken || subtle.ConstantTimeCompare([]byte(token), []byte(CONFIG.Server.AdminToken)) != 1 {
+		return sendAPIError(c, fiber.StatusUnauthorized, "A valid admin token is required")
+	}
+
+	return c.Next()
+
+}
+
+func handleReloadRequest(c *fiber.Ctx) error {
+
+	restartRequired, err := reloadBots("admin")
+
+	if err != nil {
+		return sendAPIError(c, fiber.StatusUnprocessableEntity, err.Error())
+	}
+
+	registry := BOTS.Load()
+
+	accountsLoaded := []fiber.Map{}
+	for _, account := range registry.Accounts.All() {
+		accountsLoaded = append(accountsLoaded, fiber.Map{
+			"handle" : account.Handle,
+			"behaviour" : account.Behaviour,
+			"bot" : account.Bot,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"reloaded" : true,
+		"accounts" : accountsLoaded,
+		"communityBots" : communityBotNames(registry.CommunityBots),
+		"restartRequired" : restartRequired,
+	})
+
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bbb/config"
+)
+
+const testInfoFile = `{
+	"name": "calculator",
+	"storage": false,
+	"environmentVariables": ["POST"],
+	"type": "mention",
+	"repo": "https://github.com/example/calculator",
+	"author": "@example.bsky.social"
+}`
+
+// writeCommunityBot writes a bot's files to ./community, where the bot loads them from.
+func writeCommunityBot(t *testing.T, name, info, job string) {
+
+	dir := filepath.Join("community", name)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "info.json"), []byte(info), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "job.yaml"), []byte(job), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestBuildBotRegistryKeepsBotsThatBreak(t *testing.T) {
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(workingDir) })
+
+	cfg := config.Default()
+	cfg.Bluesky.Accounts = []config.Account{
+		{Username: "calculator.bots.example.com", Password: "password", Behaviour: config.BehaviourCommunity, Bot: "calculator"},
+		{Username: "poet.bots.example.com", Password: "password", Behaviour: config.BehaviourCommunity, Bot: "poet"},
+	}
+
+	brokenJob := strings.Replace(testJobFile, "Type: batch", "Type: service", 1)
+
+	writeCommunityBot(t, "calculator", testInfoFile, testJobFile)
+	writeCommunityBot(t, "poet", strings.Replace(testInfoFile, "calculator", "poet", 1), brokenJob)
+
+	previous, err := buildBotRegistry(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := communityBotNames(previous.CommunityBots); len(names) != 1 || names[0] != "calculator" {
+		t.Fatalf("expected only calculator to be loaded, got %v", names)
+	}
+
+	// calculator is edited into a bot that can't be loaded
+	writeCommunityBot(t, "calculator", testInfoFile, brokenJob)
+
+	next, err := buildBotRegistry(cfg, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(next.CommunityBots) != 1 || next.CommunityBots[0].Name != "calculator" || next.CommunityBots[0].JobFile != testJobFile {
+		t.Fatalf("expected the previous calculator to be kept, got %+v", next.CommunityBots)
+	}
+
+	if _, found := next.Accounts.ForBot("calculator"); !found {
+		t.Error("calculator's account was disabled")
+	}
+
+	// poet was never valid, so there's nothing to keep
+	disabled := next.Accounts.Disabled()
+	if len(disabled) != 1 || disabled[0].Bot != "poet" {
+		t.Errorf("expected only poet's account to be disabled, got %+v", disabled)
+	}
+
+}
@@ -0,0 +1,209 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bbb/bacalhau"
+	"bbb/storage"
+)
+
+type Type string
+
+const (
+	TypeAltText        Type = "alt-text"
+	TypeJobRun         Type = "job-run"
+	TypeClassification Type = "classification"
+	TypeCommunity      Type = "community"
+)
+
+// KeyPrefix is where records and their files are kept in the storage backend.
+const KeyPrefix = "results/"
+
+type Label struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Record is the shared format for the output of every command the bot runs.
+// Fields that don't apply to a command type are left empty.
+type Record struct {
+	ID        string    `json:"id"`
+	Type      Type      `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// The post that invoked the command
+	PostURI    string `json:"postUri,omitempty"`
+	PostAuthor string `json:"postAuthor,omitempty"`
+
+	JobID       string        `json:"jobId,omitempty"`
+	ExecutionID string        `json:"executionId,omitempty"`
+	State       string        `json:"state,omitempty"`
+	Duration    time.Duration `json:"-"`
+	Stdout      string        `json:"stdout,omitempty"`
+	Stderr      string        `json:"stderr,omitempty"`
+
+	// Alt-text
+	ImageURL string `json:"imageUrl,omitempty"`
+	AltText  string `json:"altText,omitempty"`
+	OCRText  string `json:"ocrText,omitempty"`
+
+	// Classification
+	Model    string  `json:"model,omitempty"`
+	Labels   []Label `json:"labels,omitempty"`
+	HasImage bool    `json:"hasImage,omitempty"`
+
+	// Community bots
+	BotName    string `json:"botName,omitempty"`
+	BotTrigger string `json:"botTrigger,omitempty"`
+	BotError   string `json:"botError,omitempty"`
+}
+
+// recordJSON is how a Record is stored and served. Durations are given in
+// whole milliseconds as durationMs. Records saved before that have the
+// duration in nanoseconds, which are still read.
+type recordJSON struct {
+	record
+	DurationMs     int64 `json:"durationMs,omitempty"`
+	LegacyDuration int64 `json:"duration,omitempty"`
+}
+
+// record has the same fields as Record, without its JSON methods.
+type record Record
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		record
+		DurationMs int64 `json:"durationMs,omitempty"`
+	}{record(r), r.Duration.Milliseconds()})
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+
+	var decoded recordJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*r = Record(decoded.record)
+
+	switch {
+	case decoded.DurationMs > 0:
+		r.Duration = time.Duration(decoded.DurationMs) * time.Millisecond
+	case decoded.LegacyDuration > 0:
+		r.Duration = time.Duration(decoded.LegacyDuration)
+	}
+
+	return nil
+
+}
+
+// ApplyJobResult copies the details of a Bacalhau execution onto the record.
+func (r *Record) ApplyJobResult(result bacalhau.JobExecutionResult) {
+	r.JobID = result.JobID
+	r.ExecutionID = result.ExecutionID
+	r.State = result.State
+	r.Duration = result.Duration
+	r.Stdout = result.Stdout
+	r.Stderr = result.Stderr
+}
+
+type Store struct {
+	storage storage.Storage
+}
+
+func NewStore(backend storage.Storage) *Store {
+	return &Store{storage: backend}
+}
+
+func recordKey(id string) string {
+	return fmt.Sprintf("%s%s.json", KeyPrefix, id)
+}
+
+func jobIndexKey(jobID string) string {
+	return fmt.Sprintf("%sjobs/%s.json", KeyPrefix, jobID)
+}
+
+func imageKey(id string) string {
+	return fmt.Sprintf("%s%s.jpg", KeyPrefix, id)
+}
+
+func (s *Store) Save(record Record) error {
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not marshal result record: %w", err)
+	}
+
+	if err := s.storage.Put(recordKey(record.ID), content, "application/json"); err != nil {
+		return fmt.Errorf("could not store result record: %w", err)
+	}
+
+	// Index the record by its Bacalhau JobID so it can be found from either
+	if record.JobID != "" {
+
+		index, err := json.Marshal(map[string]string{"id": record.ID})
+		if err != nil {
+			return fmt.Errorf("could not marshal job index: %w", err)
+		}
+
+		if err := s.storage.Put(jobIndexKey(record.JobID), index, "application/json"); err != nil {
+			return fmt.Errorf("could not store job index: %w", err)
+		}
+
+	}
+
+	return nil
+
+}
+
+// Load returns storage.ErrNotFound if there's no record with that ID.
+func (s *Store) Load(id string) (Record, error) {
+
+	content, err := s.storage.Get(recordKey(id))
+	if err != nil {
+		return Record{}, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(content, &record); err != nil {
+		return Record{}, fmt.Errorf("could not parse result record: %w", err)
+	}
+
+	return record, nil
+
+}
+
+// LoadByJobID returns the record for a Bacalhau job that the bot created, or
+// storage.ErrNotFound if there isn't one.
+func (s *Store) LoadByJobID(jobID string) (Record, error) {
+
+	content, err := s.storage.Get(jobIndexKey(jobID))
+	if err != nil {
+		return Record{}, err
+	}
+
+	var index struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.Unmarshal(content, &index); err != nil {
+		return Record{}, fmt.Errorf("could not parse job index: %w", err)
+	}
+
+	return s.Load(index.ID)
+
+}
+
+func (s *Store) SaveImage(id string, image []byte) error {
+	return s.storage.Put(imageKey(id), image, "image/jpeg")
+}
+
+func (s *Store) LoadImage(id string) ([]byte, error) {
+	return s.storage.Get(imageKey(id))
+}
@@ -0,0 +1,46 @@
+package results
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordDurationJSON(t *testing.T) {
+
+	encoded, err := json.Marshal(Record{ID: "abc", Duration: 1500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(encoded), `"durationMs":1500`) || strings.Contains(string(encoded), `"duration":`) {
+		t.Errorf("unexpected JSON %s", encoded)
+	}
+
+	tests := []struct {
+		name string
+		json string
+		want time.Duration
+	}{
+		{"milliseconds", `{"id":"abc","durationMs":1500}`, 1500 * time.Millisecond},
+		{"nanoseconds from older records", `{"id":"abc","duration":1500000000}`, 1500 * time.Millisecond},
+		{"no duration", `{"id":"abc"}`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var record Record
+			if err := json.Unmarshal([]byte(test.json), &record); err != nil {
+				t.Fatal(err)
+			}
+
+			if record.ID != "abc" || record.Duration != test.want {
+				t.Errorf("got %+v, want a duration of %s", record, test.want)
+			}
+
+		})
+	}
+
+}
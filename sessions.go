@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"bbb/accounts"
+	"bbb/bsky"
+)
+
+// How long a Bluesky session is used before it's refreshed. Access tokens last
+// about two hours, so this leaves plenty of time for jobs that use one.
+const SESSION_REFRESH_AFTER = 30 * time.Minute
+
+// Every account's session, shared by polling, the firehose and schedules
+var BLUESKY_SESSIONS = newSessionCache()
+
+// sessionCache keeps one Bluesky session for each account. Signing in is rate
+// limited by Bluesky, so sessions are reused, and refreshed with their refresh
+// token rather than by signing in again.
+type sessionCache struct {
+	mutex sync.Mutex
+	accounts map[string]*cachedSession
+
+	// signIn and refresh talk to Bluesky, and are replaced in tests
+	signIn func(handle, password string) (*bsky.Session, error)
+	refresh func(refreshJwt string) (*bsky.Session, error)
+}
+
+// cachedSession is one account's session. Its mutex is held while signing in,
+// so the account only signs in once however many callers need it at once.
+type cachedSession struct {
+	mutex sync.Mutex
+	session *bsky.Session
+	password string
+	createdAt time.Time
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{accounts: map[string]*cachedSession{}, signIn: bsky.Authenticate, refresh: bsky.RefreshSession}
+}
+
+// get returns a session for account. It signs in if there isn't one yet, or
+// the account's password has changed, and refreshes the session once it's
+// older than SESSION_REFRESH_AFTER, signing in again if that fails.
+func (c *sessionCache) get(account accounts.Account, now time.Time) (*bsky.Session, error) {
+
+	c.mutex.Lock()
+	cached, found := c.accounts[account.Handle]
+	if !found {
+		cached = &cachedSession{}
+		c.accounts[account.Handle] = cached
+	}
+	c.mutex.Unlock()
+
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+
+	if cached.session != nil && cached.password == account.Password {
+
+		if now.Sub(cached.createdAt) < SESSION_REFRESH_AFTER {
+			return cached.session, nil
+		}
+
+		session, refreshErr := c.refresh(cached.session.RefreshJwt)
+
+		if refreshErr == nil {
+			cached.session, cached.createdAt = session, now
+			return session, nil
+		}
+
+		slog.Warn("Could not refresh Bluesky session, so signing in again", "account", account.Handle, "error", refreshErr)
+
+	}
+
+	session, err := c.signIn(account.Handle, account.Password)
+	if err != nil {
+		cached.session = nil
+		return nil, err
+	}
+
+	cached.session, cached.password, cached.createdAt = session, account.Password, now
+
+	return session, nil
+
+}
+
+// forget drops account's session, so the next caller signs in again. It's
+// used when Bluesky rejects the session.
+func (c *sessionCache) forget(handle string) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.accounts, handle)
+
+}
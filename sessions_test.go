@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"bbb/accounts"
+	"bbb/bsky"
+)
+
+func TestSessionCache(t *testing.T) {
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	account := accounts.Account{Handle: "calculator.bots.example.com", Password: "password"}
+
+	signIns, refreshes := 0, 0
+	refreshFails := false
+
+	cache := newSessionCache()
+
+	cache.signIn = func(handle, password string) (*bsky.Session, error) {
+		signIns++
+		return &bsky.Session{AccessJwt: fmt.Sprintf("access %d", signIns), RefreshJwt: fmt.Sprintf("refresh %d", signIns)}, nil
+	}
+
+	cache.refresh = func(refreshJwt string) (*bsky.Session, error) {
+		if refreshFails {
+			return nil, errors.New("expired")
+		}
+		refreshes++
+		return &bsky.Session{AccessJwt: "refreshed " + refreshJwt, RefreshJwt: refreshJwt}, nil
+	}
+
+	tests := []struct {
+		name string
+		after time.Duration
+		change func()
+		wantJwt string
+		wantSignIns int
+		wantRefreshes int
+	}{
+		{"first use signs in", 0, func() {}, "access 1", 1, 0},
+		{"the session is reused", time.Minute, func() {}, "access 1", 1, 0},
+		{"an old session is refreshed", SESSION_REFRESH_AFTER, func() {}, "refreshed refresh 1", 1, 1},
+		{"the refreshed session is reused", SESSION_REFRESH_AFTER + time.Minute, func() {}, "refreshed refresh 1", 1, 1},
+		{"a failed refresh signs in", 3 * SESSION_REFRESH_AFTER, func() { refreshFails = true }, "access 2", 2, 1},
+		{"a new password signs in", 3 * SESSION_REFRESH_AFTER, func() { account.Password = "new password" }, "access 3", 3, 1},
+		{"a forgotten session signs in", 3 * SESSION_REFRESH_AFTER, func() { cache.forget(account.Handle) }, "access 4", 4, 1},
+	}
+
+	for _, test := range tests {
+
+		test.change()
+
+		session, err := cache.get(account, start.Add(test.after))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if session.AccessJwt != test.wantJwt || signIns != test.wantSignIns || refreshes != test.wantRefreshes {
+			t.Errorf("%s: got %q after %d sign-ins and %d refreshes, want %q after %d and %d", test.name, session.AccessJwt, signIns, refreshes, test.wantJwt, test.wantSignIns, test.wantRefreshes)
+		}
+
+	}
+
+}
@@ -0,0 +1,247 @@
+package shortener
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"bbb/storage"
+)
+
+// Shortener turns long result links into links short enough for a post.
+type Shortener interface {
+	Shorten(targetURL string) (string, error)
+}
+
+// RoutePrefix is the path the HTTP server resolves built-in short codes on.
+const RoutePrefix = "/s"
+
+const codeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const codeLength = 7
+
+var ErrExpired = errors.New("short link has expired")
+
+type Link struct {
+	Code      string    `json:"code"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Clicks    int       `json:"clicks"`
+}
+
+// Builtin keeps short codes in our own storage backend, and resolves them
+// through the bot's HTTP server.
+type Builtin struct {
+	store   storage.Storage
+	baseURL string
+	expiry  time.Duration
+	mu      sync.Mutex
+}
+
+func NewBuiltin(store storage.Storage, baseURL string, expiry time.Duration) *Builtin {
+	return &Builtin{
+		store:   store,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		expiry:  expiry,
+	}
+}
+
+func linkKey(code string) string {
+	return fmt.Sprintf("shortlinks/%s.json", code)
+}
+
+func generateCode() (string, error) {
+
+	code := make([]byte, codeLength)
+
+	for i := range code {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeAlphabet[idx.Int64()]
+	}
+
+	return string(code), nil
+
+}
+
+func (b *Builtin) Shorten(targetURL string) (string, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var code string
+
+	// Codes are random, so retry on the (unlikely) chance of a collision
+	for attempt := 0; attempt < 5; attempt++ {
+
+		candidate, err := generateCode()
+		if err != nil {
+			return "", fmt.Errorf("could not generate short code: %w", err)
+		}
+
+		_, err = b.store.Stat(linkKey(candidate))
+
+		if errors.Is(err, storage.ErrNotFound) {
+			code = candidate
+			break
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("could not check short code: %w", err)
+		}
+
+	}
+
+	if code == "" {
+		return "", errors.New("could not find an unused short code")
+	}
+
+	now := time.Now()
+
+	link := Link{
+		Code:      code,
+		URL:       targetURL,
+		CreatedAt: now,
+		ExpiresAt: now.Add(b.expiry),
+	}
+
+	if err := b.save(link); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s/%s", b.baseURL, RoutePrefix, code), nil
+
+}
+
+// Resolve looks up a short code. Expired links are removed and reported with
+// ErrExpired. Clicks are counted in the background, so redirects don't wait
+// for each other.
+func (b *Builtin) Resolve(code string) (Link, error) {
+
+	link, err := b.load(code)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if time.Now().After(link.ExpiresAt) {
+		b.store.Delete(linkKey(code))
+		return Link{}, ErrExpired
+	}
+
+	go b.countClick(code)
+
+	return link, nil
+
+}
+
+// countClick adds one to the link's clicks. Clicks are only a rough count,
+// so a link that can't be updated is left as it is.
+func (b *Builtin) countClick(code string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	link, err := b.load(code)
+	if err != nil {
+		return
+	}
+
+	link.Clicks++
+
+	b.save(link)
+
+}
+
+// RemoveExpired deletes every link that has expired, and returns how many
+// were deleted.
+func (b *Builtin) RemoveExpired() (int, error) {
+
+	objects, err := b.store.List("shortlinks/")
+	if err != nil {
+		return 0, fmt.Errorf("could not list short links: %w", err)
+	}
+
+	removed := 0
+	errs := []error{}
+
+	for _, object := range objects {
+
+		code := strings.TrimSuffix(strings.TrimPrefix(object.Key, "shortlinks/"), ".json")
+
+		link, loadErr := b.load(code)
+		if loadErr != nil || !time.Now().After(link.ExpiresAt) {
+			continue
+		}
+
+		if deleteErr := b.store.Delete(object.Key); deleteErr != nil {
+			errs = append(errs, deleteErr)
+			continue
+		}
+
+		removed++
+
+	}
+
+	return removed, errors.Join(errs...)
+
+}
+
+func (b *Builtin) load(code string) (Link, error) {
+
+	content, err := b.store.Get(linkKey(code))
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	if err := json.Unmarshal(content, &link); err != nil {
+		return Link{}, fmt.Errorf("could not parse short link: %w", err)
+	}
+
+	return link, nil
+
+}
+
+func (b *Builtin) save(link Link) error {
+
+	content, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("could not marshal short link: %w", err)
+	}
+
+	if err := b.store.Put(linkKey(link.Code), content, "application/json"); err != nil {
+		return fmt.Errorf("could not store short link: %w", err)
+	}
+
+	return nil
+
+}
+
+// Fallback tries each Shortener in turn until one of them succeeds.
+type Fallback []Shortener
+
+func (f Fallback) Shorten(targetURL string) (string, error) {
+
+	errs := []error{}
+
+	for _, s := range f {
+
+		shortURL, err := s.Shorten(targetURL)
+		if err == nil {
+			return shortURL, nil
+		}
+
+		errs = append(errs, err)
+
+	}
+
+	return "", errors.Join(errs...)
+
+}
@@ -0,0 +1,99 @@
+package shortener
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bbb/storage"
+)
+
+func newTestShortener(t *testing.T, expiry time.Duration) (*Builtin, storage.Storage) {
+
+	store, err := storage.NewLocal(storage.LocalOptions{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewBuiltin(store, "https://bots.example.com/", expiry), store
+
+}
+
+func TestShortenAndResolve(t *testing.T) {
+
+	shortener, _ := newTestShortener(t, time.Hour)
+
+	shortURL, err := shortener.Shorten("https://bots.example.com/results/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, found := strings.CutPrefix(shortURL, "https://bots.example.com/s/")
+	if !found || len(code) != codeLength {
+		t.Fatalf("unexpected short URL %s", shortURL)
+	}
+
+	link, err := shortener.Resolve(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link.URL != "https://bots.example.com/results/abc" {
+		t.Errorf("resolved to %s", link.URL)
+	}
+
+	// Clicks are counted in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counted, _ := shortener.load(code)
+		if counted.Clicks == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("click wasn't counted: %+v", counted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := shortener.Resolve("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown code, got %v", err)
+	}
+
+}
+
+func TestExpiredLinks(t *testing.T) {
+
+	shortener, store := newTestShortener(t, -time.Minute)
+
+	expiredURL, err := shortener.Shorten("https://bots.example.com/results/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shortener.expiry = time.Hour
+
+	if _, err := shortener.Shorten("https://bots.example.com/results/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := shortener.RemoveExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Errorf("removed %d links, want 1", removed)
+	}
+
+	remaining, _ := store.List("shortlinks/")
+	if len(remaining) != 1 {
+		t.Errorf("%d links remain, want 1", len(remaining))
+	}
+
+	code := strings.TrimPrefix(expiredURL, "https://bots.example.com/s/")
+	if _, err := shortener.Resolve(code); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the expired link to be gone, got %v", err)
+	}
+
+}
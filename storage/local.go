@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalRoutePrefix is the path the HTTP server serves local objects from.
+const LocalRoutePrefix = "/storage"
+
+type LocalOptions struct {
+	Directory string
+
+	// BaseURL is the public origin of the bot's HTTP server, used to build
+	// links to stored objects.
+	BaseURL string
+
+	// SigningKey signs presigned URLs. A random key is generated if it's
+	// empty, which means presigned links won't survive a restart.
+	SigningKey string
+}
+
+type Local struct {
+	directory string
+	baseURL   string
+	signer    *Signer
+}
+
+// NewLocal creates a backend that keeps objects in a directory on disk, which
+// the bot's HTTP server then serves under LocalRoutePrefix.
+func NewLocal(options LocalOptions) (*Local, error) {
+
+	directory := options.Directory
+	if directory == "" {
+		directory = "./data"
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("could not create local storage directory: %w", err)
+	}
+
+	signer, err := NewSigner(options.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		directory: directory,
+		baseURL:   strings.TrimSuffix(options.BaseURL, "/"),
+		signer:    signer,
+	}, nil
+
+}
+
+func (l *Local) pathFor(key string) (string, error) {
+
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.directory, filepath.FromSlash(key)), nil
+
+}
+
+func (l *Local) Put(key string, content []byte, contentType string) error {
+
+	path, err := l.pathFor(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create directory for object: %w", err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("could not write object to local storage: %w", err)
+	}
+
+	return nil
+
+}
+
+func (l *Local) Get(key string) ([]byte, error) {
+
+	path, err := l.pathFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not read object from local storage: %w", err)
+	}
+
+	return content, nil
+
+}
+
+func (l *Local) Delete(key string) error {
+
+	path, err := l.pathFor(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not delete object from local storage: %w", err)
+	}
+
+	return nil
+
+}
+
+func (l *Local) List(prefix string) ([]Object, error) {
+
+	objects := []Object{}
+
+	walkErr := filepath.WalkDir(l.directory, func(path string, entry fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(l.directory, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, Object{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+
+		return nil
+
+	})
+
+	if walkErr != nil {
+		return nil, fmt.Errorf("could not list local storage: %w", walkErr)
+	}
+
+	return objects, nil
+
+}
+
+func (l *Local) PublicURL(key string) string {
+	return fmt.Sprintf("%s%s/%s", l.baseURL, LocalRoutePrefix, key)
+}
+
+func (l *Local) Stat(key string) (Object, error) {
+
+	path, err := l.pathFor(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Object{}, ErrNotFound
+		}
+		return Object{}, fmt.Errorf("could not stat object in local storage: %w", err)
+	}
+
+	return Object{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+
+}
+
+func (l *Local) PresignedURL(key string, expiry time.Duration) (string, error) {
+
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	query := l.signer.Sign(key, time.Now().Add(expiry))
+
+	return fmt.Sprintf("%s?%s", l.PublicURL(key), query.Encode()), nil
+
+}
+
+// Upload links are signed over a different path to download links, so a link
+// to read an object can't be used to overwrite it.
+func uploadSigningPath(key string) string {
+	return "upload:" + key
+}
+
+func (l *Local) PresignedUploadURL(key string, contentType string, expiry time.Duration) (string, error) {
+
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	query := l.signer.Sign(uploadSigningPath(key), time.Now().Add(expiry))
+
+	return fmt.Sprintf("%s?%s", l.PublicURL(key), query.Encode()), nil
+
+}
+
+// VerifySignature checks the query parameters of a URL made by PresignedURL.
+func (l *Local) VerifySignature(key, expires, signature string) bool {
+	valid, expired := l.signer.Verify(key, expires, signature)
+	return valid && !expired
+}
+
+// VerifyUploadSignature checks the query parameters of a URL made by PresignedUploadURL.
+func (l *Local) VerifyUploadSignature(key, expires, signature string) bool {
+	valid, expired := l.signer.Verify(uploadSigningPath(key), expires, signature)
+	return valid && !expired
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) *Local {
+
+	local, err := NewLocal(LocalOptions{Directory: t.TempDir(), BaseURL: "https://bots.example.com/", SigningKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return local
+
+}
+
+func TestLocalKeys(t *testing.T) {
+
+	local := newTestLocal(t)
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"nested", "results/abc.json", false},
+		{"dots in a name", "results/abc..json", false},
+		{"empty", "", true},
+		{"parent directory", "../secrets.yaml", true},
+		{"parent directory in the middle", "results/../../secrets.yaml", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := local.Put(test.key, []byte("{}"), "application/json")
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want an error: %v", err, test.wantErr)
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			content, err := local.Get(test.key)
+			if err != nil || string(content) != "{}" {
+				t.Errorf("read back %q, %v", content, err)
+			}
+
+		})
+	}
+
+	if _, err := local.Get("results/missing.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing object, got %v", err)
+	}
+
+}
+
+func TestLocalPresignedURLs(t *testing.T) {
+
+	local := newTestLocal(t)
+
+	signedQuery := func(signed string) url.Values {
+		parsed, err := url.Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(signed, "https://bots.example.com"+LocalRoutePrefix+"/results/abc.json?") {
+			t.Errorf("unexpected URL %s", signed)
+		}
+		return parsed.Query()
+	}
+
+	download, err := local.PresignedURL("results/abc.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := local.PresignedUploadURL("results/abc.json", "application/json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloadQuery, uploadQuery := signedQuery(download), signedQuery(upload)
+
+	if !local.VerifySignature("results/abc.json", downloadQuery.Get("expires"), downloadQuery.Get("signature")) {
+		t.Error("download link wasn't valid")
+	}
+
+	if !local.VerifyUploadSignature("results/abc.json", uploadQuery.Get("expires"), uploadQuery.Get("signature")) {
+		t.Error("upload link wasn't valid")
+	}
+
+	// A link to read an object can't be used to overwrite it, or the other way round
+	if local.VerifyUploadSignature("results/abc.json", downloadQuery.Get("expires"), downloadQuery.Get("signature")) {
+		t.Error("download link was accepted for an upload")
+	}
+
+	if local.VerifySignature("results/abc.json", uploadQuery.Get("expires"), uploadQuery.Get("signature")) {
+		t.Error("upload link was accepted for a download")
+	}
+
+	expired, err := local.PresignedURL("results/abc.json", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiredQuery := signedQuery(expired)
+	if local.VerifySignature("results/abc.json", expiredQuery.Get("expires"), expiredQuery.Get("signature")) {
+		t.Error("expired link was accepted")
+	}
+
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3Options struct {
+	Bucket string
+	Region string
+
+	// Endpoint and UsePathStyle are only needed for S3-compatible services
+	// such as MinIO. Leave them empty for AWS.
+	Endpoint     string
+	UsePathStyle bool
+
+	// PublicBaseURL overrides the origin used by PublicURL, e.g. for a CDN
+	// sitting in front of the bucket.
+	PublicBaseURL string
+}
+
+type S3 struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	options S3Options
+}
+
+// NewS3 creates a backend for AWS S3 or any S3-compatible service. Credentials
+// are loaded from environment variables or the IAM role.
+func NewS3(options S3Options) (*S3, error) {
+
+	if options.Bucket == "" {
+		return nil, errors.New("no bucket has been set for S3 storage")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(options.Region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS SDK config: %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if options.Endpoint != "" {
+			o.BaseEndpoint = aws.String(options.Endpoint)
+		}
+		o.UsePathStyle = options.UsePathStyle
+	})
+
+	return &S3{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		options: options,
+	}, nil
+
+}
+
+func (s *S3) Put(key string, content []byte, contentType string) error {
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.options.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(contentType),
+	}
+
+	if _, err := s.client.PutObject(context.TODO(), input); err != nil {
+		return fmt.Errorf("failed to upload file to S3: %v", err)
+	}
+
+	return nil
+
+}
+
+func (s *S3) Get(key string) ([]byte, error) {
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := s.client.GetObject(context.TODO(), input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve object from S3: %v", err)
+	}
+	defer result.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(result.Body); err != nil {
+		return nil, fmt.Errorf("failed to read object data: %v", err)
+	}
+
+	return buf.Bytes(), nil
+
+}
+
+func (s *S3) Delete(key string) error {
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+
+	if _, err := s.client.DeleteObject(context.TODO(), input); err != nil {
+		return fmt.Errorf("failed to delete object from S3: %v", err)
+	}
+
+	return nil
+
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+
+	objects := []Object{}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.options.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3: %v", err)
+		}
+
+		for _, item := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.ToString(item.Key),
+				Size:         aws.ToInt64(item.Size),
+				LastModified: aws.ToTime(item.LastModified),
+			})
+		}
+
+	}
+
+	return objects, nil
+
+}
+
+func (s *S3) Stat(key string) (Object, error) {
+
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := s.client.HeadObject(context.TODO(), input)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return Object{}, ErrNotFound
+		}
+		return Object{}, fmt.Errorf("failed to stat object in S3: %v", err)
+	}
+
+	return Object{
+		Key:          key,
+		Size:         aws.ToInt64(result.ContentLength),
+		LastModified: aws.ToTime(result.LastModified),
+	}, nil
+
+}
+
+func (s *S3) PublicURL(key string) string {
+
+	if s.options.PublicBaseURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(s.options.PublicBaseURL, "/"), key)
+	}
+
+	if s.options.Endpoint != "" {
+		if s.options.UsePathStyle {
+			return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.options.Endpoint, "/"), s.options.Bucket, key)
+		}
+		return fmt.Sprintf("%s/%s", strings.Replace(strings.TrimSuffix(s.options.Endpoint, "/"), "://", fmt.Sprintf("://%s.", s.options.Bucket), 1), key)
+	}
+
+	if s.options.Region != "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.options.Bucket, s.options.Region, key)
+	}
+
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.options.Bucket, key)
+
+}
+
+func (s *S3) PresignedURL(key string, expiry time.Duration) (string, error) {
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+
+	request, err := s.presign.PresignGetObject(context.TODO(), input, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 URL: %v", err)
+	}
+
+	return request.URL, nil
+
+}
+
+func (s *S3) PresignedUploadURL(key string, contentType string, expiry time.Duration) (string, error) {
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.options.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+
+	request, err := s.presign.PresignPutObject(context.TODO(), input, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 upload URL: %v", err)
+	}
+
+	return request.URL, nil
+
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+
+	signer, err := NewSigner("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewSigner("other key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key after a restart
+	restarted, err := NewSigner("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	future := signer.Sign("results/abc.json", time.Now().Add(time.Hour))
+	past := signer.Sign("results/abc.json", time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name        string
+		path        string
+		query       url.Values
+		signer      *Signer
+		wantValid   bool
+		wantExpired bool
+	}{
+		{"genuine", "results/abc.json", future, signer, true, false},
+		{"expired", "results/abc.json", past, signer, true, true},
+		{"another path", "results/def.json", future, signer, false, false},
+		{"another key", "results/abc.json", future, other, false, false},
+		{"same key", "results/abc.json", future, restarted, true, false},
+		{"extended expiry", "results/abc.json", url.Values{
+			"expires":   {strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)},
+			"signature": {past.Get("signature")},
+		}, signer, false, false},
+		{"invalid expiry", "results/abc.json", url.Values{"expires": {"soon"}, "signature": {future.Get("signature")}}, signer, false, false},
+		{"missing signature", "results/abc.json", url.Values{"expires": {future.Get("expires")}}, signer, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			valid, expired := test.signer.Verify(test.path, test.query.Get("expires"), test.query.Get("signature"))
+
+			if valid != test.wantValid || expired != test.wantExpired {
+				t.Errorf("got valid %v and expired %v, want %v and %v", valid, expired, test.wantValid, test.wantExpired)
+			}
+
+		})
+	}
+
+}
+
+func TestGeneratedSigningKeys(t *testing.T) {
+
+	first, err := NewSigner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewSigner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := first.Sign("results/abc.json", time.Now().Add(time.Hour))
+
+	if valid, _ := first.Verify("results/abc.json", query.Get("expires"), query.Get("signature")); !valid {
+		t.Error("a generated key didn't verify its own signature")
+	}
+
+	if valid, _ := second.Verify("results/abc.json", query.Get("expires"), query.Get("signature")); valid {
+		t.Error("two generated keys were the same")
+	}
+
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Object describes a stored object returned by List.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Storage is implemented by every object storage backend the bot can write
+// results to.
+type Storage interface {
+	Put(key string, content []byte, contentType string) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	List(prefix string) ([]Object, error)
+	Stat(key string) (Object, error)
+	PublicURL(key string) string
+	PresignedURL(key string, expiry time.Duration) (string, error)
+
+	// PresignedUploadURL returns a URL that anyone holding it can PUT a
+	// single object to until it expires. Jobs are given these rather than
+	// credentials for the whole bucket.
+	PresignedUploadURL(key string, contentType string, expiry time.Duration) (string, error)
+}
+
+var ErrNotFound = errors.New("object not found")
+
+// Config selects and configures a storage backend.
+type Config struct {
+	// Backend is "s3", "s3-compatible" or "local"
+	Backend string
+
+	Bucket       string
+	Region       string
+	Endpoint     string
+	UsePathStyle bool
+	PublicURL    string
+
+	LocalDirectory string
+
+	// BaseURL is the public origin of the bot's HTTP server
+	BaseURL    string
+	SigningKey string
+}
+
+// New creates the backend selected by config.Backend. Supported values are
+// "s3" (the default), "s3-compatible" and "local".
+func New(config Config) (Storage, error) {
+
+	backend := config.Backend
+
+	if backend == "" {
+		backend = "s3"
+	}
+
+	switch backend {
+	case "s3":
+		return NewS3(S3Options{
+			Bucket: config.Bucket,
+			Region: config.Region,
+		})
+	case "s3-compatible":
+		return NewS3(S3Options{
+			Bucket:        config.Bucket,
+			Region:        config.Region,
+			Endpoint:      config.Endpoint,
+			UsePathStyle:  config.UsePathStyle,
+			PublicBaseURL: config.PublicURL,
+		})
+	case "local":
+		return NewLocal(LocalOptions{
+			Directory:  config.LocalDirectory,
+			BaseURL:    config.BaseURL,
+			SigningKey: config.SigningKey,
+		})
+	default:
+		return nil, fmt.Errorf(`unknown storage backend "%s". Expected one of: s3, s3-compatible, local`, backend)
+	}
+
+}
+
+// validateKey stops keys from escaping the bucket (or directory) they're written to.
+func validateKey(key string) error {
+
+	if key == "" {
+		return errors.New("object key cannot be empty")
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return fmt.Errorf(`object key "%s" cannot contain ".."`, key)
+		}
+	}
+
+	return nil
+
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "bacalhau-bluesky-bot"
+
+var tracer = otel.Tracer("bbb")
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider. exporterName chooses the
+// exporter: "none" (the default), "stdout" for local use, or "otlp", which
+// sends spans over HTTP to OTEL_EXPORTER_OTLP_ENDPOINT. The returned function
+// flushes any buffered spans and should be called before the process exits.
+func Setup(exporterName string) (func(context.Context) error, error) {
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch strings.ToLower(exporterName) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf(`unknown trace exporter "%s". Expected one of: none, stdout, otlp`, exporterName)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace exporter: %w", err)
+	}
+
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return provider.Shutdown, nil
+
+}
+
+// Start begins a span as a child of any span already in ctx.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartChild begins a span only if ctx already carries one, so that work done
+// outside of a traced operation, like health checks, doesn't start new traces.
+func StartChild(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	return Start(ctx, name, attributes...)
+
+}
+
+// InjectHeaders adds the trace context in ctx to outgoing HTTP request headers.
+func InjectHeaders(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// StartMention begins the root span for everything the bot does in response
+// to a single mention. It's linked to the span for the poll that found it.
+func StartMention(ctx context.Context, notificationURI string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "mention",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("bluesky.notification.uri", notificationURI)),
+	)
+}
+
+// StartTrigger begins the root span for everything a community bot does when
+// it's invoked by something other than a notification, like a direct message
+// or its schedule. It's linked to the span in ctx, if there is one.
+func StartTrigger(ctx context.Context, trigger, id string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, trigger,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("bbb.trigger", trigger), attribute.String("bbb.trigger.id", id)),
+	)
+}
+
+// RecordError marks the span as failed with err, if there was one.
+func RecordError(span trace.Span, err error) {
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+}
+
+// End records err on the span, if there was one, and ends it.
+func End(span trace.Span, err error) {
+	RecordError(span, err)
+	span.End()
+}
+
+// TraceID returns the ID of the trace in ctx, or an empty string if there isn't one.
+func TraceID(ctx context.Context) string {
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+
+}
+
+// JobLabels returns the trace context in ctx as Bacalhau job labels, so jobs
+// can be matched up with traces on the orchestrator's side.
+func JobLabels(ctx context.Context) map[string]string {
+
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	labels := map[string]string{}
+
+	if traceparent := carrier.Get("traceparent"); traceparent != "" {
+		labels["traceparent"] = traceparent
+		labels["trace-id"] = TraceID(ctx)
+	}
+
+	return labels
+
+}
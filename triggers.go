@@ -0,0 +1,709 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"bbb/accounts"
+	"bbb/botspec"
+	"bbb/bsky"
+	"bbb/config"
+	"bbb/firehose"
+	"bbb/health"
+	"bbb/metrics"
+	"bbb/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How long to wait before reconnecting to the firehose, or checking whether
+// any bot needs it
+const FIREHOSE_RETRY_INTERVAL = 30 * time.Second
+
+// The most unread messages read from each conversation on each poll
+const MAX_MESSAGES_PER_CONVO = 10
+
+// communityEvent is something that invoked a community bot.
+type communityEvent struct {
+	Trigger botspec.Trigger
+
+	// Notif is the post that invoked the bot, for post triggers, or the follow
+	Notif bsky.Notification
+
+	// Keywords are the bot's keywords in the post, for keyword triggers
+	Keywords []string
+
+	// ConvoID, Message and Sender are the direct message, for dm triggers
+	ConvoID string
+	Message bsky.ChatMessage
+	Sender bsky.Author
+
+	// ScheduledAt is when a schedule trigger was due
+	ScheduledAt time.Time
+}
+
+// notificationTriggers are the triggers for each reason Bluesky gives for a
+// notification.
+var notificationTriggers = map[string]string{
+	"mention": botspec.TriggerMention,
+	"reply": botspec.TriggerReply,
+	"quote": botspec.TriggerQuote,
+	"follow": botspec.TriggerFollow,
+}
+
+// recentKeys remembers keys for a while, and forgets them after that, so it
+// doesn't grow for as long as the bot runs.
+type recentKeys struct {
+	mutex sync.Mutex
+	expiry time.Duration
+	added map[string]time.Time
+	pruned time.Time
+}
+
+func newRecentKeys(expiry time.Duration) *recentKeys {
+	return &recentKeys{expiry: expiry, added: map[string]time.Time{}}
+}
+
+// add remembers key, unless it was already added in the last expiry, and
+// returns whether it did.
+func (r *recentKeys) add(key string, now time.Time) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Forgetting keys as they expire would mean a timer for each of them
+	if now.Sub(r.pruned) >= time.Minute {
+		for added, addedAt := range r.added {
+			if now.Sub(addedAt) >= r.expiry {
+				delete(r.added, added)
+			}
+		}
+		r.pruned = now
+	}
+
+	if addedAt, found := r.added[key]; found && now.Sub(addedAt) < r.expiry {
+		return false
+	}
+
+	r.added[key] = now
+
+	return true
+
+}
+
+// Posts are only seen again when the firehose replays them after reconnecting,
+// which is long over by the time they're forgotten
+var FIREHOSE_SEEN_POSTS = newRecentKeys(time.Hour)
+
+// triggerLimiter keeps track of when each community bot was invoked, so none
+// is invoked more often than it's allowed.
+type triggerLimiter struct {
+	mutex sync.Mutex
+	invocations map[string][]time.Time
+
+	// explained is who's been told why each bot won't reply
+	explained *recentKeys
+}
+
+var COMMUNITY_TRIGGER_LIMITER = &triggerLimiter{invocations: map[string][]time.Time{}, explained: newRecentKeys(time.Hour)}
+
+// allow records an invocation of the bot by trigger, unless it would take the
+// bot over botLimit in the last hour, or the trigger over its own limit.
+func (l *triggerLimiter) allow(bot botspec.Bot, trigger botspec.Trigger, botLimit int, now time.Time) bool {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	recent := func(key string) []time.Time {
+
+		kept := []time.Time{}
+		for _, invokedAt := range l.invocations[key] {
+			if now.Sub(invokedAt) < time.Hour {
+				kept = append(kept, invokedAt)
+			}
+		}
+
+		l.invocations[key] = kept
+		return kept
+
+	}
+
+	botKey := bot.Name
+	triggerKey := bot.Name + "/" + trigger.Type
+
+	if len(recent(botKey)) >= botLimit {
+		return false
+	}
+
+	if trigger.MaxPerHour > 0 && len(recent(triggerKey)) >= trigger.MaxPerHour {
+		return false
+	}
+
+	l.invocations[botKey] = append(l.invocations[botKey], now)
+	l.invocations[triggerKey] = append(recent(triggerKey), now)
+
+	return true
+
+}
+
+// explain records that user has been told why the bot won't reply, unless
+// they've already been told in the last hour, so a busy bot doesn't flood
+// anyone with explanations.
+func (l *triggerLimiter) explain(bot botspec.Bot, user string, now time.Time) bool {
+
+	return l.explained.add(bot.Name + "/" + user, now)
+
+}
+
+// jobQueue keeps track of each community bot's running and queued jobs, so
+// none runs more jobs at once than it's allowed.
+type jobQueue struct {
+	mutex sync.Mutex
+	bots map[string]*queuedJobs
+}
+
+type queuedJobs struct {
+	running int
+	waiting []chan struct{}
+}
+
+var COMMUNITY_JOB_QUEUE = &jobQueue{bots: map[string]*queuedJobs{}}
+
+// join returns a channel that's closed when one of the bot's jobs can start,
+// or false if the bot is running as many jobs as it can and its queue is full.
+// Every job that joins must call leave once it's finished.
+func (q *jobQueue) join(bot string, limits config.CommunityLimits) (chan struct{}, bool) {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	jobs, found := q.bots[bot]
+	if !found {
+		jobs = &queuedJobs{}
+		q.bots[bot] = jobs
+	}
+
+	turn := make(chan struct{})
+
+	switch {
+		case jobs.running < limits.MaxConcurrentJobs:
+			jobs.running++
+			close(turn)
+		case len(jobs.waiting) < limits.MaxQueuedJobs:
+			jobs.waiting = append(jobs.waiting, turn)
+			metrics.CommunityJobsQueued.WithLabelValues(bot).Inc()
+		default:
+			return nil, false
+	}
+
+	return turn, true
+
+}
+
+// leave makes room for the bot's next queued job. If the job given by turn
+// is still waiting, it's taken out of the queue instead.
+func (q *jobQueue) leave(bot string, turn chan struct{}, limits config.CommunityLimits) {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	jobs := q.bots[bot]
+
+	if index := slices.Index(jobs.waiting, turn); index >= 0 {
+		jobs.waiting = slices.Delete(jobs.waiting, index, index + 1)
+		metrics.CommunityJobsQueued.WithLabelValues(bot).Dec()
+		return
+	}
+
+	jobs.running--
+
+	for jobs.running < limits.MaxConcurrentJobs && len(jobs.waiting) > 0 {
+		close(jobs.waiting[0])
+		jobs.waiting = jobs.waiting[1:]
+		jobs.running++
+		metrics.CommunityJobsQueued.WithLabelValues(bot).Dec()
+	}
+
+}
+
+// authenticateAccount returns account's session, for triggers that don't come
+// from polling it. The session is shared with polling, so triggers don't sign
+// in each time.
+func authenticateAccount(account accounts.Account) (*bsky.Session, error) {
+
+	session, err := BLUESKY_SESSIONS.get(account, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate: %w", err)
+	}
+
+	if didErr := BOTS.Load().Accounts.SignedIn(account.Handle, session.Did); didErr != nil {
+		return nil, didErr
+	}
+
+	return session, nil
+
+}
+
+// dispatchCommunityEvent runs the bot for event in the background, once it has
+// room for another job, unless it's been invoked too often in the last hour or
+// too many of its jobs are already waiting. If session is nil, the bot's
+// account signs in first. span is ended once the bot has replied.
+func dispatchCommunityEvent(ctx context.Context, span trace.Span, logger *slog.Logger, account accounts.Account, session *bsky.Session, event communityEvent, bot botspec.Bot) {
+
+	span.SetAttributes(attribute.String("bbb.command", "community"), attribute.String("bbb.bot", bot.Name), attribute.String("bbb.trigger", event.Trigger.Type))
+
+	limits := BOTS.Load().communityLimits(bot.Name)
+
+	if !COMMUNITY_TRIGGER_LIMITER.allow(bot, event.Trigger, limits.MaxTriggersPerHour, time.Now()) {
+		logger.Warn("Community bot has been invoked too often in the last hour, so it won't be invoked this time")
+		metrics.CommunityTriggers.WithLabelValues(bot.Name, event.Trigger.Type, "rate_limited").Inc()
+		explainCommunityLimit(ctx, span, logger, session, event, bot, "I've been asked to do a lot in the last hour, so I'm taking a break. Please try again later!")
+		return
+	}
+
+	turn, joined := COMMUNITY_JOB_QUEUE.join(bot.Name, limits)
+
+	if !joined {
+		logger.Warn("Community bot is running as many jobs as it can, and its queue is full, so it won't be invoked this time", "max_concurrent_jobs", limits.MaxConcurrentJobs, "max_queued_jobs", limits.MaxQueuedJobs)
+		metrics.CommunityTriggers.WithLabelValues(bot.Name, event.Trigger.Type, "queue_full").Inc()
+		explainCommunityLimit(ctx, span, logger, session, event, bot, "I'm busy with lots of requests right now, so I can't get to this one. Please try again in a few minutes!")
+		return
+	}
+
+	metrics.CommunityTriggers.WithLabelValues(bot.Name, event.Trigger.Type, "dispatched").Inc()
+
+	go func() {
+
+		defer span.End()
+
+		select {
+			case <-turn:
+			case <-ctx.Done():
+				COMMUNITY_JOB_QUEUE.leave(bot.Name, turn, limits)
+				return
+		}
+
+		defer COMMUNITY_JOB_QUEUE.leave(bot.Name, turn, limits)
+
+		if session == nil {
+
+			var authErr error
+			session, authErr = authenticateAccount(account)
+
+			if authErr != nil {
+				tracing.RecordError(span, authErr)
+				logger.Error("Could not sign in to run community bot", "error", authErr)
+				return
+			}
+
+		}
+
+		startCommunityJob(ctx, logger, session, event, bot, limits, account.Handle)
+
+	}()
+
+}
+
+// explainCommunityLimit tells whoever invoked the bot why it won't reply, if
+// they asked it directly and haven't been told in the last hour. Nothing is
+// sent for keywords, follows and schedules, which no one is waiting on. span
+// is ended once the explanation has been sent.
+func explainCommunityLimit(ctx context.Context, span trace.Span, logger *slog.Logger, session *bsky.Session, event communityEvent, bot botspec.Bot, explanation string) {
+
+	user := event.Notif.Author.Did
+
+	switch event.Trigger.Type {
+		case botspec.TriggerMention, botspec.TriggerReply, botspec.TriggerQuote:
+		case botspec.TriggerDM:
+			user = event.Sender.Did
+		default:
+			span.End()
+			return
+	}
+
+	if session == nil || !COMMUNITY_TRIGGER_LIMITER.explain(bot, user, time.Now()) {
+		span.End()
+		return
+	}
+
+	go func() {
+		defer span.End()
+		replyToCommunityEvent(ctx, logger, session, event, []bsky.Draft{{Text: explanation}}, true)
+	}()
+
+}
+
+// communityInput describes event for the bot. parent and quoted are the posts
+// that a post trigger replied to and quoted, if they were fetched.
+func communityInput(accountName string, event communityEvent, parent, quoted *bsky.Post) botspec.Input {
+
+	switch event.Trigger.Type {
+		case botspec.TriggerDM:
+			return botspec.NewMessageInput(accountName, event.ConvoID, event.Message, event.Sender)
+		case botspec.TriggerFollow:
+			return botspec.NewFollowInput(accountName, event.Notif.Author)
+		case botspec.TriggerSchedule:
+			return botspec.NewScheduleInput(accountName, event.ScheduledAt)
+	}
+
+	input := botspec.NewInput(accountName, event.Trigger.Type, event.Notif, parent, quoted)
+	input.Keywords = event.Keywords
+
+	return input
+
+}
+
+// replyToCommunityEvent sends the bot's posts where they belong: as replies to
+// the post that invoked it, as messages in the conversation it was sent a
+// message in, or as posts of its own. Failure replies are only sent to someone
+// who's waiting for a reply.
+func replyToCommunityEvent(ctx context.Context, logger *slog.Logger, session *bsky.Session, event communityEvent, posts []bsky.Draft, failed bool) {
+
+	switch {
+		// Whoever used a keyword didn't ask the bot for anything
+		case failed && event.Trigger.Type == botspec.TriggerKeyword:
+			logger.Info("Community bot failed, and there's no one to tell", "trigger", event.Trigger.Type)
+		case event.Trigger.IsPost():
+			sendReplies(ctx, logger, session, event.Notif, posts)
+		case event.Trigger.Type == botspec.TriggerDM:
+			sendMessages(ctx, logger, session, event.ConvoID, posts)
+		case failed:
+			logger.Info("Community bot failed, and there's no one to tell", "trigger", event.Trigger.Type)
+		default:
+			publishPosts(ctx, logger, session, posts)
+	}
+
+}
+
+// sendMessages sends each post as a direct message. Messages can't have
+// images, so they're left out, and link cards are sent as their URL.
+func sendMessages(ctx context.Context, logger *slog.Logger, session *bsky.Session, convoID string, posts []bsky.Draft) {
+
+	_, span := tracing.Start(ctx, "bluesky.send_messages", attribute.Int("bluesky.messages", len(posts)))
+	defer span.End()
+
+	for _, post := range posts {
+
+		text := post.Text
+
+		if post.Link != nil {
+			text = strings.TrimSpace(text + "\n\n" + post.Link.Uri)
+		}
+
+		if len(post.Images) > 0 {
+			logger.Warn("Direct messages can't include images, so they were left out", "images", len(post.Images))
+		}
+
+		if text == "" {
+			continue
+		}
+
+		logger.Debug("Sending message", "text", text)
+
+		if accountSettings(session).DryRun {
+			continue
+		}
+
+		if _, err := bsky.SendMessage(session.AccessJwt, convoID, text); err != nil {
+			tracing.RecordError(span, err)
+			logger.Error("Could not send message", "error", err)
+			return
+		}
+
+	}
+
+	logger.Info("Sent messages", "convo", convoID, "messages", len(posts))
+
+}
+
+// publishPosts makes the bot's posts on its own account, as a thread.
+func publishPosts(ctx context.Context, logger *slog.Logger, session *bsky.Session, posts []bsky.Draft) {
+
+	_, span := tracing.Start(ctx, "bluesky.post", attribute.Int("bluesky.posts", len(posts)))
+	defer span.End()
+
+	var (
+		postUris []string
+		err      error
+	)
+
+	if !accountSettings(session).DryRun {
+		postUris, err = bsky.PostThread(session.AccessJwt, posts, session.Did)
+		if err != nil {
+			tracing.RecordError(span, err)
+			logger.Error("Could not post", "error", err, "posted", len(postUris))
+			return
+		}
+	} else {
+		postUris = []string{"DRY_RUN_URI"}
+	}
+
+	logger.Info("Posted", "post", postUris[0], "posts", len(postUris))
+
+}
+
+// handleCommunityNotifications invokes the bot for each new notification that
+// it has a trigger for.
+func handleCommunityNotifications(pollCtx context.Context, accountLogger *slog.Logger, session *bsky.Session, notifications []bsky.Notification, bot botspec.Bot, account accounts.Account) {
+
+	for _, notif := range notifications {
+
+		trigger, triggered := bot.Trigger(notificationTriggers[notif.Reason])
+
+		// Bots aren't invoked by their own posts
+		if !triggered || notif.Author.Did == session.Did || !bsky.ShouldRespond(notif) || bsky.HasResponded(notif.Uri) {
+			continue
+		}
+
+		ctx, span := tracing.StartMention(pollCtx, notif.Uri)
+
+		logger := mentionLogger(ctx, accountLogger, notif, "community").With("bot", bot.Name, "trigger", trigger.Type)
+
+		dispatchCommunityEvent(ctx, span, logger, account, session, communityEvent{Trigger: trigger, Notif: notif}, bot)
+
+		bsky.RecordResponse(notif.Uri)
+
+	}
+
+}
+
+// handleCommunityMessages invokes the bot for each new direct message to it,
+// if it has a dm trigger, and marks them as read.
+func handleCommunityMessages(pollCtx context.Context, accountLogger *slog.Logger, session *bsky.Session, bot botspec.Bot, account accounts.Account) {
+
+	trigger, triggered := bot.Trigger(botspec.TriggerDM)
+	if !triggered {
+		return
+	}
+
+	_, fetchSpan := tracing.Start(pollCtx, "bluesky.fetch_conversations")
+	convos, err := bsky.ListUnreadConvos(session.AccessJwt)
+	tracing.End(fetchSpan, err)
+
+	if err != nil {
+		accountLogger.Error("Could not fetch direct messages. Check the account's app password can access them.", "error", err)
+		return
+	}
+
+	for _, convo := range convos {
+
+		messages, err := bsky.GetMessages(session.AccessJwt, convo.Id, min(max(convo.UnreadCount, 1), MAX_MESSAGES_PER_CONVO))
+		if err != nil {
+			accountLogger.Error("Could not fetch direct messages", "convo", convo.Id, "error", err)
+			continue
+		}
+
+		// Messages come newest first, but are answered in the order they were sent
+		for index := len(messages) - 1; index >= 0; index-- {
+
+			message := messages[index]
+			key := fmt.Sprintf("dm:%s/%s", convo.Id, message.Id)
+
+			if message.Sender.Did == session.Did || bsky.HasResponded(key) {
+				continue
+			}
+
+			if sentAt, parseErr := time.Parse(time.RFC3339, message.SentAt); parseErr != nil || sentAt.Before(bsky.StartTime) {
+				continue
+			}
+
+			sender, _ := convo.Member(message.Sender.Did)
+
+			ctx, span := tracing.StartTrigger(pollCtx, botspec.TriggerDM, key)
+
+			logger := accountLogger.With("convo", convo.Id, "message", message.Id, "command", "community", "bot", bot.Name, "trigger", trigger.Type)
+			if traceID := tracing.TraceID(ctx); traceID != "" {
+				logger = logger.With("trace_id", traceID)
+			}
+
+			event := communityEvent{
+				Trigger: trigger,
+				ConvoID: convo.Id,
+				Message: message,
+				Sender: sender,
+			}
+
+			dispatchCommunityEvent(ctx, span, logger, account, session, event, bot)
+
+			bsky.RecordResponse(key)
+
+		}
+
+		if !accountSettings(session).DryRun {
+			if readErr := bsky.MarkConvoRead(session.AccessJwt, convo.Id); readErr != nil {
+				accountLogger.Warn("Could not mark direct messages as read", "convo", convo.Id, "error", readErr)
+			}
+		}
+
+	}
+
+}
+
+// keywordBots returns the bots with a keyword trigger in registry.
+func keywordBots(registry *BotRegistry) []botspec.Bot {
+
+	bots := []botspec.Bot{}
+
+	for _, bot := range registry.CommunityBots {
+		if _, triggered := bot.Trigger(botspec.TriggerKeyword); triggered {
+			bots = append(bots, bot)
+		}
+	}
+
+	return bots
+
+}
+
+// watchFirehose reads every new post on Bluesky while any community bot has a
+// keyword trigger, and invokes each bot whose keywords are in it. If the
+// connection drops, it picks up from the last post it saw.
+func watchFirehose() {
+
+	var cursor int64
+
+	for {
+
+		if len(keywordBots(BOTS.Load())) == 0 {
+			cursor = 0
+			health.Unregister("firehose")
+			time.Sleep(FIREHOSE_RETRY_INTERVAL)
+			continue
+		}
+
+		health.Register("firehose", "", false)
+
+		ctx, stop := context.WithCancel(context.Background())
+
+		slog.Info("Reading the firehose for keyword triggers", "url", CONFIG.Community.FirehoseURL)
+
+		var err error
+		cursor, err = firehose.Stream(ctx, CONFIG.Community.FirehoseURL, cursor, func(post firehose.Post) {
+
+			registry := BOTS.Load()
+
+			// Stop reading once no bot needs to
+			bots := keywordBots(registry)
+			if len(bots) == 0 {
+				stop()
+				return
+			}
+
+			handleFirehosePost(registry, bots, post)
+
+		})
+
+		stop()
+
+		if errors.Is(err, context.Canceled) {
+			continue
+		}
+
+		health.Report("firehose", err)
+		slog.Error("Stopped reading the firehose. Reconnecting soon.", "error", err)
+
+		time.Sleep(FIREHOSE_RETRY_INTERVAL)
+
+	}
+
+}
+
+// handleFirehosePost invokes each of bots whose keywords are in post. Posts by
+// the bot network's own accounts are ignored, so bots can't set each other off.
+func handleFirehosePost(registry *BotRegistry, bots []botspec.Bot, post firehose.Post) {
+
+	if _, isOwnAccount := registry.Accounts.ForDID(post.Did); isOwnAccount {
+		return
+	}
+
+	for _, bot := range bots {
+
+		trigger, _ := bot.Trigger(botspec.TriggerKeyword)
+
+		matched := trigger.Matches(post.Record.Text)
+		if len(matched) == 0 {
+			continue
+		}
+
+		account, found := registry.Accounts.ForBot(bot.Name)
+		if !found {
+			continue
+		}
+
+		// More than one bot can be invoked by the same post
+		key := fmt.Sprintf("%s#%s", post.Uri, bot.Name)
+		if !FIREHOSE_SEEN_POSTS.add(key, time.Now()) {
+			continue
+		}
+
+		notif := bsky.ProcessNotifications([]bsky.Notification{{
+			Uri: post.Uri,
+			Cid: post.Cid,
+			Reason: botspec.TriggerKeyword,
+			Author: bsky.Author{Did: post.Did},
+			Record: post.Record,
+		}})[0]
+
+		ctx, span := tracing.StartMention(context.Background(), post.Uri)
+
+		logger := mentionLogger(ctx, slog.With("account", account.Handle, "behaviour", account.Behaviour), notif, "community").With("bot", bot.Name, "trigger", trigger.Type, "keywords", matched)
+
+		dispatchCommunityEvent(ctx, span, logger, account, nil, communityEvent{Trigger: trigger, Notif: notif, Keywords: matched}, bot)
+
+	}
+
+}
+
+// runCommunitySchedules invokes bots with a schedule trigger when they're due.
+// Schedules are checked at the start of every minute, the smallest step a cron
+// expression has, and read from the live registry, so reloads apply at once.
+func runCommunitySchedules() {
+
+	checked := time.Now().Truncate(time.Minute)
+
+	for {
+
+		time.Sleep(time.Until(checked.Add(time.Minute)))
+
+		now := time.Now()
+		registry := BOTS.Load()
+
+		for _, bot := range registry.CommunityBots {
+
+			trigger, triggered := bot.Trigger(botspec.TriggerSchedule)
+			if !triggered {
+				continue
+			}
+
+			due, err := trigger.Next(checked)
+			if err != nil || due.After(now) {
+				continue
+			}
+
+			account, found := registry.Accounts.ForBot(bot.Name)
+			if !found {
+				continue
+			}
+
+			ctx, span := tracing.StartTrigger(context.Background(), botspec.TriggerSchedule, bot.Name)
+
+			logger := slog.With("account", account.Handle, "behaviour", account.Behaviour, "command", "community", "bot", bot.Name, "trigger", trigger.Type, "scheduled_at", due)
+			if traceID := tracing.TraceID(ctx); traceID != "" {
+				logger = logger.With("trace_id", traceID)
+			}
+
+			dispatchCommunityEvent(ctx, span, logger, account, nil, communityEvent{Trigger: trigger, ScheduledAt: due}, bot)
+
+		}
+
+		checked = now.Truncate(time.Minute)
+
+	}
+
+}
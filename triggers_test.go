@@ -0,0 +1,205 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bbb/botspec"
+	"bbb/config"
+)
+
+func newTestLimiter() *triggerLimiter {
+	return &triggerLimiter{invocations: map[string][]time.Time{}, explained: newRecentKeys(time.Hour)}
+}
+
+func TestTriggerLimiterAllow(t *testing.T) {
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	bot := botspec.Bot{Name: "calculator"}
+
+	mention := botspec.Trigger{Type: botspec.TriggerMention}
+	keyword := botspec.Trigger{Type: botspec.TriggerKeyword, MaxPerHour: 2}
+
+	type invocation struct {
+		trigger botspec.Trigger
+		after time.Duration
+		want bool
+	}
+
+	tests := []struct {
+		name string
+		botLimit int
+		invocations []invocation
+	}{
+		{"under the bot's limit", 3, []invocation{
+			{mention, 0, true},
+			{mention, time.Minute, true},
+			{mention, 2 * time.Minute, true},
+		}},
+		{"over the bot's limit", 2, []invocation{
+			{mention, 0, true},
+			{mention, time.Minute, true},
+			{mention, 2 * time.Minute, false},
+		}},
+		{"the limit is for the last hour", 2, []invocation{
+			{mention, 0, true},
+			{mention, time.Minute, true},
+			{mention, time.Hour, true},
+			{mention, time.Hour + 30 * time.Second, false},
+			{mention, time.Hour + time.Minute, true},
+		}},
+		{"over the trigger's limit", 10, []invocation{
+			{keyword, 0, true},
+			{keyword, time.Minute, true},
+			{keyword, 2 * time.Minute, false},
+			{mention, 3 * time.Minute, true},
+		}},
+		{"every trigger counts towards the bot's limit", 2, []invocation{
+			{keyword, 0, true},
+			{mention, time.Minute, true},
+			{mention, 2 * time.Minute, false},
+		}},
+		{"refused invocations don't count", 10, []invocation{
+			{keyword, 0, true},
+			{keyword, time.Minute, true},
+			{keyword, 2 * time.Minute, false},
+			{keyword, time.Hour, true},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			limiter := newTestLimiter()
+
+			for index, invocation := range test.invocations {
+				if got := limiter.allow(bot, invocation.trigger, test.botLimit, start.Add(invocation.after)); got != invocation.want {
+					t.Errorf("invocation %d by %s after %v: got %v, want %v", index, invocation.trigger.Type, invocation.after, got, invocation.want)
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestTriggerLimiterExplain(t *testing.T) {
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter()
+
+	calculator, poet := botspec.Bot{Name: "calculator"}, botspec.Bot{Name: "poet"}
+
+	tests := []struct {
+		name string
+		bot botspec.Bot
+		user string
+		after time.Duration
+		want bool
+	}{
+		{"first time", calculator, "did:plc:alice", 0, true},
+		{"again", calculator, "did:plc:alice", time.Minute, false},
+		{"another user", calculator, "did:plc:bob", time.Minute, true},
+		{"another bot", poet, "did:plc:alice", time.Minute, true},
+		{"an hour later", calculator, "did:plc:alice", time.Hour, true},
+	}
+
+	for _, test := range tests {
+		if got := limiter.explain(test.bot, test.user, start.Add(test.after)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+}
+
+func TestRecentKeys(t *testing.T) {
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	keys := newRecentKeys(time.Hour)
+
+	if !keys.add("at://post#calculator", start) {
+		t.Error("a new key wasn't added")
+	}
+
+	if keys.add("at://post#calculator", start.Add(59 * time.Minute)) {
+		t.Error("a key was added twice within the expiry")
+	}
+
+	if !keys.add("at://post#poet", start.Add(30 * time.Minute)) {
+		t.Error("a different key wasn't added")
+	}
+
+	if !keys.add("at://post#calculator", start.Add(time.Hour)) {
+		t.Error("an expired key wasn't added again")
+	}
+
+	// Keys are forgotten once they expire, so the set doesn't keep growing
+	keys.add("at://other", start.Add(2 * time.Hour))
+
+	if len(keys.added) != 1 {
+		t.Errorf("expired keys were kept: %v", keys.added)
+	}
+
+}
+
+func TestJobQueue(t *testing.T) {
+
+	limits := config.CommunityLimits{MaxConcurrentJobs: 2, MaxQueuedJobs: 1}
+	queue := &jobQueue{bots: map[string]*queuedJobs{}}
+
+	isClosed := func(turn chan struct{}) bool {
+		select {
+			case <-turn:
+				return true
+			default:
+				return false
+		}
+	}
+
+	first, joined := queue.join("calculator", limits)
+	if !joined || !isClosed(first) {
+		t.Fatal("the first job didn't start")
+	}
+
+	second, joined := queue.join("calculator", limits)
+	if !joined || !isClosed(second) {
+		t.Fatal("the second job didn't start")
+	}
+
+	third, joined := queue.join("calculator", limits)
+	if !joined || isClosed(third) {
+		t.Fatal("the third job wasn't queued")
+	}
+
+	if _, joined := queue.join("calculator", limits); joined {
+		t.Fatal("a job joined a full queue")
+	}
+
+	// Other bots have their own queues
+	if other, joined := queue.join("poet", limits); !joined || !isClosed(other) {
+		t.Fatal("another bot's job didn't start")
+	}
+
+	queue.leave("calculator", first, limits)
+
+	if !isClosed(third) {
+		t.Fatal("the queued job didn't start when a running one finished")
+	}
+
+	fourth, joined := queue.join("calculator", limits)
+	if !joined || isClosed(fourth) {
+		t.Fatal("the fourth job wasn't queued")
+	}
+
+	// A job that gives up waiting makes room in the queue
+	queue.leave("calculator", fourth, limits)
+
+	if _, joined := queue.join("calculator", limits); !joined {
+		t.Fatal("the queue didn't have room after a job left it")
+	}
+
+	if jobs := queue.bots["calculator"]; jobs.running != 2 || len(jobs.waiting) != 1 {
+		t.Errorf("got %d running and %d waiting, want 2 and 1", jobs.running, len(jobs.waiting))
+	}
+
+}